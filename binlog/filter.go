@@ -0,0 +1,137 @@
+package binlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// FilterPCAP copies the records of input that carry a frame from any of
+// tagIDs to output, returning how many such records were written. The
+// global header and anchor/tag blocks are copied verbatim, so the result
+// parses like the original but for one tag. Frames are matched by their
+// decoded inner address; records that do not decode are dropped. Only
+// little-endian classic pcap is filtered; pcapng and big-endian input are
+// rejected before output is created.
+func FilterPCAP(input, output string, tagIDs []uint32) (n int, err error) {
+	if len(tagIDs) == 0 {
+		return 0, fmt.Errorf("no tag ids")
+	}
+	want := make(map[uint32]bool, len(tagIDs))
+	for _, id := range tagIDs {
+		want[id] = true
+	}
+
+	in, err := OpenPcap(input)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	r := bufio.NewReader(in)
+	hdr := make([]byte, pcapGlobalLen)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return 0, fmt.Errorf("%s: pcap header: %w", input, err)
+	}
+	if err := checkPcapMagic(input, hdr); err != nil {
+		return 0, err
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(out)
+	if _, err := w.Write(hdr); err != nil {
+		out.Close()
+		return 0, err
+	}
+
+	p := &BinlogParser{}
+	rec := make([]byte, pcapRecordLen)
+	for {
+		if _, err := io.ReadFull(r, rec); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				out.Close()
+				return n, fmt.Errorf("%s: pcap record: %w", input, err)
+			}
+			break
+		}
+		data := make([]byte, binary.LittleEndian.Uint32(rec[8:12]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				out.Close()
+				return n, fmt.Errorf("%s: pcap payload: %w", input, err)
+			}
+			break
+		}
+		if len(data) < phdr2Len {
+			continue
+		}
+		keep := false
+		switch flag := binary.LittleEndian.Uint16(data[0:2]); flag {
+		case flagAnchor, flagTag:
+			keep = true
+		case flagStats:
+		default:
+			if p.recordHasTag(flag, data[phdr2Len:], want) {
+				keep = true
+				n++
+			}
+		}
+		if !keep {
+			continue
+		}
+		if _, err := w.Write(rec); err != nil {
+			out.Close()
+			return n, err
+		}
+		if _, err := w.Write(data); err != nil {
+			out.Close()
+			return n, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return n, err
+	}
+	return n, out.Close()
+}
+
+// recordHasTag reports whether a data record's UNIB frame carries an inner
+// frame addressed to one of the wanted tags.
+func (p *BinlogParser) recordHasTag(flag uint16, payload []byte, want map[uint32]bool) bool {
+	_, _, payload, err := SplitPhdr2IPv6(flag, payload)
+	if err != nil || len(payload) < unibWrapLen || binary.LittleEndian.Uint16(payload[0:2]) != unibMagic {
+		return false
+	}
+	unib, err := parseUnib(payload, 0)
+	if err != nil {
+		return false
+	}
+	inner, err := p.decodeOuter(unib)
+	if err != nil {
+		return false
+	}
+	for _, in := range inner {
+		if want[in.Addr] {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPcapMagic rejects a global header read from path unless it is
+// little-endian classic pcap, the only layout the copying tools write back.
+func checkPcapMagic(path string, hdr []byte) error {
+	switch magic := binary.LittleEndian.Uint32(hdr[0:4]); magic {
+	case pcapMagic:
+		return nil
+	case pcapngSHB:
+		return fmt.Errorf("%s: pcapng input is not supported, convert it to classic pcap", path)
+	default:
+		return fmt.Errorf("%s: pcap header: unsupported magic 0x%08X (want little-endian 0x%08X)", path, magic, uint32(pcapMagic))
+	}
+}
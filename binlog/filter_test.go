@@ -0,0 +1,81 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilterPCAP(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.pcap")
+	pw, err := NewPcapWriter(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.WriteBlocks(time.Unix(1700000000, 0), []AnchorInfo{{AnchorID: 0x1A2B3C, X: 1, Y: 2, Z: 3}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 9000}
+	for i, tag := range []uint32{0xB50AC, 0xB50AD, 0xB50AC} {
+		inner, err := EncodeInner(InnerFrame{Addr: tag, Type: 0x50, Samples: []Sample{{AnchorID: 0x1A2B3C, RangeM: 3.21}}}, uint8(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkt, err := BuildRawUp(0x5A5A, tag, -60, inner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pw.WritePacketAt(time.Unix(1700000001+int64(i), 0), 0x109, addr, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "out.pcap")
+	n, err := FilterPCAP(input, output, []uint32{0xB50AC})
+	if err != nil || n != 2 {
+		t.Fatalf("FilterPCAP wrote %d records, %v; want 2", n, err)
+	}
+	p := NewBinlogParser(output)
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Events) != 2 || len(p.Anchors) != 1 {
+		t.Errorf("filtered file has %d events, %d anchors, want 2 and 1", len(p.Events), len(p.Anchors))
+	}
+}
+
+func TestFilterPCAPRejectsPcapng(t *testing.T) {
+	dir := t.TempDir()
+	// A bare little-endian section header block.
+	shb := make([]byte, 28)
+	binary.LittleEndian.PutUint32(shb[0:], pcapngSHB)
+	binary.LittleEndian.PutUint32(shb[4:], 28)
+	binary.LittleEndian.PutUint32(shb[8:], pcapngByteOrder)
+	binary.LittleEndian.PutUint16(shb[12:], 1)
+	binary.LittleEndian.PutUint64(shb[16:], ^uint64(0))
+	binary.LittleEndian.PutUint32(shb[24:], 28)
+
+	big := make([]byte, pcapGlobalLen)
+	binary.BigEndian.PutUint32(big[0:], pcapMagic)
+
+	for name, content := range map[string][]byte{"in.pcapng": shb, "big.pcap": big} {
+		input := filepath.Join(dir, name)
+		if err := os.WriteFile(input, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		output := filepath.Join(dir, name+".out")
+		if _, err := FilterPCAP(input, output, []uint32{0xB50AC}); err == nil {
+			t.Errorf("%s: filtered without error", name)
+		}
+		if _, err := os.Stat(output); !os.IsNotExist(err) {
+			t.Errorf("%s: output created for rejected input", name)
+		}
+	}
+}
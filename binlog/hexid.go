@@ -0,0 +1,14 @@
+package binlog
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseHexID parses a tag or anchor ID given in hex, as on the command line
+// or in a request, with or without a 0x prefix.
+func ParseHexID(s string) (int, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "0X")
+	v, err := strconv.ParseInt(s, 16, 64)
+	return int(v), err
+}
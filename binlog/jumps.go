@@ -0,0 +1,38 @@
+package binlog
+
+// DefaultJumpThreshold is the backward record time step, in seconds, that
+// Parse counts as a clock jump (see FindTimestampJumps).
+const DefaultJumpThreshold = 1.0
+
+// ForwardJumpGap is the forward record time step, in seconds, above which
+// FindTimestampJumps reports a jump. It matches fusion.ResetGap, past which
+// the filters reset anyway; shorter forward gaps are ordinary idle time.
+const ForwardJumpGap = 30.0
+
+// TimestampJump is a discontinuity in the capture's record times, such as a
+// gateway reboot or an NTP correction: Events[EventIndex] has timestamp
+// After while the event before it has Before (both in seconds).
+type TimestampJump struct {
+	EventIndex int
+	Before     float64
+	After      float64
+}
+
+// FindTimestampJumps lists, in capture order, where an event's time steps
+// back by more than threshold seconds from the event before it, or forward
+// by more than ForwardJumpGap (or threshold, if larger). threshold <= 0
+// means DefaultJumpThreshold.
+func (p *BinlogParser) FindTimestampJumps(threshold float64) []TimestampJump {
+	if threshold <= 0 {
+		threshold = DefaultJumpThreshold
+	}
+	forward := max(threshold, ForwardJumpGap)
+	var jumps []TimestampJump
+	for i := 1; i < len(p.Events); i++ {
+		before, after := p.Events[i-1].Timestamp, p.Events[i].Timestamp
+		if before-after > threshold || after-before > forward {
+			jumps = append(jumps, TimestampJump{EventIndex: i, Before: before, After: after})
+		}
+	}
+	return jumps
+}
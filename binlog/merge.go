@@ -0,0 +1,261 @@
+package binlog
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// mergeReadAhead is how many records each input may buffer ahead of the
+// merge, which bounds memory independently of file size.
+const mergeReadAhead = 256
+
+type mergeRecord struct {
+	tsSec, tsUsec uint32
+	data          []byte // phdr2 + payload
+}
+
+type mergeSource struct {
+	idx  int
+	path string
+	ch   chan mergeRecord
+	err  error // valid once ch is closed
+	head mergeRecord
+}
+
+// read streams the records of one file into ch until EOF or error.
+func (s *mergeSource) read(r io.Reader) {
+	defer close(s.ch)
+	rec := make([]byte, pcapRecordLen)
+	for {
+		if _, err := io.ReadFull(r, rec); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				s.err = fmt.Errorf("%s: pcap record: %w", s.path, err)
+			}
+			return
+		}
+		inclLen := binary.LittleEndian.Uint32(rec[8:12])
+		data := make([]byte, inclLen)
+		if _, err := io.ReadFull(r, data); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				s.err = fmt.Errorf("%s: pcap payload: %w", s.path, err)
+			}
+			return
+		}
+		if inclLen < phdr2Len {
+			continue
+		}
+		s.ch <- mergeRecord{
+			tsSec:  binary.LittleEndian.Uint32(rec[0:4]),
+			tsUsec: binary.LittleEndian.Uint32(rec[4:8]),
+			data:   data,
+		}
+	}
+}
+
+// mergeHeap orders sources by the timestamp of their head record, then by
+// input order so ties keep a stable order.
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	a, b := h[i].head, h[j].head
+	if a.tsSec != b.tsSec {
+		return a.tsSec < b.tsSec
+	}
+	if a.tsUsec != b.tsUsec {
+		return a.tsUsec < b.tsUsec
+	}
+	return h[i].idx < h[j].idx
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// MergePCAPs merges the records of several pcap files into output, sorted by
+// record timestamp. The global header and the leading anchor/tag blocks of
+// the first input are written first; anchors already written are dropped
+// from later anchor blocks. Inputs are read concurrently and merged as
+// streams, so memory does not grow with file size. Every input must be
+// little-endian classic pcap; otherwise nothing is written.
+func MergePCAPs(inputs []string, output string) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("no input files")
+	}
+
+	files := make([]io.ReadCloser, 0, len(inputs))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	readers := make([]*bufio.Reader, 0, len(inputs))
+	var globalHdr []byte
+	for i, path := range inputs {
+		f, err := OpenPcap(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		hdr := make([]byte, pcapGlobalLen)
+		if _, err := io.ReadFull(f, hdr); err != nil {
+			return fmt.Errorf("%s: pcap header: %w", path, err)
+		}
+		if err := checkPcapMagic(path, hdr); err != nil {
+			return err
+		}
+		if i == 0 {
+			globalHdr = hdr
+		}
+		readers = append(readers, bufio.NewReader(f))
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	if _, err := w.Write(globalHdr); err != nil {
+		out.Close()
+		return err
+	}
+
+	m := &pcapMerger{w: w, seenAnchors: map[uint64]bool{}}
+
+	sources := make([]*mergeSource, len(inputs))
+	defer func() {
+		// Unblock readers left behind by an early return.
+		for _, s := range sources {
+			if s != nil {
+				go func(s *mergeSource) {
+					for range s.ch {
+					}
+				}(s)
+			}
+		}
+	}()
+	for i := range inputs {
+		sources[i] = &mergeSource{idx: i, path: inputs[i], ch: make(chan mergeRecord, mergeReadAhead)}
+		go sources[i].read(readers[i])
+	}
+
+	// Leading metadata of the first input goes out before anything else.
+	h := &mergeHeap{}
+	first := sources[0]
+	for rec := range first.ch {
+		if flag := binary.LittleEndian.Uint16(rec.data[0:2]); flag == flagAnchor || flag == flagTag {
+			if err := m.write(rec); err != nil {
+				out.Close()
+				return err
+			}
+			continue
+		}
+		first.head = rec
+		heap.Push(h, first)
+		break
+	}
+	for _, s := range sources[1:] {
+		if rec, ok := <-s.ch; ok {
+			s.head = rec
+			heap.Push(h, s)
+		}
+	}
+
+	for h.Len() > 0 {
+		s := (*h)[0]
+		if err := m.write(s.head); err != nil {
+			out.Close()
+			return err
+		}
+		if rec, ok := <-s.ch; ok {
+			s.head = rec
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	for _, s := range sources {
+		// Drain in case the merge stopped early, then report read errors.
+		for range s.ch {
+		}
+		if s.err != nil {
+			out.Close()
+			return s.err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+type pcapMerger struct {
+	w           io.Writer
+	seenAnchors map[uint64]bool
+	hdr         [pcapRecordLen]byte
+}
+
+// write emits one record, removing already-written anchors from anchor
+// blocks. Blocks left empty are dropped.
+func (m *pcapMerger) write(rec mergeRecord) error {
+	data := rec.data
+	if binary.LittleEndian.Uint16(data[0:2]) == flagAnchor {
+		data = m.dedupAnchors(data)
+		if data == nil {
+			return nil
+		}
+	}
+	binary.LittleEndian.PutUint32(m.hdr[0:], rec.tsSec)
+	binary.LittleEndian.PutUint32(m.hdr[4:], rec.tsUsec)
+	binary.LittleEndian.PutUint32(m.hdr[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(m.hdr[12:], uint32(len(data)))
+	if _, err := m.w.Write(m.hdr[:]); err != nil {
+		return err
+	}
+	_, err := m.w.Write(data)
+	return err
+}
+
+// dedupAnchors rebuilds an anchor block (phdr2 port = item count, ip = item
+// size) without anchors seen before. It returns nil if none are left.
+func (m *pcapMerger) dedupAnchors(data []byte) []byte {
+	itemnum := int(binary.LittleEndian.Uint16(data[2:4]))
+	itemsize := int(binary.LittleEndian.Uint32(data[4:8]))
+	payload := data[phdr2Len:]
+	if itemsize < 8 {
+		return data
+	}
+	out := make([]byte, phdr2Len, len(data))
+	copy(out, data[:phdr2Len])
+	kept := 0
+	for i := 0; i < itemnum; i++ {
+		start := i * itemsize
+		end := start + itemsize
+		if end > len(payload) {
+			break
+		}
+		id := binary.LittleEndian.Uint64(payload[start : start+8])
+		if m.seenAnchors[id] {
+			continue
+		}
+		m.seenAnchors[id] = true
+		out = append(out, payload[start:end]...)
+		kept++
+	}
+	if kept == 0 {
+		return nil
+	}
+	binary.LittleEndian.PutUint16(out[2:4], uint16(kept))
+	return out
+}
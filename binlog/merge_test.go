@@ -0,0 +1,47 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergePCAPsRejectsForeignInput(t *testing.T) {
+	dir := t.TempDir()
+	good := make([]byte, pcapGlobalLen)
+	binary.LittleEndian.PutUint32(good[0:], pcapMagic)
+	big := make([]byte, pcapGlobalLen)
+	binary.BigEndian.PutUint32(big[0:], pcapMagic)
+	shb := make([]byte, pcapGlobalLen)
+	binary.LittleEndian.PutUint32(shb[0:], pcapngSHB)
+
+	write := func(name string, content []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	first := write("good.pcap", good)
+	for name, content := range map[string][]byte{"in.pcapng": shb, "big.pcap": big} {
+		bad := write(name, content)
+		output := filepath.Join(dir, name+".out")
+		err := MergePCAPs([]string{first, bad}, output)
+		if err == nil || !strings.Contains(err.Error(), bad) {
+			t.Errorf("%s: MergePCAPs error %v, want one naming the input", name, err)
+		}
+		if _, err := os.Stat(output); !os.IsNotExist(err) {
+			t.Errorf("%s: output created for rejected input", name)
+		}
+	}
+
+	output := filepath.Join(dir, "merged.pcap")
+	if err := MergePCAPs([]string{first, first}, output); err != nil {
+		t.Fatalf("MergePCAPs: %v", err)
+	}
+	if got, _ := os.ReadFile(output); string(got) != string(good) {
+		t.Errorf("merged %d bytes, want the first input's header only", len(got))
+	}
+}
@@ -0,0 +1,61 @@
+package binlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+// gzipMagic is the two-byte header of a gzip stream.
+var gzipMagic = [2]byte{0x1f, 0x8b}
+
+type pcapFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (pf *pcapFile) Close() error {
+	var first error
+	for _, c := range pf.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// OpenPcap opens a pcap file for reading. Files ending in .gz or starting
+// with the gzip magic bytes are decompressed transparently.
+func OpenPcap(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	if !strings.HasSuffix(path, ".gz") && !hasGzipMagic(br) {
+		return &pcapFile{Reader: br, closers: []io.Closer{f}}, nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &pcapFile{Reader: gz, closers: []io.Closer{gz, f}}, nil
+}
+
+// maybeGunzip wraps r in a gzip reader when the stream starts with the gzip
+// magic bytes.
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if !hasGzipMagic(br) {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
+
+func hasGzipMagic(br *bufio.Reader) bool {
+	b, err := br.Peek(2)
+	return err == nil && b[0] == gzipMagic[0] && b[1] == gzipMagic[1]
+}
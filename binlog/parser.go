@@ -6,7 +6,7 @@ import (
     "fmt"
     "io"
     "math"
-    "os"
+    "sort"
 )
 
 const (
@@ -18,6 +18,9 @@ const (
     flagTag    = 0x08
     flagStats  = 0x10
 
+    pcapMagic        = 0xA1B2C3D4
+    pcapMagicSwapped = 0xD4C3B2A1
+
     unibMagic    = 0x7857
     unibHdrLen   = 9
     unibWrapLen  = 11
@@ -41,11 +44,16 @@ type Sample struct {
     AnchorID int
     RSSIDb   int
     RangeM   float64
+    Seq      uint8 // sequence number of the inner frame carrying the sample
 }
 
 type IMUSample struct {
-    Distance float64
-    YawDeg   float64
+    Distance     float64
+    YawDeg       float64
+    SpeedMps     float64
+    MotionCode   int
+    YawSigmaCode int
+    DsSigmaCode  int
 }
 
 type InnerFrame struct {
@@ -53,37 +61,135 @@ type InnerFrame struct {
     Type    uint8
     Samples []Sample
     IMU     *IMUSample
+    CRCBad  bool // decoded despite a CRC mismatch (LenientCRC)
+
+    // SecondsPrefix is the raw seconds-prefix byte of frames sent with
+    // secondsFlag, nil without one. The protocol does not define its unit,
+    // so it is not applied: every frame is timed by its Event.Timestamp,
+    // frames a gateway batched into one packet included. Using it as the
+    // measurement time is deferred until the firmware specifies the unit.
+    SecondsPrefix *uint8
 }
 
+// Event is one gateway packet. Timestamp is the pcap record time in
+// seconds, i.e. when the packet reached the server; it is also the
+// measurement time of all of Inner (see InnerFrame.SecondsPrefix).
 type Event struct {
     Timestamp float64
     Inner     []InnerFrame
 }
 
+// PcapHeader is the classic pcap global header as read from the file.
+// Magic is reported as read in little-endian order, so a big-endian capture
+// shows pcapMagicSwapped.
+type PcapHeader struct {
+    Magic        uint32
+    MajorVersion uint16
+    MinorVersion uint16
+    Zone         int32
+    Accuracy     uint32
+    SnapLen      uint32
+    LinkType     uint32
+}
+
 type BinlogParser struct {
     Path string
     VerifyCRC bool
 
+    // Header is filled by Parse for classic pcap input.
+    Header PcapHeader
+    // LenientCRC decodes frames whose CRC does not match instead of
+    // dropping them; such frames are marked CRCBad.
+    LenientCRC bool
+
+    // CRC outcome counts over all UNIB frames checked (outer and inner).
+    CRCOk     int
+    CRCFailed int
+
+    // TimestampJumps is set by Parse to the number of clock jumps in the
+    // events at DefaultJumpThreshold (see FindTimestampJumps).
+    TimestampJumps int
+
     Anchors []AnchorInfo
     Tags    []TagHeight
     Events  []Event
+
+    r io.Reader
+    // window, when set, limits the events kept to record times in
+    // [window[0], window[1]] seconds (see ParseTimeRange).
+    window *[2]float64
+    // order is the byte order of the capture's own structures (record
+    // headers, phdr2, anchor/tag blocks); UNIB frames are always
+    // little-endian.
+    order binary.ByteOrder
 }
 
+// readerPath is the Path reported by parsers built from an io.Reader.
+const readerPath = "<reader>"
+
+// NewBinlogParser returns a parser for the pcap file at path. The file is
+// opened by Parse.
 func NewBinlogParser(path string) *BinlogParser {
     return &BinlogParser{Path: path, VerifyCRC: true}
 }
 
+// NewBinlogParserFromReader returns a parser that reads pcap data from r.
+func NewBinlogParserFromReader(r io.Reader) *BinlogParser {
+    return &BinlogParser{Path: readerPath, VerifyCRC: true, r: r}
+}
+
+// Parse reads the whole capture. Gzip-compressed input is detected by the
+// .gz suffix or the gzip magic bytes and decompressed on the fly.
 func (p *BinlogParser) Parse() error {
-    f, err := os.Open(p.Path)
+    err := p.parse()
+    p.TimestampJumps = len(p.FindTimestampJumps(DefaultJumpThreshold))
+    return err
+}
+
+func (p *BinlogParser) parse() error {
+    if p.r != nil {
+        r, err := maybeGunzip(p.r)
+        if err != nil {
+            return fmt.Errorf("gzip: %w", err)
+        }
+        return p.parseFrom(r)
+    }
+    f, err := OpenPcap(p.Path)
     if err != nil {
         return err
     }
     defer f.Close()
+    return p.parseFrom(f)
+}
 
+// ParseTimeRange is Parse keeping only events whose record time lies in
+// [startSec, endSec] (Unix seconds). Records outside the window are read
+// but not decoded; anchor and tag blocks are always parsed.
+func (p *BinlogParser) ParseTimeRange(startSec, endSec float64) error {
+    if endSec < startSec {
+        return fmt.Errorf("time range: end %.3f before start %.3f", endSec, startSec)
+    }
+    p.window = &[2]float64{startSec, endSec}
+    defer func() { p.window = nil }()
+    return p.Parse()
+}
+
+func (p *BinlogParser) parseFrom(f io.Reader) error {
     hdr := make([]byte, pcapGlobalLen)
-    if _, err := io.ReadFull(f, hdr); err != nil {
+    if _, err := io.ReadFull(f, hdr[:4]); err != nil {
+        return fmt.Errorf("pcap header: %w", err)
+    }
+    p.order = binary.LittleEndian
+    if binary.LittleEndian.Uint32(hdr[:4]) == pcapngSHB {
+        return p.parsePcapng(f)
+    }
+    if _, err := io.ReadFull(f, hdr[4:]); err != nil {
         return fmt.Errorf("pcap header: %w", err)
     }
+    if err := p.readHeader(hdr); err != nil {
+        return err
+    }
+    order := p.order
 
     for {
         rec := make([]byte, pcapRecordLen)
@@ -93,70 +199,114 @@ func (p *BinlogParser) Parse() error {
             }
             return fmt.Errorf("pcap record: %w", err)
         }
-        tsSec := binary.LittleEndian.Uint32(rec[0:4])
-        tsUsec := binary.LittleEndian.Uint32(rec[4:8])
-        inclLen := binary.LittleEndian.Uint32(rec[8:12])
-        // origLen := binary.LittleEndian.Uint32(rec[12:16]) // unused
+        tsSec := order.Uint32(rec[0:4])
+        tsUsec := order.Uint32(rec[4:8])
+        inclLen := order.Uint32(rec[8:12])
+        // origLen := order.Uint32(rec[12:16]) // unused
         if inclLen < phdr2Len {
             // malformed record, skip the stated length
-            if _, err := f.Seek(int64(inclLen), io.SeekCurrent); err != nil {
+            if _, err := io.CopyN(io.Discard, f, int64(inclLen)); err != nil {
+                if errors.Is(err, io.EOF) {
+                    break
+                }
                 return fmt.Errorf("skip malformed record: %w", err)
             }
             continue
         }
 
-        phdr := make([]byte, phdr2Len)
-        if _, err := io.ReadFull(f, phdr); err != nil {
-            if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
-                break
-            }
-            return fmt.Errorf("pcap phdr2: %w", err)
-        }
-        flag := binary.LittleEndian.Uint16(phdr[0:2])
-        wport := binary.LittleEndian.Uint16(phdr[2:4])
-        uip := binary.LittleEndian.Uint32(phdr[4:8])
-
-        payloadLen := int(inclLen) - phdr2Len
-        if payloadLen <= 0 {
-            continue
-        }
-        payload := make([]byte, payloadLen)
-        if _, err := io.ReadFull(f, payload); err != nil {
+        // phdr2 header followed by the payload
+        data := make([]byte, inclLen)
+        if _, err := io.ReadFull(f, data); err != nil {
             if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
                 break
             }
             return fmt.Errorf("pcap payload: %w", err)
         }
 
-        switch flag {
-        case flagAnchor:
-            p.parseAnchorBlock(payload, int(wport), int(uip))
-            continue
-        case flagTag:
-            p.parseTagBlock(payload, int(wport), int(uip))
-            continue
-        case flagStats:
-            // ignore
-            continue
-        }
-
-        if len(payload) < unibWrapLen || binary.LittleEndian.Uint16(payload[0:2]) != unibMagic {
-            continue
-        }
-        unib, err := parseUnib(payload, 0, p.VerifyCRC)
-        if err != nil {
-            continue
-        }
         ts := float64(tsSec) + float64(tsUsec)/1e6
-        evt, err := p.decodeOuter(unib)
-        if err != nil {
-            continue
-        }
-        p.Events = append(p.Events, Event{Timestamp: ts, Inner: evt})
+        p.handleRecord(ts, data)
     }
     return nil
 }
 
+// readHeader decodes the pcap global header and selects the byte order for
+// the rest of the file.
+func (p *BinlogParser) readHeader(hdr []byte) error {
+    magic := binary.LittleEndian.Uint32(hdr[0:4])
+    switch magic {
+    case pcapMagic:
+        p.order = binary.LittleEndian
+    case pcapMagicSwapped:
+        p.order = binary.BigEndian
+    default:
+        return fmt.Errorf("pcap header: bad magic 0x%08X (want 0x%08X or 0x%08X)", magic, uint32(pcapMagic), uint32(pcapMagicSwapped))
+    }
+    o := p.order
+    p.Header = PcapHeader{
+        Magic:        magic,
+        MajorVersion: o.Uint16(hdr[4:6]),
+        MinorVersion: o.Uint16(hdr[6:8]),
+        Zone:         int32(o.Uint32(hdr[8:12])),
+        Accuracy:     o.Uint32(hdr[12:16]),
+        SnapLen:      o.Uint32(hdr[16:20]),
+        LinkType:     o.Uint32(hdr[20:24]),
+    }
+    return nil
+}
+
+// byteOrder is the capture's byte order, little-endian until a header says
+// otherwise.
+func (p *BinlogParser) byteOrder() binary.ByteOrder {
+    if p.order == nil {
+        return binary.LittleEndian
+    }
+    return p.order
+}
+
+// handleRecord dispatches one captured packet: the phdr2 header followed by
+// an anchor/tag block or a UNIB frame.
+func (p *BinlogParser) handleRecord(ts float64, data []byte) {
+    if len(data) <= phdr2Len {
+        return
+    }
+    o := p.byteOrder()
+    flag := o.Uint16(data[0:2])
+    wport := o.Uint16(data[2:4])
+    uip := o.Uint32(data[4:8])
+    flag, _, payload, err := SplitPhdr2IPv6(flag, data[phdr2Len:])
+    if err != nil {
+        return
+    }
+
+    switch flag {
+    case flagAnchor:
+        p.parseAnchorBlock(payload, int(wport), int(uip))
+        return
+    case flagTag:
+        p.parseTagBlock(payload, int(wport), int(uip))
+        return
+    case flagStats:
+        // ignore
+        return
+    }
+    if p.window != nil && (ts < p.window[0] || ts > p.window[1]) {
+        return
+    }
+
+    if len(payload) < unibWrapLen || binary.LittleEndian.Uint16(payload[0:2]) != unibMagic {
+        return
+    }
+    unib, err := parseUnib(payload, 0)
+    if err != nil || !p.acceptCRC(unib) {
+        return
+    }
+    evt, err := p.decodeOuter(unib)
+    if err != nil {
+        return
+    }
+    p.Events = append(p.Events, Event{Timestamp: ts, Inner: evt})
+}
+
 func (p *BinlogParser) parseAnchorBlock(payload []byte, itemnum int, itemsize int) {
     for i := 0; i < itemnum; i++ {
         start := i * itemsize
@@ -165,11 +315,12 @@ func (p *BinlogParser) parseAnchorBlock(payload []byte, itemnum int, itemsize in
             return
         }
         chunk := payload[start:end]
-        anchorID := binary.LittleEndian.Uint64(chunk[0:8])
-        x := int32(binary.LittleEndian.Uint32(chunk[8:12]))
-        y := int32(binary.LittleEndian.Uint32(chunk[12:16]))
-        z := int32(binary.LittleEndian.Uint32(chunk[16:20]))
-        region := binary.LittleEndian.Uint16(chunk[20:22])
+        o := p.byteOrder()
+        anchorID := o.Uint64(chunk[0:8])
+        x := int32(o.Uint32(chunk[8:12]))
+        y := int32(o.Uint32(chunk[12:16]))
+        z := int32(o.Uint32(chunk[16:20]))
+        region := o.Uint16(chunk[20:22])
         p.Anchors = append(p.Anchors, AnchorInfo{AnchorID: anchorID, X: float64(x) / 100.0, Y: float64(y) / 100.0, Z: float64(z) / 100.0, Region: region})
     }
 }
@@ -182,8 +333,9 @@ func (p *BinlogParser) parseTagBlock(payload []byte, itemnum int, itemsize int)
             return
         }
         chunk := payload[start:end]
-        tagID := binary.LittleEndian.Uint64(chunk[0:8])
-        height := int32(binary.LittleEndian.Uint32(chunk[8:12]))
+        o := p.byteOrder()
+        tagID := o.Uint64(chunk[0:8])
+        height := int32(o.Uint32(chunk[8:12]))
         p.Tags = append(p.Tags, TagHeight{TagID: tagID, Height: float64(height) / 100.0})
     }
 }
@@ -196,9 +348,33 @@ type unibPacket struct {
     Flags uint8
     Body []byte
     TotalLen int
+    CRCOk bool
+}
+
+// acceptCRC counts pkt's CRC outcome and reports whether it should be
+// decoded.
+func (p *BinlogParser) acceptCRC(pkt *unibPacket) bool {
+    if !p.VerifyCRC {
+        return true
+    }
+    if pkt.CRCOk {
+        p.CRCOk++
+        return true
+    }
+    p.CRCFailed++
+    return p.LenientCRC
+}
+
+// CRCFailureRate is the fraction of checked frames with a bad CRC.
+func (p *BinlogParser) CRCFailureRate() float64 {
+    total := p.CRCOk + p.CRCFailed
+    if total == 0 {
+        return 0
+    }
+    return float64(p.CRCFailed) / float64(total)
 }
 
-func parseUnib(data []byte, offset int, verifyCRC bool) (*unibPacket, error) {
+func parseUnib(data []byte, offset int) (*unibPacket, error) {
     if len(data)-offset < unibWrapLen {
         return nil, fmt.Errorf("unib too short")
     }
@@ -219,16 +395,12 @@ func parseUnib(data []byte, offset int, verifyCRC bool) (*unibPacket, error) {
     }
     body := data[bodyStart:bodyEnd]
     crcRead := binary.LittleEndian.Uint16(data[bodyEnd : bodyEnd+2])
-    if verifyCRC {
-        if crc16(data[offset:bodyEnd]) != crcRead {
-            return nil, fmt.Errorf("crc mismatch")
-        }
-    }
+    crcOk := crc16(data[offset:bodyEnd]) == crcRead
     typLow := typeFlags >> 3
     pktType := typLow + (typHigh << 5)
     flags := typeFlags & 0x7
     total := bodyLen + unibWrapLen
-    return &unibPacket{Addr: addr, PktType: pktType, Flags: flags, Body: body, TotalLen: total}, nil
+    return &unibPacket{Addr: addr, PktType: pktType, Flags: flags, Body: body, TotalLen: total, CRCOk: crcOk}, nil
 }
 
 func crc16(data []byte) uint16 {
@@ -271,19 +443,28 @@ func (p *BinlogParser) decodeOuter(pkt *unibPacket) ([]InnerFrame, error) {
     innerPayload := pkt.Body[offset:]
     inner := []InnerFrame{}
     pos := 0
+    // A frame is expected at the start of the payload and right after a
+    // decoded frame. While resyncing elsewhere a magic match may be chance,
+    // so a bad CRC there just moves on a byte: it is not counted and not
+    // accepted by LenientCRC, whose length would skip real frames.
+    aligned := true
     for pos+unibWrapLen <= len(innerPayload) {
         if binary.LittleEndian.Uint16(innerPayload[pos:pos+2]) != unibMagic {
             pos++
+            aligned = false
             continue
         }
-        inPkt, err := parseUnib(innerPayload, pos, p.VerifyCRC)
-        if err != nil {
+        inPkt, err := parseUnib(innerPayload, pos)
+        if err != nil || (!aligned && p.VerifyCRC && !inPkt.CRCOk) || !p.acceptCRC(inPkt) {
             pos++
+            aligned = false
             continue
         }
         pos += inPkt.TotalLen
+        aligned = true
         frame, err := p.decodeInner(inPkt, pkt.Flags)
         if err == nil && frame != nil {
+            frame.CRCBad = !inPkt.CRCOk || !pkt.CRCOk
             inner = append(inner, *frame)
         }
     }
@@ -293,40 +474,34 @@ func (p *BinlogParser) decodeOuter(pkt *unibPacket) ([]InnerFrame, error) {
 func (p *BinlogParser) decodeInner(pkt *unibPacket, parentFlags uint8) (*InnerFrame, error) {
     secFlags := pkt.Flags | parentFlags
     body := pkt.Body
-    var secPrefix *uint8
+    frame := InnerFrame{Addr: pkt.Addr, Type: pkt.PktType}
     if secFlags&secondsFlag != 0 && len(body) > 0 {
         v := body[0]
-        secPrefix = &v
+        frame.SecondsPrefix = &v
         body = body[1:]
     }
-    _ = secPrefix
 
-    frame := InnerFrame{Addr: pkt.Addr, Type: pkt.PktType}
     switch pkt.PktType {
     case 0x50: // TWR
-        seq, samples, err := decodeTwrSamples(body, false)
-        _ = seq
+        _, samples, err := decodeTwrSamples(body, false)
         if err != nil {
             return nil, err
         }
         frame.Samples = samples
     case 0x52: // TWR_S
-        seq, samples, err := decodeTwrSamples(body, true)
-        _ = seq
+        _, samples, err := decodeTwrSamples(body, true)
         if err != nil {
             return nil, err
         }
         frame.Samples = samples
     case 0x60: // RSSI
-        seq, samples, err := decodeRssi(body, false)
-        _ = seq
+        _, samples, err := decodeRssi(body, false)
         if err != nil {
             return nil, err
         }
         frame.Samples = samples
     case 0x61: // RSSI_S
-        seq, samples, err := decodeRssi(body, true)
-        _ = seq
+        _, samples, err := decodeRssi(body, true)
         if err != nil {
             return nil, err
         }
@@ -343,6 +518,9 @@ func (p *BinlogParser) decodeInner(pkt *unibPacket, parentFlags uint8) (*InnerFr
     return &frame, nil
 }
 
+// decodeTwrSamples decodes a TWR or TWR_S body. Ranges are uint16
+// centimeters, at most 655.35 m; frames using the firmware's extended range
+// encoding are not recognised until its meta flag is specified.
 func decodeTwrSamples(body []byte, short bool) (uint8, []Sample, error) {
     if len(body) < 2 {
         return 0, nil, fmt.Errorf("twr too short")
@@ -362,7 +540,7 @@ func decodeTwrSamples(body []byte, short bool) (uint8, []Sample, error) {
             rng := binary.LittleEndian.Uint16(body[pos+3 : pos+5])
             pos += 5
             anchorID := int(uint32(addrHi)<<16 | uint32(addrLow))
-            samples = append(samples, Sample{AnchorID: anchorID, RangeM: float64(rng) / 100.0})
+            samples = append(samples, Sample{AnchorID: anchorID, RangeM: float64(rng) / 100.0, Seq: seq})
         }
     } else {
         for i := 0; i < num; i++ {
@@ -372,7 +550,7 @@ func decodeTwrSamples(body []byte, short bool) (uint8, []Sample, error) {
             addr := binary.LittleEndian.Uint16(body[pos : pos+2])
             rng := binary.LittleEndian.Uint16(body[pos+2 : pos+4])
             pos += 4
-            samples = append(samples, Sample{AnchorID: int(addr), RangeM: float64(rng) / 100.0})
+            samples = append(samples, Sample{AnchorID: int(addr), RangeM: float64(rng) / 100.0, Seq: seq})
         }
     }
     return seq, samples, nil
@@ -398,7 +576,7 @@ func decodeRssi(body []byte, short bool) (uint8, []Sample, error) {
                 addr := binary.LittleEndian.Uint16(body[pos : pos+2])
                 rssi := int(int8(body[pos+2]))
                 pos += 3
-                samples = append(samples, Sample{AnchorID: int(addr), RSSIDb: rssi})
+                samples = append(samples, Sample{AnchorID: int(addr), RSSIDb: rssi, Seq: seq})
             }
         } else {
             for i := 0; i < num; i++ {
@@ -410,7 +588,7 @@ func decodeRssi(body []byte, short bool) (uint8, []Sample, error) {
                 rssi := int(int8(body[pos+3]))
                 pos += 4
                 anchorID := int(uint32(addrHi)<<16 | uint32(addrLow))
-                samples = append(samples, Sample{AnchorID: anchorID, RSSIDb: rssi})
+                samples = append(samples, Sample{AnchorID: anchorID, RSSIDb: rssi, Seq: seq})
             }
         }
     } else {
@@ -421,7 +599,7 @@ func decodeRssi(body []byte, short bool) (uint8, []Sample, error) {
             addr := binary.LittleEndian.Uint16(body[pos : pos+2])
             rssi := int(int8(body[pos+2]))
             pos += 3
-            samples = append(samples, Sample{AnchorID: int(addr), RSSIDb: rssi})
+            samples = append(samples, Sample{AnchorID: int(addr), RSSIDb: rssi, Seq: seq})
         }
     }
     return seq, samples, nil
@@ -432,11 +610,21 @@ func decodeIMU(body []byte) (*IMUSample, error) {
         return nil, fmt.Errorf("imu too short")
     }
     // payload: seq(1) + distance float32 + word1 uint32 + word2 uint16
+    // word1: yaw:13, motion:3, yaw_sigma:3, ds_sigma:3 (LSB first)
+    // word2: speed in cm/s
     distance := math.Float32frombits(binary.LittleEndian.Uint32(body[1:5]))
     word1 := binary.LittleEndian.Uint32(body[5:9])
+    word2 := binary.LittleEndian.Uint16(body[9:11])
     yaw := word1 & 0x1FFF
     yawDeg := float64(yaw) * 360.0 / 8192.0
-    return &IMUSample{Distance: float64(distance), YawDeg: yawDeg}, nil
+    return &IMUSample{
+        Distance:     float64(distance),
+        YawDeg:       yawDeg,
+        SpeedMps:     float64(word2) / 100.0,
+        MotionCode:   int((word1 >> 13) & 0x7),
+        YawSigmaCode: int((word1 >> 16) & 0x7),
+        DsSigmaCode:  int((word1 >> 19) & 0x7),
+    }, nil
 }
 
 // ------------------------------------------------------------------------
@@ -460,26 +648,184 @@ func (p *BinlogParser) FilterSamples(evt Event, tagID uint32) ([]Sample, []Sampl
         if in.Addr != tagID {
             continue
         }
+        // Classify on frame type alone, as server.feedRssi/feedTwr do;
+        // range and strength validity is left to the pipeline.
         switch in.Type {
         case 0x60, 0x61:
+            ble = append(ble, in.Samples...)
+        case 0x50, 0x52:
+            twr = append(twr, in.Samples...)
+        case 0x90:
+            if in.IMU != nil {
+                imu = append(imu, *in.IMU)
+            }
+        }
+    }
+    return ble, twr, imu
+}
+
+// maxSeqAdvance is the largest step between two 8-bit sequence numbers
+// read as frames gone missing. A frame further ahead of the newest one is
+// taken as behind it instead: it arrived late, or the counter was reset.
+const maxSeqAdvance = 128
+
+// seqTrack unwraps the 8-bit sequence numbers of one frame stream into a
+// running frame index.
+type seqTrack struct {
+    started bool
+    seq     uint8 // newest sequence number
+    index   int   // index of seq
+}
+
+// next returns the index of seq. A frame behind the newest one gets the
+// index it would have had and leaves the newest alone.
+func (t *seqTrack) next(seq uint8) int {
+    if !t.started {
+        t.started, t.seq = true, seq
+        return t.index
+    }
+    d := int(seq - t.seq)
+    if d > maxSeqAdvance {
+        return t.index - (256 - d)
+    }
+    t.seq = seq
+    t.index += d
+    return t.index
+}
+
+// sortedIndices returns the keys of set in increasing order.
+func sortedIndices(set map[int]bool) []int {
+    out := make([]int, 0, len(set))
+    for i := range set {
+        out = append(out, i)
+    }
+    sort.Ints(out)
+    return out
+}
+
+// DetectSeqGaps walks the tag's TWR and RSSI frames and returns, per
+// anchor, the length of every gap in the frame sequence numbers between
+// two frames that carried that anchor. TWR and RSSI counters are tracked
+// separately; repeated sequence numbers (the same frame relayed twice) are
+// skipped, and a frame more than maxSeqAdvance ahead is placed behind the
+// newest one (reordered in transit, or a counter reset) rather than
+// opening a gap.
+func (p *BinlogParser) DetectSeqGaps(events []Event, tagID uint32) map[int][]int {
+    type key struct {
+        anchor int
+        twr    bool
+    }
+    tracks := map[key]*seqTrack{}
+    seen := map[key]map[int]bool{}
+    var order []key
+    for _, evt := range events {
+        for _, in := range evt.Inner {
+            if in.Addr != tagID {
+                continue
+            }
+            var isTwr bool
+            switch in.Type {
+            case 0x50, 0x52:
+                isTwr = true
+            case 0x60, 0x61:
+                isTwr = false
+            default:
+                continue
+            }
             for _, s := range in.Samples {
-                if s.RSSIDb != 0 || s.RangeM == 0 {
-                    ble = append(ble, s)
+                k := key{anchor: s.AnchorID, twr: isTwr}
+                t, ok := tracks[k]
+                if !ok {
+                    t = &seqTrack{}
+                    tracks[k] = t
+                    seen[k] = map[int]bool{}
+                    order = append(order, k)
                 }
+                seen[k][t.next(s.Seq)] = true
             }
-        case 0x50, 0x52:
+        }
+    }
+    gaps := map[int][]int{}
+    for _, k := range order {
+        idx := sortedIndices(seen[k])
+        for i := 1; i < len(idx); i++ {
+            if gap := idx[i] - idx[i-1] - 1; gap > 0 {
+                gaps[k.anchor] = append(gaps[k.anchor], gap)
+            }
+        }
+    }
+    return gaps
+}
+
+// SeqCounts tells the samples of a tag that went missing in transit from
+// those of an anchor that was out of range.
+type SeqCounts struct {
+    Received   int // TWR/RSSI samples received
+    Lost       int // missing because their whole frame never arrived
+    OutOfRange int // missing from frames that did arrive
+}
+
+// CountSeqGaps splits the gaps DetectSeqGaps reports: a sequence number
+// for which none of the tag's frames of that kind (TWR or RSSI) arrived
+// counts as lost, one whose frame arrived without the anchor as out of
+// range. Reordered frames are handled as in DetectSeqGaps, so a frame that
+// arrives late fills its gap instead of counting as lost.
+func (p *BinlogParser) CountSeqGaps(events []Event, tagID uint32) SeqCounts {
+    type key struct {
+        anchor int
+        twr    bool
+    }
+    // kind unwraps the sequence numbers of one frame kind and marks the
+    // indices a frame arrived with.
+    type kind struct {
+        track   seqTrack
+        arrived map[int]bool
+    }
+    kinds := map[bool]*kind{true: {arrived: map[int]bool{}}, false: {arrived: map[int]bool{}}}
+    seen := map[key]map[int]bool{}
+    var order []key
+    var counts SeqCounts
+    for _, evt := range events {
+        for _, in := range evt.Inner {
+            if in.Addr != tagID || len(in.Samples) == 0 {
+                continue
+            }
+            var isTwr bool
+            switch in.Type {
+            case 0x50, 0x52:
+                isTwr = true
+            case 0x60, 0x61:
+                isTwr = false
+            default:
+                continue
+            }
+            kd := kinds[isTwr]
+            index := kd.track.next(in.Samples[0].Seq)
+            kd.arrived[index] = true
+            counts.Received += len(in.Samples)
             for _, s := range in.Samples {
-                if s.RangeM > 0 {
-                    twr = append(twr, s)
+                k := key{anchor: s.AnchorID, twr: isTwr}
+                if seen[k] == nil {
+                    seen[k] = map[int]bool{}
+                    order = append(order, k)
                 }
+                seen[k][index] = true
             }
-        case 0x90:
-            if in.IMU != nil {
-                imu = append(imu, *in.IMU)
+        }
+    }
+    for _, k := range order {
+        idx := sortedIndices(seen[k])
+        for j := 1; j < len(idx); j++ {
+            for i := idx[j-1] + 1; i < idx[j]; i++ {
+                if kinds[k.twr].arrived[i] {
+                    counts.OutOfRange++
+                } else {
+                    counts.Lost++
+                }
             }
         }
     }
-    return ble, twr, imu
+    return counts
 }
 
 // EarliestEventTs returns earliest timestamp.
@@ -0,0 +1,233 @@
+package binlog
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// rawUp builds a LORA_RAWDATA_UP packet around payload (inner frames and
+// anything in between) and returns it parsed.
+func rawUp(t *testing.T, payload ...[]byte) *unibPacket {
+	t.Helper()
+	pkt, err := BuildRawUp(0x5A5A, 0xB50AC, -60, payload...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer, err := parseUnib(pkt, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outer
+}
+
+func twrFrame(t *testing.T, seq uint8, anchor int, rangeM float64) []byte {
+	t.Helper()
+	b, err := EncodeInner(InnerFrame{Addr: 0xB50AC, Type: 0x50, Samples: []Sample{{AnchorID: anchor, RangeM: rangeM}}}, seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeOuterResyncIgnoresChanceMagic(t *testing.T) {
+	// Junk between frames holding a magic match whose header length would
+	// swallow the start of the next frame.
+	junk := []byte{0x00, 0x57, 0x78, 0, 0, 0, 0, 0, 0xE0, 0, 0x11, 0x22}
+	a, b := twrFrame(t, 1, 0x1A2B3C, 3.21), twrFrame(t, 2, 0x1A2B3D, 4.56)
+
+	for _, lenient := range []bool{false, true} {
+		p := &BinlogParser{VerifyCRC: true, LenientCRC: lenient}
+		frames, err := p.decodeOuter(rawUp(t, a, junk, b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(frames) != 2 || frames[0].Samples[0].Seq != 1 || frames[1].Samples[0].Seq != 2 {
+			t.Fatalf("lenient=%v: decoded %+v, want frames 1 and 2", lenient, frames)
+		}
+		if p.CRCFailed != 0 {
+			t.Errorf("lenient=%v: %d CRC failures counted for a chance magic match", lenient, p.CRCFailed)
+		}
+	}
+}
+
+func TestDecodeOuterCountsBadCRCAtFrameBoundary(t *testing.T) {
+	a, b := twrFrame(t, 1, 0x1A2B3C, 3.21), twrFrame(t, 2, 0x1A2B3D, 4.56)
+	b[len(b)-1] ^= 0xFF
+
+	p := &BinlogParser{VerifyCRC: true}
+	frames, err := p.decodeOuter(rawUp(t, a, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 || p.CRCFailed != 1 {
+		t.Errorf("strict: %d frames, %d CRC failures, want 1 and 1", len(frames), p.CRCFailed)
+	}
+
+	p = &BinlogParser{VerifyCRC: true, LenientCRC: true}
+	frames, err = p.decodeOuter(rawUp(t, a, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || !frames[1].CRCBad || p.CRCFailed != 1 {
+		t.Errorf("lenient: %d frames, %d CRC failures, want 2 with the second marked CRCBad", len(frames), p.CRCFailed)
+	}
+}
+
+func TestDecodeTwrSamplesTruncated(t *testing.T) {
+	for _, short := range []bool{false, true} {
+		anchor := 0x1A2B3C
+		if short {
+			anchor = 0x2B3C
+		}
+		body, err := encodeTwr([]Sample{{AnchorID: anchor, RangeM: 3.21}, {AnchorID: anchor + 1, RangeM: 4.56}}, 7, short)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq, samples, err := decodeTwrSamples(body, short)
+		if err != nil || seq != 7 || len(samples) != 2 || samples[1].AnchorID != anchor+1 || samples[1].RangeM != 4.56 {
+			t.Fatalf("short=%v: decoded seq %d %+v, %v", short, seq, samples, err)
+		}
+		for n := 0; n < len(body); n++ {
+			if _, samples, err := decodeTwrSamples(body[:n], short); err == nil {
+				t.Errorf("short=%v: %d of %d bytes decoded to %+v", short, n, len(body), samples)
+			}
+		}
+	}
+}
+
+func TestFilterSamplesByFrameType(t *testing.T) {
+	var payload [][]byte
+	for i, f := range []InnerFrame{
+		{Addr: 0xB50AC, Type: 0x60, Samples: []Sample{{AnchorID: 0x1A2B3C, RSSIDb: 0}, {AnchorID: 0x1A2B3D, RSSIDb: -70}}},
+		{Addr: 0xB50AC, Type: 0x50, Samples: []Sample{{AnchorID: 0x1A2B3C, RangeM: 0}, {AnchorID: 0x1A2B3D, RangeM: 3.21}}},
+		{Addr: 0xB50AD, Type: 0x60, Samples: []Sample{{AnchorID: 0x1A2B3C, RSSIDb: -60}}},
+	} {
+		b, err := EncodeInner(f, uint8(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload = append(payload, b)
+	}
+	p := &BinlogParser{VerifyCRC: true}
+	frames, err := p.decodeOuter(rawUp(t, payload...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A 0 dBm sample that also carries a range, as a combined frame would.
+	frames = append(frames, InnerFrame{Addr: 0xB50AC, Type: 0x61, Samples: []Sample{{AnchorID: 0x2B3C, RSSIDb: 0, RangeM: 2.5}}})
+
+	ble, twr, _ := p.FilterSamples(Event{Inner: frames}, 0xB50AC)
+	if len(ble) != 3 || ble[0].RSSIDb != 0 || ble[1].RSSIDb != -70 || ble[2].AnchorID != 0x2B3C {
+		t.Errorf("BLE samples %+v, want every sample of the tag's 0x60/0x61 frames", ble)
+	}
+	if len(twr) != 2 || twr[0].RangeM != 0 || twr[1].RangeM != 3.21 {
+		t.Errorf("TWR samples %+v, want both ranges left to the pipeline", twr)
+	}
+}
+
+func TestCountSeqGaps(t *testing.T) {
+	frame := func(typ uint8, seq uint8, anchors ...int) InnerFrame {
+		f := InnerFrame{Addr: 0xB50AC, Type: typ}
+		for _, a := range anchors {
+			f.Samples = append(f.Samples, Sample{AnchorID: a, Seq: seq})
+		}
+		return f
+	}
+	// TWR frame 3 is lost and anchor 2 is out of range in frame 4; RSSI
+	// frame 255 is lost across the wrap to 0.
+	events := []Event{
+		{Inner: []InnerFrame{frame(0x50, 1, 1, 2), frame(0x60, 254, 3)}},
+		{Inner: []InnerFrame{frame(0x50, 2, 1, 2)}},
+		{Inner: []InnerFrame{frame(0x50, 4, 1), frame(0x60, 0, 3)}},
+		{Inner: []InnerFrame{frame(0x50, 5, 1, 2), {Addr: 0xB50AD, Type: 0x50, Samples: []Sample{{AnchorID: 1, Seq: 9}}}}},
+	}
+	p := &BinlogParser{}
+	got := p.CountSeqGaps(events, 0xB50AC)
+	if want := (SeqCounts{Received: 9, Lost: 3, OutOfRange: 1}); got != want {
+		t.Errorf("CountSeqGaps = %+v, want %+v", got, want)
+	}
+	missing := 0
+	for _, gaps := range p.DetectSeqGaps(events, 0xB50AC) {
+		for _, g := range gaps {
+			missing += g
+		}
+	}
+	if missing != got.Lost+got.OutOfRange {
+		t.Errorf("DetectSeqGaps finds %d missing, CountSeqGaps %d lost + %d out of range", missing, got.Lost, got.OutOfRange)
+	}
+}
+
+func TestSeqGapsReordered(t *testing.T) {
+	frame := func(seq uint8) InnerFrame {
+		return InnerFrame{Addr: 0xB50AC, Type: 0x50, Samples: []Sample{{AnchorID: 1, Seq: seq}, {AnchorID: 2, Seq: seq}}}
+	}
+	// Frame 3 arrives after frame 4 and frame 0 after frame 1, across the
+	// wrap; nothing is missing. Frame 7 alone is lost.
+	var events []Event
+	for _, seq := range []uint8{1, 2, 4, 3, 5, 6, 8, 254, 255, 1, 0, 2} {
+		events = append(events, Event{Inner: []InnerFrame{frame(seq)}})
+	}
+	p := &BinlogParser{}
+	if got, want := p.CountSeqGaps(events[:7], 0xB50AC), (SeqCounts{Received: 14, Lost: 2}); got != want {
+		t.Errorf("CountSeqGaps = %+v, want %+v", got, want)
+	}
+	if got := p.DetectSeqGaps(events[:7], 0xB50AC); len(got[1]) != 1 || got[1][0] != 1 {
+		t.Errorf("DetectSeqGaps = %v, want one gap of 1 per anchor", got)
+	}
+	if got, want := p.CountSeqGaps(events[7:], 0xB50AC), (SeqCounts{Received: 10}); got != want {
+		t.Errorf("CountSeqGaps across the wrap = %+v, want %+v", got, want)
+	}
+	if got := p.DetectSeqGaps(events[7:], 0xB50AC); len(got) != 0 {
+		t.Errorf("DetectSeqGaps across the wrap = %v, want none", got)
+	}
+}
+
+func TestFindTimestampJumps(t *testing.T) {
+	p := &BinlogParser{}
+	for _, ts := range []float64{100, 100.5, 105, 134, 170, 169.5, 160, 161} {
+		p.Events = append(p.Events, Event{Timestamp: ts})
+	}
+	// 105 -> 134 is an idle gap under ForwardJumpGap, 169.5 -> 160 a
+	// backward step and 134 -> 170 a forward step over it.
+	want := []TimestampJump{{EventIndex: 4, Before: 134, After: 170}, {EventIndex: 6, Before: 169.5, After: 160}}
+	got := p.FindTimestampJumps(0)
+	if len(got) != len(want) {
+		t.Fatalf("jumps %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("jump %d: %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got := p.FindTimestampJumps(40); len(got) != 0 {
+		t.Errorf("threshold 40: jumps %+v, want none", got)
+	}
+}
+
+func TestParseSetsTimestampJumps(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := NewPcapWriterFromWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := twrFrame(t, 1, 0x1A2B3C, 3.21)
+	for _, sec := range []int64{1000, 1001, 1020, 990} {
+		pkt, err := BuildRawUp(0x5A5A, 0xB50AC, -60, frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pw.WritePacketAt(time.Unix(sec, 0), 0x109, &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 9000}, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := NewBinlogParserFromReader(&buf)
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Events) != 4 || p.TimestampJumps != 1 || p.Stats().TimestampJumps != 1 {
+		t.Errorf("%d events, %d jumps (stats %d), want 4 events and 1 jump", len(p.Events), p.TimestampJumps, p.Stats().TimestampJumps)
+	}
+}
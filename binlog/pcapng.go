@@ -0,0 +1,154 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	pcapngSHB = 0x0A0D0D0A // Section Header Block, also the file magic
+	pcapngIDB = 0x00000001 // Interface Description Block
+	pcapngSPB = 0x00000003 // Simple Packet Block
+	pcapngEPB = 0x00000006 // Enhanced Packet Block
+
+	pcapngByteOrder = 0x1A2B3C4D
+	pcapngOptTsres  = 9 // if_tsresol
+)
+
+// parsePcapng walks a pcapng stream whose 4-byte magic has already been
+// consumed. Packet data in EPB/SPB blocks carries the same phdr2 + payload
+// layout as classic pcap records.
+func (p *BinlogParser) parsePcapng(f io.Reader) error {
+	var bo binary.ByteOrder = binary.LittleEndian
+	// Seconds per timestamp unit, one entry per interface in the section
+	var tsUnit []float64
+	blockType := uint32(pcapngSHB)
+
+	for {
+		lenBuf := make([]byte, 4)
+		if _, err := io.ReadFull(f, lenBuf); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return fmt.Errorf("pcapng block: %w", err)
+		}
+
+		if blockType == pcapngSHB {
+			// The byte order magic follows the length; peek it before
+			// trusting the length field.
+			bom := make([]byte, 4)
+			if _, err := io.ReadFull(f, bom); err != nil {
+				return fmt.Errorf("pcapng section header: %w", err)
+			}
+			switch {
+			case binary.LittleEndian.Uint32(bom) == pcapngByteOrder:
+				bo = binary.LittleEndian
+			case binary.BigEndian.Uint32(bom) == pcapngByteOrder:
+				bo = binary.BigEndian
+			default:
+				return fmt.Errorf("pcapng section header: bad byte-order magic %x", bom)
+			}
+			total := bo.Uint32(lenBuf)
+			if total < 28 {
+				return fmt.Errorf("pcapng section header: bad length %d", total)
+			}
+			// Rest of body plus trailing length
+			if _, err := io.CopyN(io.Discard, f, int64(total)-12); err != nil {
+				return fmt.Errorf("pcapng section header: %w", err)
+			}
+			tsUnit = tsUnit[:0]
+		} else {
+			total := bo.Uint32(lenBuf)
+			if total < 12 || total%4 != 0 {
+				return fmt.Errorf("pcapng block %#x: bad length %d", blockType, total)
+			}
+			body := make([]byte, total-12)
+			if _, err := io.ReadFull(f, body); err != nil {
+				if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+					return nil
+				}
+				return fmt.Errorf("pcapng block %#x: %w", blockType, err)
+			}
+			if _, err := io.CopyN(io.Discard, f, 4); err != nil {
+				return nil
+			}
+
+			switch blockType {
+			case pcapngIDB:
+				tsUnit = append(tsUnit, pcapngTsUnit(body, bo))
+			case pcapngEPB:
+				if len(body) < 20 {
+					continue
+				}
+				ifID := bo.Uint32(body[0:4])
+				tsRaw := uint64(bo.Uint32(body[4:8]))<<32 | uint64(bo.Uint32(body[8:12]))
+				capLen := bo.Uint32(body[12:16])
+				if int(capLen) > len(body)-20 {
+					continue
+				}
+				unit := 1e-6
+				if int(ifID) < len(tsUnit) {
+					unit = tsUnit[ifID]
+				}
+				p.handleRecord(float64(tsRaw)*unit, body[20:20+capLen])
+			case pcapngSPB:
+				// No timestamp; snap length comes from the IDB, so use all
+				// data up to the original length.
+				if len(body) < 4 {
+					continue
+				}
+				origLen := int(bo.Uint32(body[0:4]))
+				data := body[4:]
+				if origLen < len(data) {
+					data = data[:origLen]
+				}
+				p.handleRecord(0, data)
+			}
+		}
+
+		typeBuf := make([]byte, 4)
+		if _, err := io.ReadFull(f, typeBuf); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return fmt.Errorf("pcapng block: %w", err)
+		}
+		// The SHB type is a palindrome, so it reads the same in either order
+		blockType = bo.Uint32(typeBuf)
+	}
+}
+
+// pcapngTsUnit returns the timestamp resolution of an IDB in seconds,
+// defaulting to microseconds, also when the options are truncated or
+// corrupt.
+func pcapngTsUnit(body []byte, bo binary.ByteOrder) float64 {
+	unit := 1e-6
+	if len(body) < 8 {
+		return unit
+	}
+	opts := body[8:]
+	for len(opts) >= 4 {
+		code := bo.Uint16(opts[0:2])
+		olen := int(bo.Uint16(opts[2:4]))
+		if code == 0 {
+			break
+		}
+		next := 4 + (olen+3)&^3
+		if next > len(opts) {
+			return 1e-6
+		}
+		if code == pcapngOptTsres && olen >= 1 {
+			v := opts[4]
+			if v&0x80 != 0 {
+				unit = math.Pow(2, -float64(v&0x7F))
+			} else {
+				unit = math.Pow(10, -float64(v))
+			}
+		}
+		opts = opts[next:]
+	}
+	return unit
+}
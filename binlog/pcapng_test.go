@@ -0,0 +1,29 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestPcapngTsUnit(t *testing.T) {
+	idb := func(opts ...byte) []byte {
+		return append(make([]byte, 8), opts...)
+	}
+	tests := []struct {
+		name string
+		body []byte
+		want float64
+	}{
+		{"no options", idb(), 1e-6},
+		{"nanoseconds", idb(9, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0), 1e-9},
+		{"power of two", idb(9, 0, 1, 0, 0x8A, 0, 0, 0), 1.0 / 1024},
+		{"unpadded last option", idb(9, 0, 1, 0, 9), 1e-6},
+		{"length past end", idb(2, 0, 0xFF, 0, 'x', 'y'), 1e-6},
+		{"truncated header", idb(9, 0, 1), 1e-6},
+	}
+	for _, tt := range tests {
+		if got := pcapngTsUnit(tt.body, binary.LittleEndian); got != tt.want {
+			t.Errorf("%s: unit %g, want %g", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package binlog
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ReadProgress is a snapshot of a pcap being replayed. BytesTotal is the
+// file size from PcapSize, 0 when unknown (compressed captures); progress
+// and ETA are then not available.
+type ReadProgress struct {
+	Packets    int
+	BytesRead  int64
+	BytesTotal int64
+	FileTime   float64 // seconds of capture time consumed
+	Elapsed    time.Duration
+	Speed      float64 // replay speed multiplier; 0 is as fast as possible
+}
+
+// PcapSize returns the size of an uncompressed capture, or 0 for gzip
+// files, whose decompressed size is unknown up front. Gzip is recognized
+// as by OpenPcap, by the .gz suffix or the magic bytes.
+func PcapSize(path string) int64 {
+	if strings.HasSuffix(path, ".gz") {
+		return 0
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	if hasGzipMagic(bufio.NewReader(f)) {
+		return 0
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// Fraction is the share of the file read so far, or 0 if unknown.
+func (p ReadProgress) Fraction() float64 {
+	if p.BytesTotal <= 0 {
+		return 0
+	}
+	return float64(p.BytesRead) / float64(p.BytesTotal)
+}
+
+// ETA extrapolates the remaining wall time from the rate so far, which at
+// a fixed speed multiplier tracks the remaining capture time / speed. It is
+// 0 when the file size is unknown.
+func (p ReadProgress) ETA() time.Duration {
+	if p.BytesTotal <= 0 || p.BytesRead <= 0 || p.BytesRead >= p.BytesTotal {
+		return 0
+	}
+	left := float64(p.BytesTotal-p.BytesRead) / float64(p.BytesRead)
+	return time.Duration(float64(p.Elapsed) * left)
+}
+
+// PacketRate is the throughput so far in packets per second.
+func (p ReadProgress) PacketRate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Packets) / p.Elapsed.Seconds()
+}
+
+// String is a one-line progress report: percent done and ETA at a fixed
+// speed, packets per second at maximum speed.
+func (p ReadProgress) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d packets, %s of capture in %s", p.Packets,
+		time.Duration(p.FileTime*float64(time.Second)).Round(time.Second), p.Elapsed.Round(time.Second))
+	if p.BytesTotal > 0 {
+		fmt.Fprintf(&b, ", %.1f%% of %d bytes", 100*p.Fraction(), p.BytesTotal)
+	}
+	if p.Speed <= 0 {
+		fmt.Fprintf(&b, ", %.0f pkt/s", p.PacketRate())
+	} else if eta := p.ETA(); eta > 0 {
+		fmt.Fprintf(&b, ", ETA %s", eta.Round(time.Second))
+	}
+	return b.String()
+}
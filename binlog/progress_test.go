@@ -0,0 +1,44 @@
+package binlog
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPcapSizeDetectsGzipByMagic(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.pcap")
+	pw, err := NewPcapWriter(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := PcapSize(plain); got != pcapGlobalLen {
+		t.Errorf("PcapSize(plain) = %d, want %d", got, pcapGlobalLen)
+	}
+
+	// Compressed, but without the .gz suffix.
+	packed := filepath.Join(dir, "packed.pcap")
+	f, err := os.Create(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err = NewPcapWriterFromWriter(gzip.NewWriter(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := PcapSize(packed); got != 0 {
+		t.Errorf("PcapSize(gzip without suffix) = %d, want 0", got)
+	}
+	if got := PcapSize(filepath.Join(dir, "missing.pcap")); got != 0 {
+		t.Errorf("PcapSize(missing) = %d, want 0", got)
+	}
+}
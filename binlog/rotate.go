@@ -0,0 +1,139 @@
+package binlog
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RotatingPcapWriter writes packets to a series of pcap files named
+// <prefix>_YYYYMMDD_HHMMSS.pcap, starting a new file once the current one
+// exceeds maxSize bytes or has been open longer than maxAge. A zero limit
+// disables that trigger. Every file starts with the anchor and tag blocks
+// given to SetBlocks.
+type RotatingPcapWriter struct {
+	// OnRotate, if set, is called with the path of each file after it has
+	// been closed, e.g. to compress or upload it.
+	OnRotate func(closedPath string)
+
+	mu      sync.Mutex
+	dir     string
+	prefix  string
+	maxSize int64
+	maxAge  time.Duration
+
+	cur     *PcapWriter
+	curPath string
+	size    int64
+	opened  time.Time
+
+	anchors []AnchorInfo
+	tags    []TagHeight
+}
+
+func NewRotatingPcapWriter(dir, prefix string, maxSizeBytes int64, maxDuration time.Duration) (*RotatingPcapWriter, error) {
+	rw := &RotatingPcapWriter{
+		dir:     dir,
+		prefix:  prefix,
+		maxSize: maxSizeBytes,
+		maxAge:  maxDuration,
+	}
+	if err := rw.open(); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// CurrentPath returns the file currently being written.
+func (rw *RotatingPcapWriter) CurrentPath() string {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.curPath
+}
+
+func (rw *RotatingPcapWriter) open() error {
+	now := time.Now()
+	base := fmt.Sprintf("%s_%s", rw.prefix, now.Format("20060102_150405"))
+	path := filepath.Join(rw.dir, base+".pcap")
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		path = filepath.Join(rw.dir, fmt.Sprintf("%s_%d.pcap", base, i))
+	}
+	pw, err := NewPcapWriter(path)
+	if err != nil {
+		return err
+	}
+	rw.cur = pw
+	rw.curPath = path
+	rw.size = pcapGlobalLen
+	rw.opened = now
+	return rw.writeBlocks()
+}
+
+// SetBlocks sets the anchor and tag blocks written at the start of every
+// file, so that each file replays on its own, and writes them to the
+// current file.
+func (rw *RotatingPcapWriter) SetBlocks(anchors []AnchorInfo, tags []TagHeight) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.anchors = append([]AnchorInfo(nil), anchors...)
+	rw.tags = append([]TagHeight(nil), tags...)
+	return rw.writeBlocks()
+}
+
+func (rw *RotatingPcapWriter) writeBlocks() error {
+	if err := rw.cur.WriteBlocks(time.Now(), rw.anchors, rw.tags); err != nil {
+		return err
+	}
+	rw.size += blocksLen(len(rw.anchors), len(rw.tags))
+	return nil
+}
+
+func (rw *RotatingPcapWriter) rotate() error {
+	closed := rw.curPath
+	if err := rw.cur.Close(); err != nil {
+		return err
+	}
+	if err := rw.open(); err != nil {
+		return err
+	}
+	if rw.OnRotate != nil {
+		go rw.OnRotate(closed)
+	}
+	return nil
+}
+
+// WritePacket has the same semantics as PcapWriter.WritePacket.
+func (rw *RotatingPcapWriter) WritePacket(flag uint16, addr *net.UDPAddr, data []byte) error {
+	return rw.WritePacketAt(time.Now(), flag, addr, data)
+}
+
+// WritePacketAt has the same semantics as PcapWriter.WritePacketAt. Rotation
+// still follows wall-clock age, not the record timestamps.
+func (rw *RotatingPcapWriter) WritePacketAt(ts time.Time, flag uint16, addr *net.UDPAddr, data []byte) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if (rw.maxSize > 0 && rw.size >= rw.maxSize) || (rw.maxAge > 0 && time.Since(rw.opened) >= rw.maxAge) {
+		if err := rw.rotate(); err != nil {
+			return err
+		}
+	}
+	if err := rw.cur.WritePacketAt(ts, flag, addr, data); err != nil {
+		return err
+	}
+	rw.size += int64(pcapRecordLen + phdr2Len + len(ipv6Of(addr)) + len(data))
+	return nil
+}
+
+// Close closes the current file. OnRotate is not called for it.
+func (rw *RotatingPcapWriter) Close() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.cur.Close()
+}
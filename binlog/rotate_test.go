@@ -0,0 +1,71 @@
+package binlog
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatedFilesStartWithBlocks(t *testing.T) {
+	dir := t.TempDir()
+	rw, err := NewRotatingPcapWriter(dir, "PKTSBIN", 600, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchors := []AnchorInfo{
+		{AnchorID: 0xA1001234, X: 12.5, Y: -3.25, Z: 3, Region: 2},
+		{AnchorID: 0x5678, X: 0, Y: 20, Z: 2.8, Region: 1},
+	}
+	tags := []TagHeight{{TagID: 0xB50AC, Height: 1.2}}
+	if err := rw.SetBlocks(anchors, tags); err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 9000}
+	for i := 0; i < 20; i++ {
+		pkt, err := BuildRawUp(0x5A5A, 0xB50AC, -60, twrFrame(t, uint8(i), 0x1A2B3C, 3.21))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rw.WritePacket(0x109, addr, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "PKTSBIN_*.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("%d files written, want the capture rotated", len(files))
+	}
+	events := 0
+	for _, path := range files {
+		p := NewBinlogParser(path)
+		if err := p.Parse(); err != nil {
+			t.Fatal(err)
+		}
+		events += len(p.Events)
+		if len(p.Anchors) != len(anchors) || len(p.Tags) != len(tags) {
+			t.Errorf("%s: %d anchors, %d tags, want %d and %d", filepath.Base(path), len(p.Anchors), len(p.Tags), len(anchors), len(tags))
+			continue
+		}
+		for i, a := range anchors {
+			if p.Anchors[i] != a {
+				t.Errorf("%s: anchor %d read back as %+v, want %+v", filepath.Base(path), i, p.Anchors[i], a)
+			}
+		}
+		if p.Tags[0] != tags[0] {
+			t.Errorf("%s: tag read back as %+v, want %+v", filepath.Base(path), p.Tags[0], tags[0])
+		}
+		if fi, err := os.Stat(path); err == nil && fi.Size() > 600+200 {
+			t.Errorf("%s: %d bytes, well past the 600 byte limit", filepath.Base(path), fi.Size())
+		}
+	}
+	if events != 20 {
+		t.Errorf("%d events across %d files, want 20", events, len(files))
+	}
+}
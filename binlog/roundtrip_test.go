@@ -0,0 +1,124 @@
+package binlog
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+var roundTripPrefix = uint8(12)
+
+// roundTripFrames cover every frame type the parser decodes plus the
+// seconds-prefix encoding.
+var roundTripFrames = []InnerFrame{
+	{Addr: 0xB50AC, Type: 0x50, Samples: []Sample{
+		{AnchorID: 0x1A2B3C, RangeM: 3.21},
+		{AnchorID: 0x00F00D, RangeM: 0.05},
+		{AnchorID: 0x0BEEF, RangeM: 655.35},
+	}},
+	{Addr: 0xB50AC, Type: 0x50, SecondsPrefix: &roundTripPrefix, Samples: []Sample{
+		{AnchorID: 0x345678, RangeM: 81.24},
+	}},
+	{Addr: 0xB50AD, Type: 0x52, Samples: []Sample{
+		{AnchorID: 0x2B3C, RangeM: 12.34},
+		{AnchorID: 0xFFFF, RangeM: 1.00},
+	}},
+	{Addr: 0xB50AC, Type: 0x60, Samples: []Sample{
+		{AnchorID: 0x1A2B3C, RSSIDb: -71},
+		{AnchorID: 0x000001, RSSIDb: -128},
+	}},
+	{Addr: 0xB50AD, Type: 0x61, Samples: []Sample{
+		{AnchorID: 0x2B3C, RSSIDb: -90},
+	}},
+	{Addr: 0xB50AC, Type: 0x90, IMU: &IMUSample{
+		Distance: 123.5, YawDeg: 271.5, SpeedMps: 1.23, MotionCode: 1, YawSigmaCode: 5, DsSigmaCode: 2,
+	}},
+}
+
+// TestPcapRoundTrip writes roundTripFrames through PcapWriter and checks
+// that BinlogParser reads back the same frames and record times.
+func TestPcapRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := NewPcapWriterFromWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Unix(1700000000, 250000000)
+	addrs := []*net.UDPAddr{
+		{IP: net.IPv4(192, 168, 1, 20), Port: 9000},
+		{IP: net.ParseIP("fd00::20"), Port: 9000},
+	}
+	for i, f := range roundTripFrames {
+		inner, err := EncodeInner(f, uint8(i))
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		pkt, err := BuildRawUp(0x5A5A, f.Addr, -60, inner)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		ts := base.Add(time.Duration(i) * 100 * time.Millisecond)
+		if err := pw.WritePacketAt(ts, 0x109, addrs[i%len(addrs)], pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := NewBinlogParserFromReader(&buf)
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Events) != len(roundTripFrames) {
+		t.Fatalf("read %d events, wrote %d", len(p.Events), len(roundTripFrames))
+	}
+	for i, evt := range p.Events {
+		wantTs := float64(base.Add(time.Duration(i)*100*time.Millisecond).UnixMicro()) / 1e6
+		if math.Abs(evt.Timestamp-wantTs) > 1e-6 {
+			t.Errorf("event %d: timestamp %.6f, want %.6f", i, evt.Timestamp, wantTs)
+		}
+		if len(evt.Inner) != 1 {
+			t.Errorf("event %d: %d inner frames, want 1", i, len(evt.Inner))
+			continue
+		}
+		compareFrame(t, i, evt.Inner[0], roundTripFrames[i], uint8(i))
+	}
+	if p.CRCFailed > 0 {
+		t.Errorf("%d CRC failures", p.CRCFailed)
+	}
+}
+
+func compareFrame(t *testing.T, i int, got, want InnerFrame, seq uint8) {
+	t.Helper()
+	if got.Addr != want.Addr || got.Type != want.Type {
+		t.Errorf("event %d: frame %X/%s, want %X/%s", i, got.Addr, FrameTypeName(got.Type), want.Addr, FrameTypeName(want.Type))
+		return
+	}
+	if (got.SecondsPrefix == nil) != (want.SecondsPrefix == nil) ||
+		(got.SecondsPrefix != nil && *got.SecondsPrefix != *want.SecondsPrefix) {
+		t.Errorf("event %d: seconds prefix %v, want %v", i, got.SecondsPrefix, want.SecondsPrefix)
+	}
+	if want.IMU != nil {
+		g, w := got.IMU, want.IMU
+		if g == nil {
+			t.Errorf("event %d: IMU sample missing", i)
+			return
+		}
+		if math.Abs(g.Distance-w.Distance) > 1e-3 || math.Abs(g.YawDeg-w.YawDeg) > 360.0/8192 ||
+			math.Abs(g.SpeedMps-w.SpeedMps) > 0.005 || g.MotionCode != w.MotionCode ||
+			g.YawSigmaCode != w.YawSigmaCode || g.DsSigmaCode != w.DsSigmaCode {
+			t.Errorf("event %d: IMU %+v, want %+v", i, *g, *w)
+		}
+		return
+	}
+	if len(got.Samples) != len(want.Samples) {
+		t.Errorf("event %d: %d samples, want %d", i, len(got.Samples), len(want.Samples))
+		return
+	}
+	for j, s := range got.Samples {
+		w := want.Samples[j]
+		if s.AnchorID != w.AnchorID || s.RSSIDb != w.RSSIDb || s.Seq != seq || math.Abs(s.RangeM-w.RangeM) > 0.005 {
+			t.Errorf("event %d sample %d: %+v, want %+v (seq %d)", i, j, s, w, seq)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package binlog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// frameTypeNames names the inner frame types the parser decodes.
+var frameTypeNames = map[uint8]string{
+	0x50: "TWR",
+	0x52: "TWR_S",
+	0x60: "RSSI",
+	0x61: "RSSI_S",
+	0x90: "IMU",
+}
+
+// FrameTypeName returns the short name of an inner frame type, or its hex
+// code when unknown.
+func FrameTypeName(t uint8) string {
+	if name, ok := frameTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", t)
+}
+
+// ParserStats summarises a parsed capture.
+type ParserStats struct {
+	Events       int
+	FramesByType map[uint8]int
+	Tags         int // distinct frame addresses
+	Anchors      int // distinct anchor IDs in TWR/RSSI samples
+	FirstTs      float64
+	LastTs       float64 // seconds, pcap record time
+	CRCFailed    int
+
+	// TimestampJumps is the clock jump count Parse found (see
+	// FindTimestampJumps).
+	TimestampJumps int
+}
+
+// Span is the time between the first and last event in seconds.
+func (s ParserStats) Span() float64 {
+	return s.LastTs - s.FirstTs
+}
+
+func (s ParserStats) String() string {
+	types := make([]int, 0, len(s.FramesByType))
+	for t := range s.FramesByType {
+		types = append(types, int(t))
+	}
+	sort.Ints(types)
+	frames := make([]string, len(types))
+	for i, t := range types {
+		frames[i] = fmt.Sprintf("%s=%d", FrameTypeName(uint8(t)), s.FramesByType[uint8(t)])
+	}
+	return fmt.Sprintf("%d events over %.1fs, frames: %s, %d tags, %d anchors, %d CRC failures, %d timestamp jumps",
+		s.Events, s.Span(), strings.Join(frames, " "), s.Tags, s.Anchors, s.CRCFailed, s.TimestampJumps)
+}
+
+// Stats counts the parsed events and their frames. Call after Parse.
+func (p *BinlogParser) Stats() ParserStats {
+	st := ParserStats{
+		Events:       len(p.Events),
+		FramesByType: map[uint8]int{},
+		CRCFailed:    p.CRCFailed,
+
+		TimestampJumps: p.TimestampJumps,
+	}
+	tags := map[uint32]bool{}
+	anchors := map[int]bool{}
+	for i, evt := range p.Events {
+		if i == 0 || evt.Timestamp < st.FirstTs {
+			st.FirstTs = evt.Timestamp
+		}
+		if i == 0 || evt.Timestamp > st.LastTs {
+			st.LastTs = evt.Timestamp
+		}
+		for _, in := range evt.Inner {
+			st.FramesByType[in.Type]++
+			tags[in.Addr] = true
+			for _, s := range in.Samples {
+				anchors[s.AnchorID] = true
+			}
+		}
+	}
+	st.Tags = len(tags)
+	st.Anchors = len(anchors)
+	return st
+}
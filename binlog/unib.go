@@ -0,0 +1,138 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// TypeRawUp is the LORA_RAWDATA_UP packet type whose body carries a tag's
+// inner frames.
+const TypeRawUp = 0x48
+
+// MaxUnibBody is the longest body the 11-bit UNIB length field can carry.
+const MaxUnibBody = 0x7FF
+
+// BuildUnib frames body as a UNIB packet the parser accepts: magic,
+// address, type/flags and length header, body and a CRC16 over header and
+// body.
+func BuildUnib(addr uint32, pktType, flags uint8, body []byte) ([]byte, error) {
+	if len(body) > MaxUnibBody {
+		return nil, fmt.Errorf("unib body of %d bytes exceeds %d", len(body), MaxUnibBody)
+	}
+	buf := make([]byte, unibHdrLen+len(body)+2)
+	binary.LittleEndian.PutUint16(buf[0:2], unibMagic)
+	binary.LittleEndian.PutUint32(buf[2:6], addr)
+	buf[6] = (pktType&0x1F)<<3 | flags&0x7
+	buf[7] = uint8(len(body)&0x7)<<5 | (pktType>>5)&0x1F
+	buf[8] = uint8(len(body) >> 3)
+	copy(buf[unibHdrLen:], body)
+	crc := crc16(buf[:unibHdrLen+len(body)])
+	binary.LittleEndian.PutUint16(buf[unibHdrLen+len(body):], crc)
+	return buf, nil
+}
+
+// BuildRawUp wraps inner UNIB packets (see EncodeInner) in a
+// LORA_RAWDATA_UP packet from gateway gwID, as gateways send them.
+func BuildRawUp(gwID uint32, deviceID uint32, rssi int16, inner ...[]byte) ([]byte, error) {
+	body := make([]byte, 6)
+	binary.LittleEndian.PutUint32(body[0:4], deviceID)
+	binary.LittleEndian.PutUint16(body[4:6], uint16(rssi))
+	for _, in := range inner {
+		body = append(body, in...)
+	}
+	return BuildUnib(gwID, TypeRawUp, 0, body)
+}
+
+// EncodeInner encodes f as an inner UNIB packet that decodes back to the
+// same samples: TWR ranges to the centimeter,
+// BLE RSSI and IMU fields at their wire resolution. seq is the frame's
+// sequence number. A non-nil SecondsPrefix is sent as the seconds prefix.
+func EncodeInner(f InnerFrame, seq uint8) ([]byte, error) {
+	var body []byte
+	switch f.Type {
+	case 0x50, 0x52:
+		b, err := encodeTwr(f.Samples, seq, f.Type == 0x52)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	case 0x60, 0x61:
+		b, err := encodeRssi(f.Samples, seq, f.Type == 0x61)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	case 0x90:
+		if f.IMU == nil {
+			return nil, fmt.Errorf("imu frame without IMU sample")
+		}
+		body = encodeIMU(f.IMU, seq)
+	default:
+		return nil, fmt.Errorf("unsupported frame type 0x%02X", f.Type)
+	}
+	var flags uint8
+	if f.SecondsPrefix != nil {
+		flags |= secondsFlag
+		body = append([]byte{*f.SecondsPrefix}, body...)
+	}
+	return BuildUnib(f.Addr, f.Type, flags, body)
+}
+
+func encodeTwr(samples []Sample, seq uint8, short bool) ([]byte, error) {
+	if len(samples) > 15 {
+		return nil, fmt.Errorf("twr frame holds at most 15 samples, got %d", len(samples))
+	}
+	meta := uint8(len(samples)) << 4
+	for _, s := range samples {
+		if s.AnchorID < 0 || (short && s.AnchorID > 0xFFFF) || s.AnchorID > 0xFFFFFF {
+			return nil, fmt.Errorf("anchor id %X does not fit the frame", s.AnchorID)
+		}
+	}
+	body := []byte{seq, meta}
+	addrLen := 3
+	if short {
+		addrLen = 2
+	}
+	for _, s := range samples {
+		raw := math.Round(s.RangeM * 100)
+		if raw < 0 || raw > math.MaxUint16 {
+			return nil, fmt.Errorf("range %.2f m out of range", s.RangeM)
+		}
+		for i := 0; i < addrLen; i++ {
+			body = append(body, uint8(s.AnchorID>>(8*i)))
+		}
+		body = binary.LittleEndian.AppendUint16(body, uint16(raw))
+	}
+	return body, nil
+}
+
+func encodeRssi(samples []Sample, seq uint8, short bool) ([]byte, error) {
+	if len(samples) > 15 {
+		return nil, fmt.Errorf("rssi frame holds at most 15 samples, got %d", len(samples))
+	}
+	body := []byte{seq, uint8(len(samples)) << 4}
+	for _, s := range samples {
+		if s.RSSIDb < math.MinInt8 || s.RSSIDb > math.MaxInt8 {
+			return nil, fmt.Errorf("rssi %d dB out of range", s.RSSIDb)
+		}
+		if s.AnchorID < 0 || (short && s.AnchorID > 0xFFFF) || s.AnchorID > 0xFFFFFF {
+			return nil, fmt.Errorf("anchor id %X does not fit the frame", s.AnchorID)
+		}
+		body = binary.LittleEndian.AppendUint16(body, uint16(s.AnchorID))
+		if !short {
+			body = append(body, uint8(s.AnchorID>>16))
+		}
+		body = append(body, uint8(int8(s.RSSIDb)))
+	}
+	return body, nil
+}
+
+func encodeIMU(m *IMUSample, seq uint8) []byte {
+	body := []byte{seq}
+	body = binary.LittleEndian.AppendUint32(body, math.Float32bits(float32(m.Distance)))
+	yaw := uint32(math.Round(math.Mod(m.YawDeg+360, 360)*8192/360)) & 0x1FFF
+	word1 := yaw | uint32(m.MotionCode&0x7)<<13 | uint32(m.YawSigmaCode&0x7)<<16 | uint32(m.DsSigmaCode&0x7)<<19
+	body = binary.LittleEndian.AppendUint32(body, word1)
+	return binary.LittleEndian.AppendUint16(body, uint16(math.Round(m.SpeedMps*100)))
+}
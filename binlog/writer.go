@@ -2,7 +2,9 @@ package binlog
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
 	"sync"
@@ -11,6 +13,19 @@ import (
 
 const (
 	PcapMagic = 0xA1B2C3D4
+
+	// PhdrFlagIPv6 is set in the phdr2 flag when the gateway address is
+	// IPv6. The 4-byte ip field is then zero and the 16-byte address
+	// follows phdr2, ahead of the payload (and counted in incl_len). IPv4
+	// records keep the original 8-byte layout.
+	PhdrFlagIPv6 = 0x200
+
+	phdr2IPv6Len = 16
+
+	// Item sizes of the anchor and tag blocks: id(8) x(4) y(4) z(4)
+	// region(2) and id(8) height(4), lengths in centimeters.
+	anchorItemLen = 22
+	tagItemLen    = 12
 )
 
 type PcapWriter struct {
@@ -25,13 +40,26 @@ func NewPcapWriter(path string) (*PcapWriter, error) {
 		return nil, err
 	}
 
+	pw, err := NewPcapWriterFromWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return pw, nil
+}
+
+// NewPcapWriterFromWriter writes a pcap stream to w, e.g. a network
+// connection, a bytes.Buffer or a gzip.Writer, starting with the global
+// header. Close closes w if it is an io.Closer and is a no-op otherwise;
+// for a gzip.Writer that finishes the stream but leaves the underlying
+// file open.
+func NewPcapWriterFromWriter(w io.Writer) (*PcapWriter, error) {
 	pw := &PcapWriter{
-		w:   f,
+		w:   w,
 		buf: make([]byte, 32), // reused buffer for headers
 	}
 
 	if err := pw.writeGlobalHeader(); err != nil {
-		f.Close()
 		return nil, err
 	}
 
@@ -54,16 +82,26 @@ func (pw *PcapWriter) writeGlobalHeader() error {
 }
 
 func (pw *PcapWriter) WritePacket(flag uint16, addr *net.UDPAddr, data []byte) error {
+	return pw.WritePacketAt(time.Now(), flag, addr, data)
+}
+
+// WritePacketAt is WritePacket with an explicit record timestamp, for
+// re-logging replayed packets under their (possibly rebased) capture time.
+func (pw *PcapWriter) WritePacketAt(ts time.Time, flag uint16, addr *net.UDPAddr, data []byte) error {
 	pw.mu.Lock()
 	defer pw.mu.Unlock()
 
-	now := time.Now()
-	tsSec := uint32(now.Unix())
-	tsUsec := uint32(now.Nanosecond() / 1000)
+	tsSec := uint32(ts.Unix())
+	tsUsec := uint32(ts.Nanosecond() / 1000)
+
+	ip6 := ipv6Of(addr)
+	if ip6 != nil {
+		flag |= PhdrFlagIPv6
+	}
 
 	payloadLen := len(data)
 	phdr2Len := 8
-	totalLen := uint32(payloadLen + phdr2Len)
+	totalLen := uint32(payloadLen + phdr2Len + len(ip6))
 
 	// 1. Standard Record Header (16 bytes)
 	// ts_sec(4), ts_usec(4), incl_len(4), orig_len(4)
@@ -99,6 +137,11 @@ func (pw *PcapWriter) WritePacket(flag uint16, addr *net.UDPAddr, data []byte) e
 	if _, err := pw.w.Write(pw.buf[:8]); err != nil {
 		return err
 	}
+	if ip6 != nil {
+		if _, err := pw.w.Write(ip6); err != nil {
+			return err
+		}
+	}
 
 	// 3. Payload
 	if _, err := pw.w.Write(data); err != nil {
@@ -108,6 +151,98 @@ func (pw *PcapWriter) WritePacket(flag uint16, addr *net.UDPAddr, data []byte) e
 	return nil
 }
 
+// WriteBlocks writes an anchor block and a tag block, the records replay
+// reads the deployment from, stamped ts. An empty list writes no block.
+func (pw *PcapWriter) WriteBlocks(ts time.Time, anchors []AnchorInfo, tags []TagHeight) error {
+	if len(anchors) > 0 {
+		payload := make([]byte, len(anchors)*anchorItemLen)
+		for i, a := range anchors {
+			b := payload[i*anchorItemLen:]
+			binary.LittleEndian.PutUint64(b[0:], a.AnchorID)
+			binary.LittleEndian.PutUint32(b[8:], uint32(int32(math.Round(a.X*100))))
+			binary.LittleEndian.PutUint32(b[12:], uint32(int32(math.Round(a.Y*100))))
+			binary.LittleEndian.PutUint32(b[16:], uint32(int32(math.Round(a.Z*100))))
+			binary.LittleEndian.PutUint16(b[20:], a.Region)
+		}
+		if err := pw.writeBlock(ts, flagAnchor, len(anchors), anchorItemLen, payload); err != nil {
+			return err
+		}
+	}
+	if len(tags) > 0 {
+		payload := make([]byte, len(tags)*tagItemLen)
+		for i, t := range tags {
+			b := payload[i*tagItemLen:]
+			binary.LittleEndian.PutUint64(b[0:], t.TagID)
+			binary.LittleEndian.PutUint32(b[8:], uint32(int32(math.Round(t.Height*100))))
+		}
+		if err := pw.writeBlock(ts, flagTag, len(tags), tagItemLen, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBlock writes one block record: phdr2 port holds the item count and
+// ip the item size.
+func (pw *PcapWriter) writeBlock(ts time.Time, flag uint16, itemnum, itemsize int, payload []byte) error {
+	if itemnum > 0xFFFF {
+		return fmt.Errorf("block of %d items exceeds %d", itemnum, 0xFFFF)
+	}
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	totalLen := uint32(phdr2Len + len(payload))
+	binary.LittleEndian.PutUint32(pw.buf[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(pw.buf[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(pw.buf[8:], totalLen)
+	binary.LittleEndian.PutUint32(pw.buf[12:], totalLen)
+	binary.LittleEndian.PutUint16(pw.buf[16:], flag)
+	binary.LittleEndian.PutUint16(pw.buf[18:], uint16(itemnum))
+	binary.LittleEndian.PutUint32(pw.buf[20:], uint32(itemsize))
+	if _, err := pw.w.Write(pw.buf[:pcapRecordLen+phdr2Len]); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(payload)
+	return err
+}
+
+// blocksLen is the number of bytes WriteBlocks writes for the given list
+// lengths.
+func blocksLen(nAnchors, nTags int) int64 {
+	n := 0
+	if nAnchors > 0 {
+		n += pcapRecordLen + phdr2Len + nAnchors*anchorItemLen
+	}
+	if nTags > 0 {
+		n += pcapRecordLen + phdr2Len + nTags*tagItemLen
+	}
+	return int64(n)
+}
+
+// ipv6Of returns addr's 16-byte IP if it is an IPv6 (not IPv4-mapped)
+// address, else nil.
+func ipv6Of(addr *net.UDPAddr) net.IP {
+	if addr == nil || addr.IP.To4() != nil || len(addr.IP) != net.IPv6len {
+		return nil
+	}
+	return addr.IP
+}
+
+// SplitPhdr2IPv6 strips the IPv6 marker from a phdr2 flag. For IPv6
+// records it also returns the address and the rest of body, which is the
+// record data following phdr2; otherwise ip is nil and body is unchanged.
+func SplitPhdr2IPv6(flag uint16, body []byte) (baseFlag uint16, ip net.IP, rest []byte, err error) {
+	if flag&PhdrFlagIPv6 == 0 {
+		return flag, nil, body, nil
+	}
+	if len(body) < phdr2IPv6Len {
+		return flag, nil, body, fmt.Errorf("phdr2 ipv6 address truncated")
+	}
+	ip = make(net.IP, phdr2IPv6Len)
+	copy(ip, body[:phdr2IPv6Len])
+	return flag &^ PhdrFlagIPv6, ip, body[phdr2IPv6Len:], nil
+}
+
 func (pw *PcapWriter) Close() error {
 	if c, ok := pw.w.(io.Closer); ok {
 		return c.Close()
@@ -0,0 +1,74 @@
+package binlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"testing"
+	"time"
+)
+
+type closeCounter struct {
+	bytes.Buffer
+	closed int
+}
+
+func (c *closeCounter) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestPcapWriterFromWriterGzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	pw, err := NewPcapWriterFromWriter(zw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 9000}
+	for i := 0; i < 3; i++ {
+		pkt, err := BuildRawUp(0x5A5A, 0xB50AC, -60, twrFrame(t, uint8(i), 0x1A2B3C, 3.21))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pw.WritePacketAt(time.Unix(1700000000+int64(i), 0), 0x109, addr, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewBinlogParserFromReader(&buf)
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Events) != 3 {
+		t.Fatalf("read back %d events, want 3", len(p.Events))
+	}
+	for i, ev := range p.Events {
+		if len(ev.Inner) != 1 || ev.Inner[0].Samples[0].Seq != uint8(i) {
+			t.Errorf("event %d: %+v, want the frame with seq %d", i, ev.Inner, i)
+		}
+	}
+}
+
+func TestPcapWriterClose(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := NewPcapWriterFromWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := buf.Len()
+	if err := pw.Close(); err != nil || buf.Len() != n {
+		t.Errorf("Close on a bytes.Buffer: %v, %d bytes after %d", err, buf.Len(), n)
+	}
+
+	c := &closeCounter{}
+	if pw, err = NewPcapWriterFromWriter(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Close(); err != nil || c.closed != 1 {
+		t.Errorf("Close on an io.Closer: %v, closed %d times, want once", err, c.closed)
+	}
+}
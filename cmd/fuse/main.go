@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"math"
@@ -26,18 +28,82 @@ func main() {
 	tsOffset := flag.Int64("ts-offset-ms", 0, "Timestamp offset ms to align with engine output")
 	refPath := flag.String("ref", "", "Optional reference CSV for RMSE")
 	maxShift := flag.Int("max-shift", 400, "Max frame shift for RMSE")
+	metric := flag.String("metric", "rmse", "Reference comparison metric: rmse (best shift) or dtw")
+	maxWarp := flag.Int("max-warp", fusion.DTWWarpDefault, "Sakoe-Chiba band half-width in frames for --metric dtw")
+	outputHdop := flag.Bool("output-hdop", false, "Add an hdop column to the output CSV")
+	outputZ := flag.Bool("output-z", false, "Add a fused_z_m column with the assigned layer's height, the lowest anchor Z on the layer (0 without a layer)")
+	verbose := flag.Bool("verbose", false, "Add diagnostic columns (predict_only_count) to the output CSV")
+	format := flag.String("format", "csv", "Output format: csv or json")
+	anchorDiag := flag.String("anchor-diag", "", "Optional per-anchor diagnostics CSV path")
+	lenientCRC := flag.Bool("lenient-crc", false, "Decode UNIB frames even when their CRC does not match")
+	seqCheck := flag.Bool("seq-check", false, "Warn when more than 5% of a tag's TWR/RSSI samples are lost by sequence number; samples of out-of-range anchors are reported apart")
+	maxRange := flag.Float64("max-range", fusion.MaxTwrRange, "Longest TWR range in meters accepted for fusion (frames encode at most 655.35)")
+	calibRssi := flag.String("calibrate-rssi", "", "Fit the BLE path-loss model to a CSV of anchor_id,range_m,rssi survey samples and exit")
+	windowLen := flag.Int64("window-len-ms", fusion.BatchWindowMs, "BLE/TWR pairing window in ms; larger windows add latency but fuse more sensors per update")
+	splitUpdates := flag.Bool("split-updates", false, "Fuse every BLE and TWR frame at its own timestamp, as the live server does, instead of pairing them within -window-len-ms")
+	outScale := flag.Float64("out-scale", 1, "Output units per meter for emitted coordinates (e.g. 100 for cm)")
+	outOffsetX := flag.Float64("out-offset-x", 0, "X origin offset added to emitted coordinates, in output units")
+	outOffsetY := flag.Float64("out-offset-y", 0, "Y origin offset added to emitted coordinates, in output units")
+	outRotate := flag.Float64("out-rotate-deg", 0, "Rotate emitted coordinates counter-clockwise about the project origin by this many degrees")
+	resetGap := flag.Duration("reset-gap", fusion.ResetGap, "Reset the filter after this long without an update; raise for slow-beaconing tags")
+	predictGap := flag.Bool("predict-gap", false, "Predict across gaps longer than --reset-gap instead of resetting, while the tag is inside the map")
+	startTs := flag.Float64("start-ts", 0, "Only fuse records at or after this Unix time in seconds")
+	endTs := flag.Float64("end-ts", 0, "Only fuse records at or before this Unix time in seconds (0 = end of capture)")
+	jumpThreshold := flag.Float64("jump-threshold", binlog.DefaultJumpThreshold, "Warn about record times stepping back by more than this many seconds, or forward by more than the larger of this and 30s (clock jumps)")
 	flag.Parse()
 
+	if *calibRssi != "" {
+		if err := runRssiCalibration(*calibRssi, fusion.NewBLERssi(*signalLoss, *signalAdjust, *deployDist)); err != nil {
+			fmt.Printf("rssi calibration failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	outXform := fusion.OutputTransform{Scale: *outScale, OffsetX: *outOffsetX, OffsetY: *outOffsetY, RotationDeg: *outRotate}
+
 	if *pcapPath == "" {
 		fmt.Println("--pcap required")
 		os.Exit(1)
 	}
+	if *format != "csv" && *format != "json" {
+		fmt.Printf("unknown --format %q (want csv or json)\n", *format)
+		os.Exit(1)
+	}
+	if *metric != "rmse" && *metric != "dtw" {
+		fmt.Printf("unknown --metric %q (want rmse or dtw)\n", *metric)
+		os.Exit(1)
+	}
 
 	parser := binlog.NewBinlogParser(*pcapPath)
-	if err := parser.Parse(); err != nil {
-		fmt.Printf("parse pcap failed: %v\n", err)
+	parser.LenientCRC = *lenientCRC
+	var parseErr error
+	if *startTs != 0 || *endTs != 0 {
+		end := *endTs
+		if end == 0 {
+			end = math.Inf(1)
+		}
+		parseErr = parser.ParseTimeRange(*startTs, end)
+	} else {
+		parseErr = parser.Parse()
+	}
+	if parseErr != nil {
+		fmt.Printf("parse pcap failed: %v\n", parseErr)
 		os.Exit(1)
 	}
+	if parser.CRCFailed > 0 {
+		fmt.Printf("CRC failures: %d of %d frames (%.1f%%)\n", parser.CRCFailed, parser.CRCOk+parser.CRCFailed, 100*parser.CRCFailureRate())
+	}
+	if jumps := parser.FindTimestampJumps(*jumpThreshold); len(jumps) > 0 {
+		fmt.Printf("warning: %d timestamp jumps; filters may reset there:\n", len(jumps))
+		for i, j := range jumps {
+			if i == maxJumpsListed {
+				fmt.Printf("  ... %d more\n", len(jumps)-i)
+				break
+			}
+			fmt.Printf("  event %d: %.3f -> %.3f (%+.3fs)\n", j.EventIndex, j.Before, j.After, j.After-j.Before)
+		}
+	}
 
 	tagIDs := []int{}
 	if *allTags {
@@ -47,7 +113,7 @@ func main() {
 			os.Exit(1)
 		}
 	} else {
-		tagID, err := parseTagHex(*tagHex)
+		tagID, err := binlog.ParseHexID(*tagHex)
 		if err != nil {
 			fmt.Printf("invalid tag: %v\n", err)
 			os.Exit(1)
@@ -79,145 +145,132 @@ func main() {
 	}
 	layerManager := fusion.LayerManagerFromConfig(projectXML, wogiXML, anchors)
 
-	// map low16 -> full anchor id for resolving short ids in frames
-	low16Map := make(map[int]int)
-	for id := range anchors {
-		low := id & 0xFFFF
-		// only store if unique to avoid collisions
-		if _, exists := low16Map[low]; !exists {
-			low16Map[low] = id
+	// warn about anchors whose short ids frames cannot tell apart
+	for low, ids := range fusion.Low16Groups(anchors) {
+		hex := []string{}
+		for _, id := range ids {
+			if id > 0xFFFF {
+				hex = append(hex, fmt.Sprintf("%X", id))
+			}
+		}
+		if len(hex) > 1 {
+			fmt.Printf("warning: anchors %s share short id %04X; renumber them to avoid ambiguity\n", strings.Join(hex, ", "), low)
 		}
 	}
 
 	rssiModel := fusion.NewBLERssi(*signalLoss, *signalAdjust, *deployDist)
 
-	windowLen := int64(1000)
-
-	runTag := func(tagID int, out string) error {
-		tagHeight := parser.GetTagHeight(uint32(tagID))
+	runTag := func(tagID int, out string, diagOut string) error {
 		pipeline := fusion.NewFusionPipeline(anchors, rssiModel, dimMap, beaconLayer, beaconDims, layerManager)
-		rows := [][]string{{"seq", "fused_x_m", "fused_y_m"}}
-		seq := 1
-		pendingBle := [][2]interface{}{} // tsMs, []fusion.BLEMeas
-		pendingTwr := [][2]interface{}{}
-
-		processWindow := func(cutoff int64) bool {
-			if len(pendingBle) == 0 && len(pendingTwr) == 0 {
-				return false
-			}
-			earliest := cutoff + 1
-			if len(pendingBle) > 0 && pendingBle[0][0].(int64) < earliest {
-				earliest = pendingBle[0][0].(int64)
-			}
-			if len(pendingTwr) > 0 && pendingTwr[0][0].(int64) < earliest {
-				earliest = pendingTwr[0][0].(int64)
-			}
-			if earliest+windowLen > cutoff {
-				return false
+		pipeline.SetTagHeight(tagID, parser.GetTagHeight(uint32(tagID)))
+		pipeline.SetBatchWindow(*windowLen)
+		pipeline.SetSplitUpdates(*splitUpdates)
+		pipeline.SetMaxRange(*maxRange)
+		pipeline.SetResetGap(*resetGap)
+		pipeline.SetPredictAcrossGap(*predictGap)
+		if *seqCheck {
+			lost, c := seqLoss(parser, uint32(tagID))
+			if lost > seqLossWarn {
+				fmt.Printf("warning: tag %X lost %d of %d samples (%.1f%%) by sequence number\n", tagID, c.Lost, c.Received+c.Lost, 100*lost)
 			}
-			windowEnd := earliest + windowLen
-			var selBle []fusion.BLEMeas
-			var selTwr []fusion.TWRMeas
-			selBleTS := int64(0)
-			selTwrTS := int64(0)
-			for i, v := range pendingBle {
-				ts := v[0].(int64)
-				if ts <= windowEnd {
-					selBleTS = ts
-					selBle = v[1].([]fusion.BLEMeas)
-					pendingBle = append(pendingBle[:i], pendingBle[i+1:]...)
-					break
-				}
-			}
-			for i, v := range pendingTwr {
-				ts := v[0].(int64)
-				if ts <= windowEnd {
-					selTwrTS = ts
-					selTwr = v[1].([]fusion.TWRMeas)
-					pendingTwr = append(pendingTwr[:i], pendingTwr[i+1:]...)
-					break
-				}
+			if c.OutOfRange > 0 {
+				fmt.Printf("tag %X: %d samples missing from received frames (anchor out of range), not counted as loss\n", tagID, c.OutOfRange)
 			}
-			if selBle == nil && selTwr == nil {
-				// drop stale frames
-				nb := pendingBle[:0]
-				for _, v := range pendingBle {
-					if v[0].(int64) > windowEnd {
-						nb = append(nb, v)
-					}
-				}
-				pendingBle = nb
-				nt := pendingTwr[:0]
-				for _, v := range pendingTwr {
-					if v[0].(int64) > windowEnd {
-						nt = append(nt, v)
-					}
-				}
-				pendingTwr = nt
-				return true
-			}
-			tsOut := selBleTS
-			if selTwr != nil && (tsOut == 0 || selTwrTS < tsOut) {
-				tsOut = selTwrTS
-			}
-			res := pipeline.Process(tsOut, tagID, selBle, selTwr, tagHeight)
-			if res.Flag == 2 {
-				rows = append(rows, []string{strconv.Itoa(seq), fmt.Sprintf("%.4f", res.X), fmt.Sprintf("%.4f", res.Y)})
-				seq++
+		}
+		diagRows := [][]string{{"seq", "anchor_id_hex", "type", "rssi_or_range", "estimated_range_m", "residual_m"}}
+		if diagOut != "" {
+			pipeline.SetCaptureSample(true)
+		}
+		header := []string{"seq", "fused_x_m", "fused_y_m"}
+		if *outputZ {
+			header = append(header, "fused_z_m")
+		}
+		if *outputHdop {
+			header = append(header, "hdop")
+		}
+		if *verbose {
+			header = append(header, "predict_only_count")
+		}
+		rows := [][]string{header}
+		var js *jsonStream
+		if *format == "json" {
+			var err error
+			js, err = newJSONStream(out)
+			if err != nil {
+				return err
 			}
-			return true
 		}
 
-		for _, evt := range parser.Events {
-			bleS, twrS, imuS := parser.FilterSamples(evt, uint32(tagID))
-			// feed IMU immediately to propagate dead-reckoning
-			tsMs := int64(math.Round(evt.Timestamp*1000.0)) + *tsOffset
-
-			for _, im := range imuS {
-				if im.Distance > 0 {
-					pipeline.ProcessIMU(tsMs, float64(im.Distance), float64(im.YawDeg))
-				}
-			}
+		batches := fusion.EventBatches(parser, tagID, fusion.EventOptions{
+			OffsetMs: *tsOffset,
+			Resolver: fusion.NewAnchorResolver(anchors),
+		})
 
-			if len(bleS) == 0 && len(twrS) == 0 {
+		seq := 1
+		for _, res := range pipeline.ProcessBatch(batches) {
+			if res.Flag != 2 {
 				continue
 			}
-			if len(bleS) > 0 {
-				lst := make([]fusion.BLEMeas, 0, len(bleS))
-				for _, s := range bleS {
-					aid := s.AnchorID
-					if _, ok := anchors[aid]; !ok {
-						if full, ok := low16Map[aid&0xFFFF]; ok {
-							aid = full
-						}
-					}
-					lst = append(lst, fusion.BLEMeas{AnchorID: aid, RSSIDb: s.RSSIDb})
-				}
-				pendingBle = append(pendingBle, [2]interface{}{tsMs, lst})
+			if res.Sample != nil {
+				diagRows = append(diagRows, anchorDiagRows(seq, res, rssiModel)...)
 			}
-			if len(twrS) > 0 {
-				lst := make([]fusion.TWRMeas, 0, len(twrS))
-				for _, s := range twrS {
-					aid := s.AnchorID
-					if _, ok := anchors[aid]; !ok {
-						if full, ok := low16Map[aid&0xFFFF]; ok {
-							aid = full
-						}
-					}
-					lst = append(lst, fusion.TWRMeas{AnchorID: aid, Range: s.RangeM})
+			outX, outY := outXform.Apply(res.X, res.Y)
+			var outZ *float64
+			if *outputZ {
+				z := 0.0
+				if res.Layer != nil {
+					z, _ = layerManager.LayerZ(*res.Layer)
 				}
-				pendingTwr = append(pendingTwr, [2]interface{}{tsMs, lst})
+				if outXform.Scale != 0 {
+					z *= outXform.Scale
+				}
+				outZ = &z
 			}
-			for processWindow(tsMs) {
+			if js != nil {
+				if err := js.Write(jsonFrame{
+					Seq:         seq,
+					TimestampMs: res.TimestampMs,
+					X:           outX,
+					Y:           outY,
+					Z:           outZ,
+					Flag:        res.Flag,
+					Layer:       res.Layer,
+					NumBeacons:  res.NumBeacons,
+					UsedAnchors: res.UsedAnchors,
+					Algo:        res.Algo,
+					HDOP:        res.HDOP,
+				}); err != nil {
+					fmt.Printf("json write failed: %v\n", err)
+				}
+			} else {
+				row := []string{strconv.Itoa(seq), fmt.Sprintf("%.4f", outX), fmt.Sprintf("%.4f", outY)}
+				if outZ != nil {
+					row = append(row, fmt.Sprintf("%.4f", *outZ))
+				}
+				if *outputHdop {
+					row = append(row, fmt.Sprintf("%.3f", res.HDOP))
+				}
+				if *verbose {
+					row = append(row, strconv.Itoa(res.PredictOnlyCount))
+				}
+				rows = append(rows, row)
 			}
+			seq++
 		}
 
-		if len(parser.Events) > 0 {
-			lastTs := int64(math.Round(parser.Events[len(parser.Events)-1].Timestamp*1000.0)) + *tsOffset
-			for processWindow(lastTs + windowLen) {
+		if diagOut != "" {
+			if err := writeCSV(diagOut, diagRows); err != nil {
+				return err
 			}
+			fmt.Printf("Tag %X written %d anchor diagnostics to %s\n", tagID, len(diagRows)-1, diagOut)
+		}
+		if js != nil {
+			if err := js.Close(); err != nil {
+				return err
+			}
+			fmt.Printf("Tag %X written %d frames to %s\n", tagID, js.n, out)
+			return nil
 		}
-
 		if err := writeCSV(out, rows); err != nil {
 			return err
 		}
@@ -227,17 +280,28 @@ func main() {
 
 	for _, tagID := range tagIDs {
 		out := *outPath
+		diagOut := *anchorDiag
 		if *allTags {
-			ext := filepath.Ext(*outPath)
-			base := strings.TrimSuffix(*outPath, ext)
-			out = fmt.Sprintf("%s_%X%s", base, tagID, ext)
+			out = perTagPath(*outPath, tagID)
+			if diagOut != "" {
+				diagOut = perTagPath(diagOut, tagID)
+			}
 		}
-		if err := runTag(tagID, out); err != nil {
+		if err := runTag(tagID, out, diagOut); err != nil {
 			fmt.Printf("tag %X failed: %v\n", tagID, err)
 		}
 	}
 
-	if *refPath != "" {
+	if *refPath != "" && *format != "csv" {
+		fmt.Println("--ref comparison requires --format csv, skipping")
+	} else if *refPath != "" && *metric == "dtw" {
+		dist, steps, err := compareWithRefDTW(*outPath, *refPath, *maxWarp)
+		if err != nil {
+			fmt.Printf("dtw compare failed: %v\n", err)
+		} else {
+			fmt.Printf("ref DTW distance %.3f m over %d steps (%.3f m/step)\n", dist, steps, dist/float64(steps))
+		}
+	} else if *refPath != "" {
 		rmse, shift, err := compareWithRef(*outPath, *refPath, *maxShift)
 		if err != nil {
 			fmt.Printf("rmse compare failed: %v\n", err)
@@ -247,10 +311,66 @@ func main() {
 	}
 }
 
-func parseTagHex(s string) (int, error) {
-	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "0X")
-	v, err := strconv.ParseInt(s, 16, 64)
-	return int(v), err
+// seqLossWarn is the lost-sample fraction above which -seq-check warns.
+const seqLossWarn = 0.05
+
+// maxJumpsListed caps how many clock jumps the warning lists.
+const maxJumpsListed = 10
+
+// seqLoss estimates the fraction of a tag's per-anchor TWR/RSSI samples
+// lost in transit from sequence number gaps. Samples missing from frames
+// that arrived (the anchor out of range) are returned apart and not
+// counted as loss.
+func seqLoss(p *binlog.BinlogParser, tagID uint32) (lost float64, c binlog.SeqCounts) {
+	c = p.CountSeqGaps(p.Events, tagID)
+	if total := c.Received + c.Lost; total > 0 {
+		lost = float64(c.Lost) / float64(total)
+	}
+	return lost, c
+}
+
+// perTagPath inserts the hex tag ID before the file extension.
+func perTagPath(path string, tagID int) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	return fmt.Sprintf("%s_%X%s", base, tagID, ext)
+}
+
+// anchorDiagRows lists every anchor that contributed to a fix with its
+// measurement, the range implied by the fused position and the residual.
+// For BLE the measurement is the RSSI strength and the residual compares the
+// RSSI-derived range against the geometric one.
+func anchorDiagRows(seq int, res fusion.FusionResult, rssi *fusion.BLERssi) [][]string {
+	smp := res.Sample
+	geo := func(x, y, z float64) float64 {
+		dz := smp.TagHeight - z
+		return math.Sqrt(math.Pow(res.X-x, 2) + math.Pow(res.Y-y, 2) + dz*dz)
+	}
+	rows := [][]string{}
+	for _, t := range smp.TWR {
+		est := geo(t.X, t.Y, t.Z)
+		rows = append(rows, []string{
+			strconv.Itoa(seq),
+			fmt.Sprintf("%X", t.AnchorID),
+			"TWR",
+			fmt.Sprintf("%.3f", t.Range),
+			fmt.Sprintf("%.3f", est),
+			fmt.Sprintf("%.3f", t.Range-est),
+		})
+	}
+	for _, b := range smp.BLE {
+		est := geo(b.X, b.Y, b.Z)
+		rssiRange := 0.01 * float64(rssi.Rssi2Range(int(b.Strength)))
+		rows = append(rows, []string{
+			strconv.Itoa(seq),
+			fmt.Sprintf("%X", b.AnchorID),
+			"BLE",
+			fmt.Sprintf("%.0f", b.Strength),
+			fmt.Sprintf("%.3f", est),
+			fmt.Sprintf("%.3f", rssiRange-est),
+		})
+	}
+	return rows
 }
 
 // collectActiveTags finds tags with UWB/BLE/IMU data in the parsed events.
@@ -287,6 +407,73 @@ func writeCSV(path string, rows [][]string) error {
 	return w.Error()
 }
 
+// jsonFrame is one fused frame in --format json output.
+type jsonFrame struct {
+	Seq         int      `json:"seq"`
+	TimestampMs int64    `json:"timestamp_ms"`
+	X           float64  `json:"x"`
+	Y           float64  `json:"y"`
+	Z           *float64 `json:"z,omitempty"` // with --output-z
+	Flag        int      `json:"flag"`
+	Layer       *int     `json:"layer"`
+	NumBeacons  int      `json:"num_beacons"`
+	UsedAnchors []int    `json:"used_anchors"`
+	Algo        string   `json:"algo"`
+	HDOP        float64  `json:"hdop"`
+}
+
+// jsonStream writes a JSON array one element at a time so large captures
+// are never held in memory.
+type jsonStream struct {
+	f *os.File
+	w *bufio.Writer
+	n int
+}
+
+func newJSONStream(path string) (*jsonStream, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	if _, err := w.WriteString("["); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &jsonStream{f: f, w: w}, nil
+}
+
+func (js *jsonStream) Write(fr jsonFrame) error {
+	b, err := json.Marshal(fr)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if js.n == 0 {
+		sep = "\n"
+	}
+	if _, err := js.w.WriteString(sep); err != nil {
+		return err
+	}
+	if _, err := js.w.Write(b); err != nil {
+		return err
+	}
+	js.n++
+	return nil
+}
+
+func (js *jsonStream) Close() error {
+	if _, err := js.w.WriteString("\n]\n"); err != nil {
+		js.f.Close()
+		return err
+	}
+	if err := js.w.Flush(); err != nil {
+		js.f.Close()
+		return err
+	}
+	return js.f.Close()
+}
+
 func compareWithRef(predPath, refPath string, maxShift int) (float64, int, error) {
 	pred, err := readXY(predPath)
 	if err != nil {
@@ -296,6 +483,18 @@ func compareWithRef(predPath, refPath string, maxShift int) (float64, int, error
 	if err != nil {
 		return 0, 0, err
 	}
+	// Z counts only when both files have it
+	predZ, err := readZ(predPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	refZ, err := readZ(refPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(predZ) != len(pred) || len(refZ) != len(ref) {
+		predZ, refZ = nil, nil
+	}
 	bestShift := 0
 	bestRmse := math.MaxFloat64
 	for shift := -maxShift; shift <= maxShift; shift++ {
@@ -310,6 +509,10 @@ func compareWithRef(predPath, refPath string, maxShift int) (float64, int, error
 				dx := pred[i+shift][0] - ref[i][0]
 				dy := pred[i+shift][1] - ref[i][1]
 				sum += dx*dx + dy*dy
+				if predZ != nil {
+					dz := predZ[i+shift] - refZ[i]
+					sum += dz * dz
+				}
 			}
 		} else {
 			s := -shift
@@ -321,6 +524,10 @@ func compareWithRef(predPath, refPath string, maxShift int) (float64, int, error
 				dx := pred[i][0] - ref[i+s][0]
 				dy := pred[i][1] - ref[i+s][1]
 				sum += dx*dx + dy*dy
+				if predZ != nil {
+					dz := predZ[i] - refZ[i+s]
+					sum += dz * dz
+				}
 			}
 		}
 		rmse := math.Sqrt(sum / float64(n))
@@ -332,6 +539,70 @@ func compareWithRef(predPath, refPath string, maxShift int) (float64, int, error
 	return bestRmse, bestShift, nil
 }
 
+// compareWithRefDTW returns the DTW distance between the output and
+// reference trajectories and the length of the warping path.
+func compareWithRefDTW(predPath, refPath string, maxWarp int) (float64, int, error) {
+	pred, err := readXY(predPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	ref, err := readXY(refPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	dist, steps := fusion.DTWPath(pred, ref, maxWarp)
+	if steps == 0 {
+		return 0, 0, fmt.Errorf("no alignment within warp band %d", maxWarp)
+	}
+	return dist, steps, nil
+}
+
+// runRssiCalibration reads survey samples (anchor_id, range_m, rssi columns,
+// anchor IDs in hex) and prints the fitted model next to the current one.
+func runRssiCalibration(path string, current *fusion.BLERssi) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	recs, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(recs) <= 1 {
+		return fmt.Errorf("no rows")
+	}
+	iA, iR, iS := indexOf(recs[0], "anchor_id"), indexOf(recs[0], "range_m"), indexOf(recs[0], "rssi")
+	if iA < 0 || iR < 0 || iS < 0 {
+		return fmt.Errorf("columns anchor_id, range_m, rssi required")
+	}
+	samples := make([]fusion.CalibSample, 0, len(recs)-1)
+	for _, row := range recs[1:] {
+		if len(row) <= iA || len(row) <= iR || len(row) <= iS {
+			continue
+		}
+		aid, err1 := binlog.ParseHexID(row[iA])
+		rng, err2 := strconv.ParseFloat(strings.TrimSpace(row[iR]), 64)
+		rssi, err3 := strconv.Atoi(strings.TrimSpace(row[iS]))
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		samples = append(samples, fusion.CalibSample{AnchorID: aid, KnownRangeM: rng, RSSIDb: rssi})
+	}
+	rep := current.CalibrateFromSamples(samples)
+	if rep.Factor == 0 {
+		return fmt.Errorf("need samples at two or more distinct ranges, got %d usable", rep.Samples)
+	}
+	for i, s := range samples {
+		if !math.IsNaN(rep.Residuals[i]) {
+			fmt.Printf("anchor %X  range %6.2f m  rssi %4d  residual %+6.2f m\n", s.AnchorID, s.KnownRangeM, s.RSSIDb, rep.Residuals[i])
+		}
+	}
+	fmt.Printf("fit over %d samples: RMSE %.3f m (current model: factor %.2f adjust %.2f)\n", rep.Samples, rep.RMSE, current.Factor, current.AdjustRSSI)
+	fmt.Printf("recommended: --signal-loss-frac %.2f --signal-adjust %.2f\n", rep.Factor, rep.AdjustRSSI)
+	return nil
+}
+
 func readXY(path string) ([][2]float64, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -379,6 +650,39 @@ func readXY(path string) ([][2]float64, error) {
 	return out, nil
 }
 
+// readZ reads the fused_z_m (or z_m) column in the same row order as
+// readXY, or returns nil when the file has neither.
+func readZ(path string) ([]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	recs, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) <= 1 {
+		return nil, nil
+	}
+	idx := indexOf(recs[0], "fused_z_m")
+	if idx < 0 {
+		idx = indexOf(recs[0], "z_m")
+	}
+	if idx < 0 {
+		return nil, nil
+	}
+	out := make([]float64, 0, len(recs)-1)
+	for _, row := range recs[1:] {
+		if len(row) <= idx {
+			continue
+		}
+		z, _ := strconv.ParseFloat(row[idx], 64)
+		out = append(out, z)
+	}
+	return out, nil
+}
+
 func indexOf(arr []string, key string) int {
 	for i, v := range arr {
 		if strings.EqualFold(v, key) {
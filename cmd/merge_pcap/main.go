@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"engine-go/binlog"
+)
+
+func main() {
+	output := flag.String("o", "", "Output PCAP file")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: merge_pcap -o <merged.pcap> <input.pcap> [input.pcap...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *output == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := binlog.MergePCAPs(flag.Args(), *output); err != nil {
+		log.Fatalf("merge failed: %v", err)
+	}
+	log.Printf("Merged %d files into %s", flag.NArg(), *output)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"engine-go/binlog"
+)
+
+func main() {
+	pcapPath := flag.String("pcap", "", "Input PCAP file")
+	tagsStr := flag.String("tags", "", "Comma-separated tag IDs (hex) to keep")
+	output := flag.String("out", "", "Output PCAP file")
+	flag.Parse()
+
+	if *pcapPath == "" || *tagsStr == "" || *output == "" {
+		fmt.Fprintln(os.Stderr, "Usage: pcap_filter --pcap <in.pcap> --tags <hex,...> --out <out.pcap>")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var tags []uint32
+	for _, s := range strings.Split(*tagsStr, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 32)
+		if err != nil {
+			log.Fatalf("bad tag id %q: %v", s, err)
+		}
+		tags = append(tags, uint32(v))
+	}
+
+	n, err := binlog.FilterPCAP(*pcapPath, *output, tags)
+	if err != nil {
+		log.Fatalf("filter failed: %v", err)
+	}
+	log.Printf("Wrote %d records for %d tags to %s", n, len(tags), *output)
+}
@@ -7,8 +7,9 @@ import (
 	"io"
 	"log"
 	"net"
-	"os"
 	"time"
+
+	"engine-go/binlog"
 )
 
 const (
@@ -27,6 +28,7 @@ func main() {
 	pcapPath := flag.String("pcap", "", "Input PCAP file")
 	destAddr := flag.String("dest", "127.0.0.1:44333", "Destination UDP address")
 	speed := flag.Float64("speed", 1.0, "Replay speed multiplier (0 for max speed)")
+	progress := flag.Duration("progress", 5*time.Second, "Log progress and ETA at this interval (0 disables)")
 	flag.Parse()
 
 	if *pcapPath == "" {
@@ -45,7 +47,7 @@ func main() {
 	}
 	defer conn.Close()
 
-	f, err := os.Open(*pcapPath)
+	f, err := binlog.OpenPcap(*pcapPath)
 	if err != nil {
 		log.Fatalf("Open pcap failed: %v", err)
 	}
@@ -66,6 +68,9 @@ func main() {
 
 	bufRec := make([]byte, pcapRecordLen)
 	bufPhdr2 := make([]byte, phdr2Len)
+	bytesTotal := binlog.PcapSize(*pcapPath)
+	bytesRead := int64(pcapGlobalLen)
+	var lastReport time.Time
 
 	for {
 		// Read Record Header
@@ -79,10 +84,13 @@ func main() {
 		tsSec := binary.LittleEndian.Uint32(bufRec[0:4])
 		tsUsec := binary.LittleEndian.Uint32(bufRec[4:8])
 		inclLen := binary.LittleEndian.Uint32(bufRec[8:12])
+		bytesRead += pcapRecordLen + int64(inclLen)
 
 		if inclLen < phdr2Len {
 			// Skip malformed
-			f.Seek(int64(inclLen), io.SeekCurrent)
+			if _, err := io.CopyN(io.Discard, f, int64(inclLen)); err != nil {
+				break
+			}
 			continue
 		}
 
@@ -98,6 +106,11 @@ func main() {
 		if _, err := io.ReadFull(f, payload); err != nil {
 			log.Fatalf("Read payload failed: %v", err)
 		}
+		// Send only the UDP payload, not a stored IPv6 gateway address
+		flag, _, payload, err = binlog.SplitPhdr2IPv6(flag, payload)
+		if err != nil {
+			continue
+		}
 
 		// Skip metadata blocks
 		if flag == flagAnchor || flag == flagTag || flag == flagStats {
@@ -123,9 +136,17 @@ func main() {
 			log.Printf("Write error: %v", err)
 		}
 		count++
-		if count%1000 == 0 {
-			fmt.Printf("\rSent %d packets...", count)
+		if *progress > 0 && time.Since(lastReport) >= *progress {
+			lastReport = time.Now()
+			log.Printf("replay: %s", binlog.ReadProgress{
+				Packets:    count,
+				BytesRead:  bytesRead,
+				BytesTotal: bytesTotal,
+				FileTime:   ts - firstTs,
+				Elapsed:    time.Since(startReal),
+				Speed:      *speed,
+			})
 		}
 	}
-	fmt.Printf("\nDone. Sent %d packets.\n", count)
+	fmt.Printf("Done. Sent %d packets.\n", count)
 }
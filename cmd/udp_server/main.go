@@ -7,11 +7,14 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
 	"engine-go/binlog"
 	"engine-go/fusion"
+	"engine-go/logging"
 	"engine-go/rbc"
 	"engine-go/server"
 	"engine-go/web"
@@ -19,21 +22,70 @@ import (
 
 func main() {
 	port := flag.Int("port", 44333, "UDP port to listen on")
+	tcpPort := flag.Int("tcp-port", 0, "TCP port for gateways using persistent connections. 0 to disable.")
 	httpPort := flag.Int("http", 0, "HTTP/WebSocket port (e.g. 8080). 0 to disable.")
+	corsOrigins := flag.String("cors-origins", "", "Comma-separated origins allowed cross-origin HTTP access (e.g. http://localhost:5173, or * for any)")
 	webRoot := flag.String("web-root", "frontend/dist", "Path to web frontend dist directory")
 	projectXML := flag.String("project", "project.xml", "Path to project.xml")
+	projectJSON := flag.String("project-json", "", "Path to a JSON anchor/beacon list; takes precedence over -project for anchors and beacons (map layers and RBC senders still come from project.xml when present)")
 	wogiXML := flag.String("wogi", "wogi.xml", "Path to wogi.xml")
 	signalLoss := flag.Float64("signal-loss-frac", 3.0, "BLE path-loss exponent")
 	signalAdjust := flag.Float64("signal-adjust", 8.0, "BLE adjust A at 1m")
 	deployDist := flag.Int("deploy-dist", 800, "Deployment interval cm")
 	pcapPath := flag.String("pcap", "", "Path to output PCAP file (optional)")
+	pcapMaxMB := flag.Int64("pcap-max-mb", 0, "Rotate PCAP output after this many MB (requires -pcap directory, 0 disables)")
+	pcapMaxAge := flag.Duration("pcap-max-age", 0, "Rotate PCAP output after this duration (requires -pcap directory, 0 disables)")
 	csvPath := flag.String("csv", "", "Path to output CSV file (optional)")
 	replayPath := flag.String("replay", "", "Path to input PCAP file to replay")
 	replaySpeed := flag.Float64("speed", 1.0, "Replay speed multiplier")
 	loopReplay := flag.Bool("loop", false, "Loop replay indefinitely")
+	progress := flag.Bool("progress", false, "Print replay progress to stderr every 5 seconds")
+	rebaseTime := flag.Bool("rebase-time", false, "Shift replayed timestamps so the first packet maps to now (deltas preserved)")
+	forbiddenPath := flag.String("forbidden", "", "JSON file of forbidden regions that raise RBC warnings (optional)")
+	rbcHeader := flag.String("rbc-header", rbc.DefaultHeader, "RBC message header; its last 3 chars hold the length field")
+	rbcDelim := flag.String("rbc-delim", rbc.DefaultDelimiter, "Delimiter after the RBC header")
+	rbcTimeLayout := flag.String("rbc-time-layout", rbc.DefaultTimestampLayout, "Go time layout for RBC message timestamps, or \"unixms\" for Unix milliseconds")
+	rbcMulticast := flag.String("rbc-multicast", "", "Also send RBC messages to this UDP multicast group host:port (optional)")
+	rbcMulticastIface := flag.String("rbc-multicast-iface", "", "Network interface for -rbc-multicast (default: system choice)")
+	rbcMulticastTTL := flag.Int("rbc-multicast-ttl", rbc.DefaultMulticastTTL, "TTL for -rbc-multicast; 1 keeps traffic on the LAN")
+	rbcMulticastMask := flag.Uint("rbc-multicast-mask", rbc.FlagPosition|rbc.FlagWarning, "RBC message flag mask routed to -rbc-multicast")
+	rbcQuality := flag.Bool("rbc-quality", false, "Append the 0-100 fix quality score to RBC position messages")
+	rbcBinary := flag.Bool("rbc-binary", false, "Send RBC positions as 33-byte little-endian binary records instead of text (ignores -rbc-quality); warnings stay text")
+	layerCache := flag.Bool("layer-cache", false, "Cache layer decisions per 0.5 m cell and anchor set")
+	zoneEvents := flag.Bool("zone-events", false, "Report tag enter/exit of project/wogi regions over websocket and RBC")
+	zoneHyst := flag.Float64("zone-hysteresis", fusion.GeofenceHysteresis, "Meters a tag must be inside/outside a region before a zone event fires")
+	forwardAddr := flag.String("forward-addr", "", "Forward a copy of every raw input packet to this UDP host:port (optional)")
+	influxURL := flag.String("influx-url", "", "Write valid positions to this InfluxDB v2 server, e.g. http://host:8086 (optional)")
+	influxOrg := flag.String("influx-org", "", "InfluxDB organization")
+	influxBucket := flag.String("influx-bucket", "", "InfluxDB bucket")
+	influxToken := flag.String("influx-token", "", "InfluxDB API token")
+	rssiTau := flag.Duration("rssi-tau", 0, "Smooth BLE RSSI per anchor with this time constant before range conversion (0 disables, e.g. 2s)")
+	maxRange := flag.Float64("max-range", fusion.MaxTwrRange, "Longest TWR range in meters accepted for fusion (frames encode at most 655.35)")
+	idleTimeout := flag.Duration("idle-timeout", server.DefaultIdleTimeout, "Report a tag lost (flag -9) after this long without an update; 0 disables")
+	idleRbc := flag.Bool("idle-rbc", false, "Also send an RBC warning when a tag is reported lost")
+	idleRemove := flag.Bool("idle-remove", false, "Drop a lost tag's state and filter")
+	deadReckon := flag.Duration("dead-reckon-gap", 0, "Emit IMU dead-reckoned positions (flag 3) once a tag has had no anchor fix for this long, up to the reset gap; 0 disables")
+	resetGap := flag.Duration("reset-gap", fusion.ResetGap, "Reset a tag's filter after this long without an update; raise for slow-beaconing tags")
+	predictGap := flag.Bool("predict-gap", false, "Predict across gaps longer than -reset-gap instead of resetting, while the tag is inside the map")
+	tagHeight := flag.Float64("tag-height", fusion.DefaultTagHeight, "Mounting height in meters of tags without a height in the project file")
+	ukf := flag.Bool("ukf", false, "Use the unscented (UKF) measurement update instead of the EKF Jacobian")
+	outScale := flag.Float64("out-scale", 1, "Output units per meter for emitted coordinates (e.g. 100 for cm)")
+	outOffsetX := flag.Float64("out-offset-x", 0, "X origin offset added to emitted coordinates, in output units")
+	outOffsetY := flag.Float64("out-offset-y", 0, "Y origin offset added to emitted coordinates, in output units")
+	outRotate := flag.Float64("out-rotate-deg", 0, "Rotate emitted coordinates counter-clockwise about the project origin by this many degrees")
+	logLevel := flag.String("log-level", "info", "Minimum log level for the server, RBC and web packages: debug, info, warn or error")
 	flag.Parse()
 
-	if _, err := os.Stat(*projectXML); os.IsNotExist(err) {
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("Invalid -log-level: %v", err)
+	}
+	logging.SetLogger(logging.NewStd(nil, level))
+
+	anchorSrc := *projectXML
+	if *projectJSON != "" {
+		anchorSrc = *projectJSON
+	} else if _, err := os.Stat(*projectXML); os.IsNotExist(err) {
 		log.Fatalf("project.xml not found at %s", *projectXML)
 	}
 	if _, err := os.Stat(*wogiXML); os.IsNotExist(err) {
@@ -42,20 +94,12 @@ func main() {
 
 	// Load configuration
 	log.Println("Loading configuration...")
-	anchors := fusion.ParseProjectAnchors(*projectXML)
-	beacons := fusion.ParseProjectBeacons(*projectXML)
-	for id, b := range beacons {
-		anchors[id] = b
-	}
-
-	dimMap, beaconLayer, beaconDims := fusion.ParseWogiDims(*wogiXML)
-	for bid, lay := range beaconLayer {
-		if a, ok := anchors[bid]; ok {
-			a.Layer = lay
-			anchors[bid] = a
-		}
+	anchors, dimMap, beaconLayer, beaconDims, err := loadConfig(anchorSrc, *wogiXML)
+	if err != nil {
+		log.Fatalf("Failed to load anchors: %v", err)
 	}
 	layerManager := fusion.LayerManagerFromConfig(*projectXML, *wogiXML, anchors)
+	layerManager.SetCacheEnabled(*layerCache)
 
 	rssiModel := fusion.NewBLERssi(*signalLoss, *signalAdjust, *deployDist)
 
@@ -64,6 +108,53 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create UDP server: %v", err)
 	}
+	udpSvr.SetOutputTransform(fusion.OutputTransform{Scale: *outScale, OffsetX: *outOffsetX, OffsetY: *outOffsetY, RotationDeg: *outRotate})
+	udpSvr.SetRssiSmoothing(*rssiTau)
+	udpSvr.SetUKF(*ukf)
+	udpSvr.SetIdleTimeout(*idleTimeout, *idleRbc, *idleRemove)
+	udpSvr.SetMaxRange(*maxRange)
+	udpSvr.SetDeadReckoning(*deadReckon)
+	udpSvr.SetResetGap(*resetGap, *predictGap)
+	udpSvr.SetDefaultTagHeight(*tagHeight)
+	heights, err := fusion.LoadTagHeights(anchorSrc)
+	if err != nil {
+		log.Printf("Tag heights not loaded: %v", err)
+	} else {
+		udpSvr.SetTagHeights(heights)
+		log.Printf("Loaded %d tag heights", len(heights))
+	}
+	if *zoneEvents {
+		regions := layerManager.GeofenceRegions()
+		udpSvr.SetGeofenceMonitor(fusion.NewGeofenceMonitor(regions, *zoneHyst))
+		log.Printf("Zone events enabled for %d regions", len(regions))
+	}
+	if *forwardAddr != "" {
+		if err := udpSvr.SetForwardAddr(*forwardAddr); err != nil {
+			log.Fatalf("Failed to set up packet forwarding: %v", err)
+		}
+		log.Printf("Forwarding raw packets to %s", *forwardAddr)
+	}
+	if *influxURL != "" {
+		err := udpSvr.SetInflux(server.InfluxConfig{
+			URL:    *influxURL,
+			Org:    *influxOrg,
+			Bucket: *influxBucket,
+			Token:  *influxToken,
+		})
+		if err != nil {
+			log.Fatalf("Failed to set up InfluxDB output: %v", err)
+		}
+		log.Printf("Writing positions to InfluxDB at %s (bucket %s)", *influxURL, *influxBucket)
+	}
+
+	if *forbiddenPath != "" {
+		regions, err := server.LoadForbiddenRegions(*forbiddenPath)
+		if err != nil {
+			log.Fatalf("Failed to load forbidden regions: %v", err)
+		}
+		udpSvr.SetForbiddenRegions(regions)
+		log.Printf("Loaded %d forbidden regions", len(regions))
+	}
 
 	if *csvPath != "" {
 		if err := udpSvr.SetCSVWriter(*csvPath); err != nil {
@@ -74,18 +165,32 @@ func main() {
 	// Configure Web Server
 	if *httpPort > 0 {
 		webSvr := web.NewServer()
+		if *corsOrigins != "" {
+			webSvr.SetCORSOrigins(strings.Split(*corsOrigins, ","))
+		}
 		configDir := filepath.Dir(*projectXML)
 		// Serve static files from config directory and frontend
 		go webSvr.Start(*httpPort, *webRoot, configDir)
 		udpSvr.SetWebHub(webSvr.Hub)
 		webSvr.SetDownlinkHandler(udpSvr)
 		webSvr.SetTagProvider(udpSvr)
+		webSvr.SetRbcStatsProvider(udpSvr)
+		webSvr.SetGatewayProvider(udpSvr)
+		webSvr.SetAnchorStatusProvider(udpSvr)
+		webSvr.SetAnchorController(udpSvr)
 	}
 
 	// Configure RBC
 	rbcConfigs := fusion.ParseRbcSenders(*projectXML)
-	if len(rbcConfigs) > 0 {
+	if len(rbcConfigs) > 0 || *rbcMulticast != "" {
 		sender := rbc.NewSender()
+		sender.SetMulticastTTL(*rbcMulticastTTL)
+		if *rbcMulticast != "" {
+			if err := sender.AddMulticastUDPSender(*rbcMulticast, *rbcMulticastIface, uint32(*rbcMulticastMask)); err != nil {
+				log.Fatalf("Failed to add RBC multicast sender: %v", err)
+			}
+			log.Printf("Added RBC multicast sender: %s (mask %x, ttl %d)", *rbcMulticast, *rbcMulticastMask, *rbcMulticastTTL)
+		}
 		for _, cfg := range rbcConfigs {
 			if cfg.Type == "RBCC" || cfg.Type == "UDP" {
 				fullAddr := fmt.Sprintf("%s:%d", cfg.Addr, cfg.Port)
@@ -98,29 +203,66 @@ func main() {
 				}
 			}
 		}
+		rbcFmt, err := rbc.NewFormatter(*rbcHeader, *rbcDelim)
+		if err != nil {
+			log.Fatalf("Invalid RBC header: %v", err)
+		}
+		rbcFmt.TimestampLayout = *rbcTimeLayout
+		sender.SetFormatter(rbcFmt)
+		sender.SetBinaryMode(*rbcBinary)
 		sender.Start()
 		udpSvr.SetRbcSender(sender)
+		udpSvr.SetRbcQuality(*rbcQuality)
 		defer sender.Stop()
 	}
 
+	var rotating *binlog.RotatingPcapWriter
 	if *pcapPath != "" {
 		// Auto-generate name if directory
 		path := *pcapPath
-		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
-			path = fmt.Sprintf("%s/PKTSBIN_%s.pcap", path, time.Now().Format("20060102150405"))
-		}
+		fi, err := os.Stat(path)
+		isDir := err == nil && fi.IsDir()
+		if isDir && (*pcapMaxMB > 0 || *pcapMaxAge > 0) {
+			rw, err := binlog.NewRotatingPcapWriter(path, "PKTSBIN", *pcapMaxMB*1024*1024, *pcapMaxAge)
+			if err != nil {
+				log.Fatalf("Failed to create pcap writer: %v", err)
+			}
+			rw.OnRotate = func(closed string) { log.Printf("Closed pcap %s", closed) }
+			if err := rw.SetBlocks(pcapBlocks(anchors, heights)); err != nil {
+				log.Fatalf("Failed to write pcap anchor/tag blocks: %v", err)
+			}
+			rotating = rw
+			defer rw.Close()
+			udpSvr.SetPcapWriter(rw)
+			log.Printf("Logging packets to %s (rotating)", rw.CurrentPath())
+		} else {
+			if isDir {
+				path = fmt.Sprintf("%s/PKTSBIN_%s.pcap", path, time.Now().Format("20060102150405"))
+			}
 
-		pw, err := binlog.NewPcapWriter(path)
-		if err != nil {
-			log.Fatalf("Failed to create pcap writer: %v", err)
+			pw, err := binlog.NewPcapWriter(path)
+			if err != nil {
+				log.Fatalf("Failed to create pcap writer: %v", err)
+			}
+			defer pw.Close()
+			udpSvr.SetPcapWriter(pw)
+			log.Printf("Logging packets to %s", path)
 		}
-		defer pw.Close()
-		udpSvr.SetPcapWriter(pw)
-		log.Printf("Logging packets to %s", path)
 	}
 
 	// Start Server or Replay
 	if *replayPath != "" {
+		udpSvr.SetRebaseTime(*rebaseTime)
+		if *progress {
+			var lastReport time.Time
+			udpSvr.SetReplayProgressFunc(func(p server.ReplayProgress) {
+				if time.Since(lastReport) < 5*time.Second {
+					return
+				}
+				lastReport = time.Now()
+				fmt.Fprintf(os.Stderr, "replay: %s\n", p)
+			})
+		}
 		go func() {
 			for {
 				if err := udpSvr.Replay(*replayPath, *replaySpeed); err != nil {
@@ -141,13 +283,112 @@ func main() {
 		}()
 	} else {
 		go udpSvr.Start()
+		if *tcpPort > 0 {
+			go func() {
+				if err := udpSvr.StartTCP(*tcpPort); err != nil {
+					log.Fatalf("TCP server error: %v", err)
+				}
+			}()
+		}
 	}
 
-	// Wait for interrupt signal
+	// Wait for interrupt signal; SIGHUP reloads anchor positions and zones
+	// without dropping tag filter state.
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		log.Println("SIGHUP: reloading configuration...")
+		next, nextDims, nextBeaconLayer, nextBeaconDims, err := loadConfig(anchorSrc, *wogiXML)
+		if err != nil {
+			log.Printf("Reload aborted: %v", err)
+			continue
+		}
+		if len(next) == 0 {
+			log.Printf("Reload aborted: no anchors in %s", anchorSrc)
+			continue
+		}
+		lm := fusion.LayerManagerFromConfig(*projectXML, *wogiXML, next)
+		lm.SetCacheEnabled(*layerCache)
+		added, removed, moved := fusion.DiffAnchors(anchors, next)
+		for _, id := range added {
+			a := next[id]
+			log.Printf("Anchor %X added at (%.2f, %.2f, %.2f) layer %d", id, a.X, a.Y, a.Z, a.Layer)
+		}
+		for _, id := range removed {
+			log.Printf("Anchor %X removed", id)
+		}
+		for _, id := range moved {
+			a, old := next[id], anchors[id]
+			log.Printf("Anchor %X moved (%.2f, %.2f, %.2f) L%d -> (%.2f, %.2f, %.2f) L%d", id, old.X, old.Y, old.Z, old.Layer, a.X, a.Y, a.Z, a.Layer)
+		}
+		udpSvr.ReloadConfig(next, nextDims, nextBeaconLayer, nextBeaconDims, lm)
+		if h, err := fusion.LoadTagHeights(anchorSrc); err == nil {
+			heights = h
+			udpSvr.SetTagHeights(heights)
+		}
+		anchors = next
+		if rotating != nil {
+			if err := rotating.SetBlocks(pcapBlocks(anchors, heights)); err != nil {
+				log.Printf("Failed to write pcap anchor/tag blocks: %v", err)
+			}
+		}
+		log.Printf("Reloaded %d anchors (%d added, %d removed, %d moved)", len(next), len(added), len(removed), len(moved))
+	}
 
 	log.Println("Shutting down...")
 	udpSvr.Stop()
 }
+
+// pcapBlocks returns the anchor and tag blocks for rotated captures, one
+// entry per device in ID order; short-ID aliases are left out.
+func pcapBlocks(anchors map[int]fusion.Anchor, heights map[int]float64) ([]binlog.AnchorInfo, []binlog.TagHeight) {
+	seen := map[uint64]bool{}
+	infos := []binlog.AnchorInfo{}
+	for _, a := range anchors {
+		id := uint64(a.ID)
+		if a.FullID != 0 {
+			id = uint64(a.FullID)
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		infos = append(infos, binlog.AnchorInfo{AnchorID: id, X: a.X, Y: a.Y, Z: a.Z, Region: uint16(a.Layer)})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].AnchorID < infos[j].AnchorID })
+	tags := []binlog.TagHeight{}
+	for id, h := range heights {
+		tags = append(tags, binlog.TagHeight{TagID: uint64(id), Height: h})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].TagID < tags[j].TagID })
+	return infos, tags
+}
+
+// loadConfig reads anchors and beacons from project (project.xml or a
+// .json list) and the wogi dimension constraints, with beacons placed on
+// their wogi layer.
+func loadConfig(project, wogiXML string) (map[int]fusion.Anchor, map[int][]fusion.DimMat, map[int]int, map[int][]fusion.DimMat, error) {
+	anchors, err := fusion.LoadAnchors(project)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	beacons, err := fusion.LoadBeacons(project)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	for id, b := range beacons {
+		anchors[id] = b
+	}
+
+	dimMap, beaconLayer, beaconDims := fusion.ParseWogiDims(wogiXML)
+	for bid, lay := range beaconLayer {
+		if a, ok := anchors[bid]; ok {
+			a.Layer = lay
+			anchors[bid] = a
+		}
+	}
+	return anchors, dimMap, beaconLayer, beaconDims, nil
+}
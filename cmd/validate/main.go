@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"engine-go/fusion"
+)
+
+func main() {
+	dir := flag.String("dir", "", "Directory holding project.xml and wogi.xml")
+	projectXML := flag.String("project", "", "Path to project.xml (default: <dir>/project.xml)")
+	wogiXML := flag.String("wogi", "", "Path to wogi.xml (default: <dir>/wogi.xml)")
+	flag.Parse()
+
+	if *projectXML == "" {
+		if *dir == "" {
+			fmt.Println("--project or --dir required")
+			os.Exit(1)
+		}
+		*projectXML = filepath.Join(*dir, "project.xml")
+	}
+	if *wogiXML == "" {
+		*wogiXML = filepath.Join(filepath.Dir(*projectXML), "wogi.xml")
+	}
+
+	issues := fusion.ValidateConfig(*projectXML, *wogiXML)
+	fatal := 0
+	for _, is := range issues {
+		fmt.Println(is)
+		if is.Fatal {
+			fatal++
+		}
+	}
+	fmt.Printf("%d issues (%d fatal)\n", len(issues), fatal)
+	if fatal > 0 {
+		os.Exit(1)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"log"
 	"os"
+
+	"engine-go/binlog"
 )
 
 const (
@@ -115,6 +117,10 @@ func readPackets(path string) ([][]byte, error) {
 		if _, err := io.ReadFull(f, payload); err != nil {
 			return nil, err
 		}
+		flag, _, payload, err := binlog.SplitPhdr2IPv6(flag, payload)
+		if err != nil {
+			continue
+		}
 
 		if flag == flagAnchor || flag == flagTag || flag == flagStats {
 			continue
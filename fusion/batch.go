@@ -0,0 +1,222 @@
+package fusion
+
+import "sort"
+
+// BatchWindowMs is the default pairing window ProcessBatch uses to match BLE
+// and TWR frames into one update (see SetBatchWindow).
+const BatchWindowMs = int64(1000)
+
+// IMUMeas is one dead-reckoning report: cumulative distance (m) and yaw
+// (degrees) plus the frame's motion fields.
+type IMUMeas struct {
+	Distance float64
+	YawDeg   float64
+	Motion   IMUMotion
+}
+
+// TimedBatch holds the measurements of one capture event for a tag.
+type TimedBatch struct {
+	TimestampMs int64
+	TagID       int
+	BLE         []BLEMeas
+	TWR         []TWRMeas
+	IMU         []IMUMeas
+}
+
+// TimedBLE is one BLE frame's RSSI set and the time it was captured.
+type TimedBLE struct {
+	TimestampMs int64
+	Meas        []BLEMeas
+}
+
+// TimedTWR is one TWR frame's range set and the time it was captured.
+type TimedTWR struct {
+	TimestampMs int64
+	Meas        []TWRMeas
+}
+
+// batchWindow queues BLE/TWR frames until a lenMs window closes and pairs at
+// most one of each per update.
+type batchWindow struct {
+	lenMs   int64
+	ble     []TimedBLE
+	twr     []TimedTWR
+	results []FusionResult
+}
+
+// step processes the oldest window that ends before cutoff. progressed is
+// false when no window is ready; res is nil when the window only held stale
+// frames.
+func (w *batchWindow) step(p *FusionPipeline, tagID int, cutoff int64) (res *FusionResult, progressed bool) {
+	if len(w.ble) == 0 && len(w.twr) == 0 {
+		return nil, false
+	}
+	earliest := cutoff + 1
+	if len(w.ble) > 0 && w.ble[0].TimestampMs < earliest {
+		earliest = w.ble[0].TimestampMs
+	}
+	if len(w.twr) > 0 && w.twr[0].TimestampMs < earliest {
+		earliest = w.twr[0].TimestampMs
+	}
+	if earliest+w.lenMs > cutoff {
+		return nil, false
+	}
+	windowEnd := earliest + w.lenMs
+
+	var selBle []BLEMeas
+	var selTwr []TWRMeas
+	selBleTS, selTwrTS := int64(0), int64(0)
+	for i, v := range w.ble {
+		if v.TimestampMs <= windowEnd {
+			selBleTS, selBle = v.TimestampMs, v.Meas
+			w.ble = append(w.ble[:i], w.ble[i+1:]...)
+			break
+		}
+	}
+	for i, v := range w.twr {
+		if v.TimestampMs <= windowEnd {
+			selTwrTS, selTwr = v.TimestampMs, v.Meas
+			w.twr = append(w.twr[:i], w.twr[i+1:]...)
+			break
+		}
+	}
+	if selBle == nil && selTwr == nil {
+		// drop stale frames
+		nb := w.ble[:0]
+		for _, v := range w.ble {
+			if v.TimestampMs > windowEnd {
+				nb = append(nb, v)
+			}
+		}
+		w.ble = nb
+		nt := w.twr[:0]
+		for _, v := range w.twr {
+			if v.TimestampMs > windowEnd {
+				nt = append(nt, v)
+			}
+		}
+		w.twr = nt
+		return nil, true
+	}
+	tsOut := selBleTS
+	if selTwr != nil && (tsOut == 0 || selTwrTS < tsOut) {
+		tsOut = selTwrTS
+	}
+	r := p.Process(tsOut, tagID, selBle, selTwr, 0)
+	return &r, true
+}
+
+// drain fuses every window that has closed by cutoff.
+func (w *batchWindow) drain(p *FusionPipeline, tagID int, cutoff int64) {
+	for {
+		res, ok := w.step(p, tagID, cutoff)
+		if !ok {
+			return
+		}
+		if res != nil {
+			w.results = append(w.results, *res)
+		}
+	}
+}
+
+// SetBatchWindow sets the ProcessBatch pairing window in milliseconds.
+// Larger windows add latency but pair more BLE/TWR frames per update;
+// ms <= 0 restores BatchWindowMs.
+func (p *FusionPipeline) SetBatchWindow(ms int64) {
+	if ms <= 0 {
+		ms = BatchWindowMs
+	}
+	p.batchWindowMs = ms
+}
+
+// SetSplitUpdates makes ProcessBatch fuse every capture event on its own
+// with ProcessSplit, as the live server does, instead of pairing BLE and TWR
+// frames within SetBatchWindow windows.
+func (p *FusionPipeline) SetSplitUpdates(on bool) {
+	p.splitUpdates = on
+}
+
+// ProcessSplit fuses separately timestamped BLE and TWR sets with one
+// sequential filter update per distinct timestamp, in timestamp order, so
+// each frame advances the filter at the time it was captured rather than
+// being merged into a window. Sets sharing a timestamp are fused together.
+// Tag height is resolved as for Process; one result is returned per update,
+// whatever its Flag.
+func (p *FusionPipeline) ProcessSplit(tagID int, ble []TimedBLE, twr []TimedTWR, tagHeight float64) []FusionResult {
+	ble = append([]TimedBLE(nil), ble...)
+	twr = append([]TimedTWR(nil), twr...)
+	sort.SliceStable(ble, func(i, j int) bool { return ble[i].TimestampMs < ble[j].TimestampMs })
+	sort.SliceStable(twr, func(i, j int) bool { return twr[i].TimestampMs < twr[j].TimestampMs })
+
+	results := []FusionResult{}
+	for len(ble) > 0 || len(twr) > 0 {
+		var ts int64
+		switch {
+		case len(ble) == 0:
+			ts = twr[0].TimestampMs
+		case len(twr) == 0:
+			ts = ble[0].TimestampMs
+		default:
+			ts = min(ble[0].TimestampMs, twr[0].TimestampMs)
+		}
+		var selBle []BLEMeas
+		var selTwr []TWRMeas
+		for len(ble) > 0 && ble[0].TimestampMs == ts {
+			selBle = append(selBle, ble[0].Meas...)
+			ble = ble[1:]
+		}
+		for len(twr) > 0 && twr[0].TimestampMs == ts {
+			selTwr = append(selTwr, twr[0].Meas...)
+			twr = twr[1:]
+		}
+		if len(selBle) == 0 && len(selTwr) == 0 {
+			continue
+		}
+		results = append(results, p.Process(ts, tagID, selBle, selTwr, tagHeight))
+	}
+	return results
+}
+
+// ProcessBatch runs a time-ordered sequence of capture events through the
+// pipeline the way the offline tools do: IMU reports are applied as soon as
+// their event arrives, while BLE and TWR frames are paired within
+// SetBatchWindow windows and fused once a window has closed (or, with
+// SetSplitUpdates, fused per event by ProcessSplit). Tag height comes from
+// SetTagHeight/SetDefaultTagHeight. One result is returned per update,
+// whatever its Flag.
+func (p *FusionPipeline) ProcessBatch(samples []TimedBatch) []FusionResult {
+	w := batchWindow{lenMs: p.batchWindowMs, results: []FusionResult{}}
+	if w.lenMs <= 0 {
+		w.lenMs = BatchWindowMs
+	}
+	tagID := p.tagID
+
+	for _, b := range samples {
+		tagID = b.TagID
+		for _, im := range b.IMU {
+			if im.Distance > 0 {
+				p.ProcessIMUMotion(b.TimestampMs, im.Distance, im.YawDeg, im.Motion)
+			}
+		}
+		if len(b.BLE) == 0 && len(b.TWR) == 0 {
+			continue
+		}
+		if p.splitUpdates {
+			w.results = append(w.results, p.ProcessSplit(tagID,
+				[]TimedBLE{{TimestampMs: b.TimestampMs, Meas: b.BLE}},
+				[]TimedTWR{{TimestampMs: b.TimestampMs, Meas: b.TWR}}, 0)...)
+			continue
+		}
+		if len(b.BLE) > 0 {
+			w.ble = append(w.ble, TimedBLE{TimestampMs: b.TimestampMs, Meas: b.BLE})
+		}
+		if len(b.TWR) > 0 {
+			w.twr = append(w.twr, TimedTWR{TimestampMs: b.TimestampMs, Meas: b.TWR})
+		}
+		w.drain(p, tagID, b.TimestampMs)
+	}
+	if len(samples) > 0 {
+		w.drain(p, tagID, samples[len(samples)-1].TimestampMs+w.lenMs)
+	}
+	return w.results
+}
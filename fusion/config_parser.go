@@ -1,8 +1,12 @@
 package fusion
 
 import (
+    "encoding/json"
     "encoding/xml"
+    "fmt"
     "io"
+    "os"
+    "path/filepath"
     "strconv"
     "strings"
 )
@@ -62,9 +66,32 @@ func ParseRbcSenders(path string) []RbcSenderConfig {
 	return configs
 }
 
-// ParseProjectAnchors loads anchorlist from project.xml into Anchor map keyed by id.
+// ParseProjectAnchors loads anchorlist from project.xml into Anchor map keyed
+// by full device id, which is also each Anchor's ID, so anchors sharing the
+// low 16 bits stay apart. NewFusionPipeline adds short-id aliases for the
+// frames, which carry short ids, where the low 16 bits are unique.
 func ParseProjectAnchors(path string) map[int]Anchor {
     anchors := map[int]Anchor{}
+    for _, a := range parseProjectAnchorList(path) {
+        a.ID = int(a.FullID)
+        anchors[a.ID] = a
+    }
+    return anchors
+}
+
+// ParseProjectAnchorsFullID loads anchorlist from project.xml keyed by the
+// full device id. Anchor.ID is still the short id used on the air.
+func ParseProjectAnchorsFullID(path string) map[int64]Anchor {
+    anchors := map[int64]Anchor{}
+    for _, a := range parseProjectAnchorList(path) {
+        anchors[a.FullID] = a
+    }
+    return anchors
+}
+
+// parseProjectAnchorList returns the anchorlist entries in file order.
+func parseProjectAnchorList(path string) []Anchor {
+    anchors := []Anchor{}
     dec, f, err := readXML(path)
     if err != nil {
         return anchors
@@ -111,7 +138,7 @@ func ParseProjectAnchors(path string) map[int]Anchor {
                     continue
                 }
                 shortID := int(aid & 0xFFFF)
-                anchors[shortID] = Anchor{ID: shortID, X: x / 100.0, Y: y / 100.0, Z: z / 100.0, Layer: layer, Building: 0}
+                anchors = append(anchors, Anchor{ID: shortID, X: x / 100.0, Y: y / 100.0, Z: z / 100.0, Layer: layer, Building: 0, FullID: aid})
             }
         case xml.EndElement:
             if t.Name.Local == "anchorlist" {
@@ -122,6 +149,57 @@ func ParseProjectAnchors(path string) map[int]Anchor {
     return anchors
 }
 
+// ParseProjectTagHeights loads the mounting heights of the taglist in
+// project.xml, keyed by full tag id. Heights come from the deviceItem
+// height attribute in centimeters, like pos, and are returned in meters;
+// tags without one are left out.
+func ParseProjectTagHeights(path string) map[int]float64 {
+    heights := map[int]float64{}
+    dec, f, err := readXML(path)
+    if err != nil {
+        return heights
+    }
+    defer f.Close()
+    inTagList := false
+    for {
+        tok, err := dec.Token()
+        if err != nil {
+            break
+        }
+        switch t := tok.(type) {
+        case xml.StartElement:
+            if t.Name.Local == "taglist" {
+                inTagList = true
+                continue
+            }
+            if t.Name.Local == "deviceItem" && inTagList {
+                idStr, ok := attrValue(t, "id")
+                if !ok {
+                    continue
+                }
+                hStr, ok := attrValue(t, "height")
+                if !ok {
+                    continue
+                }
+                tid, err := strconv.ParseInt(idStr, 16, 64)
+                if err != nil {
+                    continue
+                }
+                h, err := strconv.ParseFloat(strings.TrimSpace(hStr), 64)
+                if err != nil || h <= 0 {
+                    continue
+                }
+                heights[int(tid)] = h / 100.0
+            }
+        case xml.EndElement:
+            if t.Name.Local == "taglist" {
+                inTagList = false
+            }
+        }
+    }
+    return heights
+}
+
 // ParseProjectBeacons returns beacons (BLE) as anchors.
 func ParseProjectBeacons(path string) map[int]Anchor {
     beacons := map[int]Anchor{}
@@ -182,6 +260,151 @@ func ParseProjectBeacons(path string) map[int]Anchor {
     return beacons
 }
 
+// projectJSON is the JSON alternative to the anchorlist and beaconlist of
+// project.xml:
+//
+//    {"anchors": [{"id": "ABCD", "pos": [x, y, z], "layer": N}], "beacons": [...],
+//     "tags": [{"id": "B50AC", "height": 120}]}
+//
+// ids are hex and positions and heights are in centimeters, as in
+// project.xml.
+type projectJSON struct {
+    Anchors []deviceJSON `json:"anchors"`
+    Beacons []deviceJSON `json:"beacons"`
+    Tags    []tagJSON    `json:"tags"`
+}
+
+type tagJSON struct {
+    ID     string  `json:"id"`
+    Height float64 `json:"height"`
+}
+
+type deviceJSON struct {
+    ID    string    `json:"id"`
+    Pos   []float64 `json:"pos"`
+    Layer int       `json:"layer"`
+}
+
+func readProjectJSON(path string) (*projectJSON, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var pj projectJSON
+    if err := json.Unmarshal(data, &pj); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    return &pj, nil
+}
+
+// anchor converts d the way the project.xml parsers convert a deviceItem;
+// ok is false for an entry they would skip (id not hex, pos short of x, y,
+// z).
+func (d deviceJSON) anchor() (a Anchor, ok bool) {
+    aid, err := strconv.ParseInt(d.ID, 16, 64)
+    if err != nil || len(d.Pos) < 3 {
+        return Anchor{}, false
+    }
+    shortID := int(aid & 0xFFFF)
+    return Anchor{ID: shortID, X: d.Pos[0] / 100.0, Y: d.Pos[1] / 100.0, Z: d.Pos[2] / 100.0, Layer: d.Layer, Building: 0, FullID: aid}, true
+}
+
+// ParseProjectAnchorsJSON is ParseProjectAnchors for a JSON project file
+// (see projectJSON); the result is the same as for the equivalent
+// project.xml, malformed entries included, which both skip. Only an
+// unreadable file or invalid JSON is an error.
+func ParseProjectAnchorsJSON(path string) (map[int]Anchor, error) {
+    pj, err := readProjectJSON(path)
+    if err != nil {
+        return nil, err
+    }
+    anchors := map[int]Anchor{}
+    for _, d := range pj.Anchors {
+        a, ok := d.anchor()
+        if !ok {
+            continue
+        }
+        a.ID = int(a.FullID)
+        anchors[a.ID] = a
+    }
+    return anchors, nil
+}
+
+// ParseProjectBeaconsJSON is ParseProjectBeacons for a JSON project file.
+func ParseProjectBeaconsJSON(path string) (map[int]Anchor, error) {
+    pj, err := readProjectJSON(path)
+    if err != nil {
+        return nil, err
+    }
+    beacons := map[int]Anchor{}
+    for _, d := range pj.Beacons {
+        b, ok := d.anchor()
+        if !ok {
+            continue
+        }
+        b.FullID = 0
+        beacons[b.ID] = b
+    }
+    return beacons, nil
+}
+
+// ParseProjectTagHeightsJSON is ParseProjectTagHeights for a JSON project
+// file.
+func ParseProjectTagHeightsJSON(path string) (map[int]float64, error) {
+    pj, err := readProjectJSON(path)
+    if err != nil {
+        return nil, err
+    }
+    heights := map[int]float64{}
+    for _, t := range pj.Tags {
+        tid, err := strconv.ParseInt(t.ID, 16, 64)
+        if err != nil || t.Height <= 0 {
+            continue
+        }
+        heights[int(tid)] = t.Height / 100.0
+    }
+    return heights, nil
+}
+
+// isJSONPath reports whether path names a JSON project file.
+func isJSONPath(path string) bool {
+    return strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// LoadAnchors reads the anchors of a project file, JSON for a .json
+// extension and project.xml otherwise.
+func LoadAnchors(path string) (map[int]Anchor, error) {
+    if isJSONPath(path) {
+        return ParseProjectAnchorsJSON(path)
+    }
+    if _, err := os.Stat(path); err != nil {
+        return nil, err
+    }
+    return ParseProjectAnchors(path), nil
+}
+
+// LoadBeacons is LoadAnchors for the BLE beacons.
+func LoadBeacons(path string) (map[int]Anchor, error) {
+    if isJSONPath(path) {
+        return ParseProjectBeaconsJSON(path)
+    }
+    if _, err := os.Stat(path); err != nil {
+        return nil, err
+    }
+    return ParseProjectBeacons(path), nil
+}
+
+// LoadTagHeights is LoadAnchors for the tag mounting heights.
+func LoadTagHeights(path string) (map[int]float64, error) {
+    if isJSONPath(path) {
+        return ParseProjectTagHeightsJSON(path)
+    }
+    if _, err := os.Stat(path); err != nil {
+        return nil, err
+    }
+    return ParseProjectTagHeights(path), nil
+}
+
 func display2groupID(cls string) (int, int) {
     if !strings.Contains(cls, ":") {
         return 0, 0
@@ -0,0 +1,130 @@
+package fusion
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testProjectXML = `<project>
+<anchorlist>
+<deviceItem class="1:0" id="A1001234" pos="0,0,300"/>
+<deviceItem class="1:0" id="B2001234" pos="2000,0,300"/>
+<deviceItem class="1:0" id="A1005678" pos="0,2000,300"/>
+</anchorlist>
+</project>`
+
+func writeTemp(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseProjectAnchorsKeepsFullIDs(t *testing.T) {
+	anchors := ParseProjectAnchors(writeTemp(t, "project.xml", testProjectXML))
+	if len(anchors) != 3 {
+		t.Fatalf("%d anchors, want 3 (two share short id 1234)", len(anchors))
+	}
+	for _, id := range []int{0xA1001234, 0xB2001234, 0xA1005678} {
+		a, ok := anchors[id]
+		if !ok || a.ID != id || a.FullID != int64(id) {
+			t.Errorf("anchor %X: %+v, present %v", id, a, ok)
+		}
+	}
+	if a := anchors[0xB2001234]; a.X != 20 || a.Z != 3 || a.Layer != 1 {
+		t.Errorf("anchor B2001234 at (%.2f, %.2f, %.2f) layer %d", a.X, a.Y, a.Z, a.Layer)
+	}
+
+	p := NewFusionPipeline(anchors, NewBLERssi(2, 0, 0), map[int][]DimMat{}, map[int]int{}, map[int][]DimMat{}, NewLayerManager(map[int]*Layer{}, nil))
+	if !p.HasAnchor(0x5678) {
+		t.Error("no short alias for the unique anchor 5678")
+	}
+	if p.HasAnchor(0x1234) {
+		t.Error("short alias 1234 picks one of two colliding anchors")
+	}
+}
+
+// The same project as XML and JSON, each with an anchor whose id is not hex
+// and one with a short pos, which both parsers skip.
+const (
+	parityProjectXML = `<project>
+<anchorlist>
+<deviceItem class="2:0" id="A1001234" pos="0,0,300"/>
+<deviceItem class="2:0" id="A1005678" pos="1250,-400,310"/>
+<deviceItem class="2:0" id="XYZ" pos="0,0,300"/>
+<deviceItem class="2:0" id="A100ABCD" pos="0,0"/>
+</anchorlist>
+<beaconlist>
+<deviceItem class="2:0" id="C0DE" pos="500,500,250"/>
+<deviceItem class="2:0" id="beacon" pos="0,0,250"/>
+</beaconlist>
+<taglist>
+<deviceItem id="B50AC" height="120"/>
+<deviceItem id="B50AD" height="0"/>
+<deviceItem id="tag" height="150"/>
+</taglist>
+</project>`
+	parityProjectJSON = `{
+"anchors": [
+  {"id": "A1001234", "pos": [0, 0, 300], "layer": 2},
+  {"id": "A1005678", "pos": [1250, -400, 310], "layer": 2},
+  {"id": "XYZ", "pos": [0, 0, 300], "layer": 2},
+  {"id": "A100ABCD", "pos": [0, 0], "layer": 2}
+],
+"beacons": [
+  {"id": "C0DE", "pos": [500, 500, 250], "layer": 2},
+  {"id": "beacon", "pos": [0, 0, 250], "layer": 2}
+],
+"tags": [
+  {"id": "B50AC", "height": 120},
+  {"id": "B50AD", "height": 0},
+  {"id": "tag", "height": 150}
+]
+}`
+)
+
+func TestProjectJSONMatchesXML(t *testing.T) {
+	xmlPath := writeTemp(t, "project.xml", parityProjectXML)
+	jsonPath := writeTemp(t, "project.json", parityProjectJSON)
+
+	for _, c := range []struct {
+		name string
+		load func(string) (map[int]Anchor, error)
+		want int
+	}{
+		{"anchors", LoadAnchors, 2},
+		{"beacons", LoadBeacons, 1},
+	} {
+		fromXML, err := c.load(xmlPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromJSON, err := c.load(jsonPath)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(fromXML) != c.want || !reflect.DeepEqual(fromXML, fromJSON) {
+			t.Errorf("%s: XML %+v, JSON %+v, want the same %d entries", c.name, fromXML, fromJSON, c.want)
+		}
+	}
+
+	hXML, err := LoadTagHeights(xmlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hJSON, err := LoadTagHeights(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hXML) != 1 || !reflect.DeepEqual(hXML, hJSON) {
+		t.Errorf("tag heights: XML %v, JSON %v, want the same single entry", hXML, hJSON)
+	}
+
+	if _, err := LoadAnchors(writeTemp(t, "broken.json", `{"anchors": [`)); err == nil {
+		t.Error("invalid JSON accepted")
+	}
+}
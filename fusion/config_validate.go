@@ -0,0 +1,179 @@
+package fusion
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigIssue is one problem found in a project.xml/wogi.xml pair. Fatal
+// issues make the configuration unusable for fusion; the rest degrade it.
+type ConfigIssue struct {
+	Fatal   bool
+	File    string
+	Message string
+}
+
+func (i ConfigIssue) String() string {
+	level := "WARN"
+	if i.Fatal {
+		level = "FATAL"
+	}
+	return fmt.Sprintf("%s %s: %s", level, i.File, i.Message)
+}
+
+// ValidateConfig checks a project.xml/wogi.xml pair without building a
+// pipeline. It reports anchors whose short IDs collide, anchors on layers
+// missing from the map list, zone beacons absent from the beacon list and
+// layers with no regions.
+func ValidateConfig(projectPath, wogiPath string) []ConfigIssue {
+	issues := []ConfigIssue{}
+	for _, path := range []string{projectPath, wogiPath} {
+		_, f, err := readXML(path)
+		if err != nil {
+			issues = append(issues, ConfigIssue{Fatal: true, File: path, Message: err.Error()})
+			continue
+		}
+		f.Close()
+	}
+	if len(issues) > 0 {
+		return issues
+	}
+
+	layers := parseProjectMaps(projectPath)
+	mapped := map[int]bool{}
+	for id := range layers {
+		mapped[id] = true
+	}
+
+	// Frames carry short IDs, so anchors sharing the low 16 bits cannot be
+	// told apart on the air.
+	byShort := map[int][]int64{}
+	for _, id := range projectDeviceIDs(projectPath, "anchorlist") {
+		short := int(id & 0xFFFF)
+		byShort[short] = append(byShort[short], id)
+	}
+	for _, short := range sortedKeys(byShort) {
+		if ids := byShort[short]; len(ids) > 1 {
+			full := make([]string, len(ids))
+			for i, id := range ids {
+				full[i] = fmt.Sprintf("%X", id)
+			}
+			issues = append(issues, ConfigIssue{Fatal: true, File: projectPath,
+				Message: fmt.Sprintf("anchors %s share short id %04X", strings.Join(full, ", "), short)})
+		}
+	}
+
+	anchors := ParseProjectAnchors(projectPath)
+	for _, id := range sortedKeys(anchors) {
+		if a := anchors[id]; !mapped[a.Layer] {
+			issues = append(issues, ConfigIssue{Fatal: true, File: projectPath,
+				Message: fmt.Sprintf("anchor %X is on layer %d, which is not in the map list", id, a.Layer)})
+		}
+	}
+
+	beacons := ParseProjectBeacons(projectPath)
+	unknown := map[int]bool{}
+	for _, bid := range wogiZoneBeacons(wogiPath) {
+		if _, ok := beacons[bid&0xFFFF]; !ok {
+			unknown[bid] = true
+		}
+	}
+	for _, bid := range sortedKeys(unknown) {
+		issues = append(issues, ConfigIssue{File: wogiPath,
+			Message: fmt.Sprintf("zone references beacon %X, which is not in the beacon list", bid)})
+	}
+
+	parseProjectRegions(projectPath, layers)
+	parseWogiZones(wogiPath, layers)
+	for _, id := range sortedKeys(layers) {
+		if len(layers[id].Regions) == 0 {
+			issues = append(issues, ConfigIssue{File: projectPath,
+				Message: fmt.Sprintf("layer %d has no regions; its map bounds will be used", id)})
+		}
+	}
+	return issues
+}
+
+// projectDeviceIDs lists the raw deviceItem ids under the given list element.
+func projectDeviceIDs(path, list string) []int64 {
+	ids := []int64{}
+	dec, f, err := readXML(path)
+	if err != nil {
+		return ids
+	}
+	defer f.Close()
+	inList := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == list {
+				inList = true
+				continue
+			}
+			if t.Name.Local == "deviceItem" && inList {
+				idStr, ok := attrValue(t, "id")
+				if !ok {
+					continue
+				}
+				if id, err := strconv.ParseInt(idStr, 16, 64); err == nil {
+					ids = append(ids, id)
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == list {
+				inList = false
+			}
+		}
+	}
+	return ids
+}
+
+// wogiZoneBeacons lists the beacon ids named in zone beacons= attributes.
+func wogiZoneBeacons(path string) []int {
+	ids := []int{}
+	dec, f, err := readXML(path)
+	if err != nil {
+		return ids
+	}
+	defer f.Close()
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "zone" {
+			continue
+		}
+		bStr, ok := attrValue(start, "beacons")
+		if !ok {
+			continue
+		}
+		for _, s := range strings.Split(bStr, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			if bid, err := strconv.ParseInt(s, 16, 64); err == nil {
+				ids = append(ids, int(bid))
+			}
+		}
+	}
+	return ids
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
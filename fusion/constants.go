@@ -1,5 +1,7 @@
 package fusion
 
+import "time"
+
 // Engine constants mirrored from the C++/Python implementations.
 var (
 	PathLossExp = [3]float64{2.5, 3.0, 3.5}
@@ -20,6 +22,7 @@ const (
 	SigmaN0           = 0.1
 	SigmaA0           = 1.0
 	MinDistance       = 0.1
+	DefaultTagHeight  = 1.2 // meters, when no per-tag height is known
 	StateDim          = 6
 	MaxMeaDim         = 12
 	UseAdaptive       = true
@@ -48,6 +51,118 @@ const (
 	Ep2 = 0.0067395
 )
 
+// Suspect-anchor detection: an anchor whose normalized innovation stays above
+// SuspectSigma for more than SuspectCountDefault updates has its rows
+// dropped from the measurement update until it clears.
+const (
+	SuspectSigma        = 3.0
+	SuspectCountDefault = 5
+)
+
+// Optional height state: [x, y, vx, vy, n, A, z, vz].
+const (
+	StateDimZ = 8
+	IdxZ      = 6
+	IdxVz     = 7
+	SigmaZ0   = 1.0
+	SigmaVelZ = 0.3
+	SigmaAccZ = 0.05
+	MaxVelZ   = 1.0
+	ZMin      = -50.0
+	ZMax      = 500.0
+)
+
+// Raw IMU integration: heading is pulled toward the UWB direction of travel
+// by ImuRawHeadingGain per fix, when consecutive fixes are at least
+// ImuRawMinFixStep apart.
+const (
+	ImuRawHeadingGain = 0.2
+	ImuRawMinFixStep  = 0.3
+)
+
+// LayerManager decision cache (see SetCacheEnabled).
+const (
+	LayerCacheCell = 0.5 // grid cell, meters
+	LayerCacheTTL  = time.Second
+	LayerCacheSize = 1024
+)
+
+// LayerHysteresis is the trust-rate margin by which another layer must beat
+// the tag's previous layer before GetLayerFrom switches to it.
+const LayerHysteresis = 0.1
+
+// RssiStaleAfter is how long an anchor may go unheard before BLE strength
+// smoothing (see SetRssiSmoothing) restarts it from its next raw sample.
+const RssiStaleAfter = 5 * time.Second
+
+// GeofenceHysteresis is the default margin (meters) a fix must be inside or
+// outside a region before GeofenceMonitor reports a transition.
+const GeofenceHysteresis = 0.5
+
+// OutOfOrderToleranceMs is how far behind the last processed timestamp a
+// frame may arrive and still be fused (at the last timestamp). Older frames
+// are dropped and counted in OutOfOrderDropped.
+const OutOfOrderToleranceMs = 20
+
+// MaxTwrRange is the default upper bound (meters) on TWR ranges used for
+// fusion (see SetMaxRange).
+const MaxTwrRange = 400.0
+
+// LooseSnapBackMeters is the default LooseFusor/EKF disagreement above
+// which the pipeline discards the LooseFusor output and re-seeds it from the
+// EKF (see SetLooseSnapBack).
+const LooseSnapBackMeters = 20.0
+
+// InitPosSigma is the position standard deviation (meters) used when the
+// filter is seeded with SetInitialPosition.
+const InitPosSigma = 0.5
+
+// MahalanobisGateDefault is the EKF innovation gate (see
+// EKF.MahalanobisGate).
+const MahalanobisGateDefault = 10.0
+
+// Robust Huber update (EKF.HuberDelta > 0): at most HuberIters iterated
+// passes, stopping early once the position moves less than HuberTol meters.
+const (
+	HuberDeltaDefault = 2.0
+	HuberIters        = 3
+	HuberTol          = 1e-3
+)
+
+// UKFKappa is the spread of the symmetric 2n+1 sigma point set used when
+// EKF.UseUKF is on: points sit at x ± sqrt((n+κ)P) and the centre point
+// weighs κ/(n+κ).
+const UKFKappa = 1.0
+
+// ZUPTNoise is the variance ((m/s)^2) of the zero-velocity pseudo
+// measurement applied while a tag is stationary (see EnableZUPT).
+const ZUPTNoise = 0.001
+
+// ResetGap is the default update gap past which the filter is reset (see
+// SetResetGap).
+const ResetGap = 30 * time.Second
+
+// Dead reckoning through anchor gaps (see EnableDeadReckoning).
+const (
+	FlagDeadReckoned  = 3    // FusionResult.Flag for IMU-only output
+	DeadReckonBlendMs = 2000 // output eases back to the fix over this long
+)
+
+// Default measurement caps for buildSample (see SetDimCap, SetBleCap).
+const (
+	DimCap = 5         // dimension constraints per frame
+	BleCap = MaxMeaDim // BLE rows per frame, strongest first
+)
+
+// TWRGateTolerance is the slack (meters) of the TWR geometry-consistency
+// gate: ranges to anchors i and j may differ by at most the anchors'
+// separation plus this (see SetTWRGateTolerance).
+const TWRGateTolerance = 20.0
+
+// PredictOnlyMax is the default number of consecutive predict-only EKF
+// steps after which results are flagged -3 (see SetPredictOnlyLimit).
+const PredictOnlyMax = 10
+
 // HDOP sanity cap.
 const HDOPMax = 50.0
 
@@ -0,0 +1,93 @@
+package fusion
+
+// deadReckonState tracks IMU-only output while no anchors are heard.
+type deadReckonState struct {
+	gapMs     int64 // 0 = disabled
+	lastFixTs *int64
+	active    bool       // the last output was dead-reckoned
+	lastPos   [2]float64 // last dead-reckoned output
+	blending  bool
+	blendFrom [2]float64 // output offset from the fix when anchors returned
+	blendTs   int64
+}
+
+// EnableDeadReckoning lets the pipeline keep emitting positions from IMU
+// dead reckoning once no TWR/BLE fix has been produced for gapMs, until
+// the reset gap (see SetResetGap). Such results carry FlagDeadReckoned; see
+// DeadReckon. gapMs <= 0 disables it (the default).
+func (p *FusionPipeline) EnableDeadReckoning(gapMs int64) {
+	if gapMs < 0 {
+		gapMs = 0
+	}
+	p.dr = deadReckonState{gapMs: gapMs}
+}
+
+// DeadReckon returns the dead-reckoned position at tsMs, after the IMU
+// report for tsMs has been applied with ProcessIMU. ok is false unless
+// dead reckoning is enabled, the tag had a fix, and the last one is at
+// least the configured gap and less than the reset gap old.
+func (p *FusionPipeline) DeadReckon(tsMs int64) (res FusionResult, ok bool) {
+	d := &p.dr
+	if d.gapMs <= 0 || !p.initialized || d.lastFixTs == nil {
+		return FusionResult{}, false
+	}
+	since := tsMs - *d.lastFixTs
+	if since < d.gapMs || float64(since) >= p.resetGap*1000 {
+		return FusionResult{}, false
+	}
+	x, y := p.ekf.xk[0], p.ekf.xk[1]
+	d.active = true
+	d.blending = false
+	d.lastPos = [2]float64{x, y}
+	// Keep the kinematic watchdog measuring from here, not from the last
+	// fix, when anchors come back.
+	p.lastGoodPos = [2]float64{x, y}
+	p.hasLastGood = true
+	if p.lastGoodTs == nil {
+		p.lastGoodTs = new(int64)
+	}
+	*p.lastGoodTs = tsMs
+	res = FusionResult{
+		TimestampMs: tsMs,
+		X:           x,
+		Y:           y,
+		Flag:        FlagDeadReckoned,
+		Algo:        "IMU",
+		Layer:       p.prevLayer,
+		HDOP:        p.ekf.HDOP,
+	}
+	if z, ok := p.ekf.Z(); ok {
+		res.Z = z
+	}
+	return res, true
+}
+
+// blendDeadReckon eases the output from the last dead-reckoned position to
+// the filter's fix over DeadReckonBlendMs once anchors are heard again, so
+// the reported track does not jump.
+func (p *FusionPipeline) blendDeadReckon(tsMs int64, x, y float64) (float64, float64) {
+	d := &p.dr
+	if d.active {
+		d.active = false
+		d.blending = true
+		d.blendFrom = [2]float64{d.lastPos[0] - x, d.lastPos[1] - y}
+		d.blendTs = tsMs
+	}
+	if !d.blending {
+		return x, y
+	}
+	w := 1 - float64(tsMs-d.blendTs)/DeadReckonBlendMs
+	if w <= 0 {
+		d.blending = false
+		return x, y
+	}
+	return x + w*d.blendFrom[0], y + w*d.blendFrom[1]
+}
+
+// noteFix records a TWR/BLE fix at tsMs for the dead-reckoning gap.
+func (p *FusionPipeline) noteFix(tsMs int64) {
+	if p.dr.lastFixTs == nil {
+		p.dr.lastFixTs = new(int64)
+	}
+	*p.dr.lastFixTs = tsMs
+}
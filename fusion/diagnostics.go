@@ -0,0 +1,165 @@
+package fusion
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"sync/atomic"
+)
+
+// DiagQueueLen is how many diagnostics records may wait for the writer
+// before new ones are dropped.
+const DiagQueueLen = 1000
+
+// diagMeas is one input measurement of a diagnostics record. Value is the
+// range (m) for TWR and the RSSI (dB) for BLE; Residual is measured minus
+// predicted range (m), null when the measurement did not reach the update.
+type diagMeas struct {
+	AnchorID string     `json:"anchor_id"` // hex
+	Type     string     `json:"type"`
+	Value    float64    `json:"value"`
+	Residual *diagFloat `json:"residual"`
+}
+
+// diagRecord is the JSON line written for each Process call.
+type diagRecord struct {
+	TsMs             int64      `json:"ts_ms"`
+	TagID            int        `json:"tag_id"`
+	BLECount         int        `json:"ble_count"`
+	TWRCount         int        `json:"twr_count"`
+	Flag             int        `json:"flag"`
+	X                diagFloat  `json:"x"`
+	Y                diagFloat  `json:"y"`
+	HDOP             diagFloat  `json:"hdop"`
+	HMaha            diagFloat  `json:"hmaha"`
+	PredictOnlyCount int        `json:"predict_only_count"`
+	DivergeCount     int        `json:"diverge_count"`
+	Pxx              diagFloat  `json:"pxx"`
+	Pyy              diagFloat  `json:"pyy"`
+	Measurements     []diagMeas `json:"measurements"`
+}
+
+// diagFloat encodes NaN and infinities, which JSON lacks, as null.
+type diagFloat float64
+
+func (f diagFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return []byte("null"), nil
+	}
+	return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+}
+
+// diagWriter encodes records to w from its own goroutine.
+type diagWriter struct {
+	queue   chan diagRecord
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+func newDiagWriter(w io.Writer) *diagWriter {
+	d := &diagWriter{
+		queue: make(chan diagRecord, DiagQueueLen),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(d.done)
+		enc := json.NewEncoder(w)
+		for rec := range d.queue {
+			// A failing writer only loses diagnostics.
+			_ = enc.Encode(rec)
+		}
+	}()
+	return d
+}
+
+// send queues rec, dropping it while the queue is full.
+func (d *diagWriter) send(rec diagRecord) {
+	select {
+	case d.queue <- rec:
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+// close writes the queued records and stops the goroutine.
+func (d *diagWriter) close() {
+	close(d.queue)
+	<-d.done
+}
+
+// SetDiagnosticsWriter writes one JSON object per Process call to w as
+// newline-delimited JSON: the input counts, output flag and position, EKF
+// HDOP, Mahalanobis distance, predict-only and divergence counts, position
+// variances, and every input measurement with its residual. Records are
+// written from a background goroutine; when DiagQueueLen records are
+// waiting, new ones are dropped (see DiagnosticsDropped) rather than
+// slowing Process. A nil w stops the output after writing what is queued;
+// do so before discarding a pipeline with diagnostics on.
+func (p *FusionPipeline) SetDiagnosticsWriter(w io.Writer) {
+	if p.diag != nil {
+		p.diag.close()
+		p.diag = nil
+	}
+	if w != nil {
+		p.diag = newDiagWriter(w)
+	}
+	p.ekf.RecordResiduals = p.residualDebug || p.diag != nil
+}
+
+// DiagnosticsDropped is the number of diagnostics records dropped because
+// the writer fell behind.
+func (p *FusionPipeline) DiagnosticsDropped() uint64 {
+	if p.diag == nil {
+		return 0
+	}
+	return p.diag.dropped.Load()
+}
+
+// writeDiag queues the diagnostics record of a Process call.
+func (p *FusionPipeline) writeDiag(tsMs int64, tagID int, bleMeas []BLEMeas, twrMeas []TWRMeas, res FusionResult) {
+	rec := diagRecord{
+		TsMs:             tsMs,
+		TagID:            tagID,
+		BLECount:         len(bleMeas),
+		TWRCount:         len(twrMeas),
+		Flag:             res.Flag,
+		X:                diagFloat(res.X),
+		Y:                diagFloat(res.Y),
+		HDOP:             diagFloat(p.ekf.HDOP),
+		HMaha:            diagFloat(p.ekf.HMaha),
+		PredictOnlyCount: p.ekf.PredictOnlyCount,
+		DivergeCount:     p.divergeCount,
+		Pxx:              diagFloat(p.ekf.Pxk[0][0]),
+		Pyy:              diagFloat(p.ekf.Pxk[1][1]),
+		Measurements:     make([]diagMeas, 0, len(bleMeas)+len(twrMeas)),
+	}
+	residual := func(id int, typ string) *diagFloat {
+		for _, r := range p.ekf.Residuals {
+			if r.AnchorID == id && r.Type == typ {
+				v := diagFloat(r.Measured - r.Predicted)
+				return &v
+			}
+		}
+		return nil
+	}
+	for _, m := range twrMeas {
+		rec.Measurements = append(rec.Measurements, diagMeas{
+			AnchorID: fmt.Sprintf("%X", m.AnchorID),
+			Type:     "TWR",
+			Value:    m.Range,
+			Residual: residual(m.AnchorID, "TWR"),
+		})
+	}
+	for _, m := range bleMeas {
+		rec.Measurements = append(rec.Measurements, diagMeas{
+			AnchorID: fmt.Sprintf("%X", m.AnchorID),
+			Type:     "BLE",
+			Value:    float64(m.RSSIDb),
+			Residual: residual(m.AnchorID, "BLE"),
+		})
+	}
+	p.diag.send(rec)
+}
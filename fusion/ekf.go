@@ -2,6 +2,7 @@ package fusion
 
 import (
     "math"
+    "sort"
 )
 
 type EKFSample struct {
@@ -13,6 +14,16 @@ type EKFSample struct {
     DimPos    []DimMat
 }
 
+// AnchorResidual is the innovation of a single anchor measurement.
+// Normalized is the innovation divided by its predicted standard deviation.
+type AnchorResidual struct {
+    AnchorID   int
+    Type       string // "TWR" or "BLE"
+    Measured   float64
+    Predicted  float64
+    Normalized float64
+}
+
 type EKF struct {
     n        int
     m        int
@@ -26,6 +37,11 @@ type EKF struct {
     xMin       []float64
     xMax       []float64
 
+    // posBounds is the map extent (minX, maxX, minY, maxY) applied to the
+    // position states; without it positions are effectively unbounded.
+    posBounds  [4]float64
+    posBounded bool
+
     usedMea []int
     ret     int
     HDOP    float64
@@ -49,17 +65,108 @@ type EKF struct {
     xkk1  []float64
     Pykk1 [][]float64
     rk    []float64
+
+    // RecordResiduals enables per-anchor innovation capture in KfUpdate.
+    RecordResiduals bool
+    Residuals       []AnchorResidual
+
+    // estZ appends [z, vz] at IdxZ/IdxVz; zSeeded is false until the
+    // first measurement seeds z from the configured tag height.
+    estZ    bool
+    zSeeded bool
+
+    // HuberDelta enables the robust iterated update when > 0: rows whose
+    // normalized innovation exceeds HuberDelta are de-weighted by
+    // w = HuberDelta/|r|. 0 (the default) keeps the standard update.
+    HuberDelta float64
+
+    // UseUKF replaces the Jacobian measurement update with an unscented
+    // one: sigma points of the predicted state go through the TWR/BLE
+    // models and the innovation and cross covariances are taken from
+    // them. Dimension rows stay linear and the Huber passes, which
+    // re-linearize, are skipped. Off by default.
+    UseUKF bool
+
+    // maxVel bounds vx/vy; decel is the speed decay applied by
+    // PredictConstrain (m/s^2). See MotionProfile.
+    maxVel float64
+    decel  float64
+
+    // MahalanobisGate is the HMaha above which KfUpdate skips the
+    // measurement update and only predicts (ret = 1). GatedUpdates counts
+    // such frames; gated reports whether the last update was one.
+    MahalanobisGate float64
+    GatedUpdates    int
+    gated           bool
+
+    // PredictOnlyCount is the number of consecutive KfUpdate calls without
+    // any measurement (predict only); a measurement update resets it.
+    PredictOnlyCount int
+
+    // TruncatedUpdates counts UpMeas calls whose sample had more than
+    // MaxMeaDim TWR/BLE rows and was cut down to MaxMeaDim.
+    TruncatedUpdates int
+
+    // SuspectCount is how many consecutive updates an anchor's normalized
+    // innovation must exceed SuspectSigma before it is excluded.
+    SuspectCount int
+    anchorMon    map[int]*anchorMonitor
+
+    // used is the sample the last KfUpdate ran on, after suspect rows
+    // were dropped.
+    used *EKFSample
+}
+
+// anchorMonitor tracks the innovation consistency of one anchor.
+type anchorMonitor struct {
+    over    int // consecutive updates above SuspectSigma
+    under   int // consecutive updates within SuspectSigma
+    suspect bool
+}
+
+// MotionProfile holds the kinematic limits of one kind of tag.
+type MotionProfile struct {
+    MaxVel       float64 // m/s, horizontal velocity clamp
+    Deceleration float64 // m/s^2 applied on predict-only steps
+}
+
+// DefaultMotionProfile returns the MaxVel/Deceleration constants.
+func DefaultMotionProfile() MotionProfile {
+    return MotionProfile{MaxVel: MaxVel, Deceleration: Deceleration}
 }
 
 func NewEKF() *EKF {
-    k := &EKF{}
-    k.n = StateDim
+    return NewEKFWithProfile(DefaultMotionProfile())
+}
+
+// NewEKFWithProfile is NewEKF with the given kinematic limits.
+func NewEKFWithProfile(mp MotionProfile) *EKF {
+    k := &EKF{maxVel: mp.MaxVel, decel: mp.Deceleration}
     k.m = MaxMeaDim
     k.ts = 0.1
     k.fading = Fading
     k.adaptive = UseAdaptive
     k.beta = BetaInit
     k.b = BetaB
+    k.usedMea = make([]int, 4)
+    k.BLE2Dis = make([][]float64, k.m)
+    for i := 0; i < k.m; i++ {
+        k.BLE2Dis[i] = make([]float64, 3)
+    }
+    k.Dc = NewDimConstrain(HistoryLen)
+    k.SuspectCount = SuspectCountDefault
+    k.MahalanobisGate = MahalanobisGateDefault
+    k.setDim()
+    return k
+}
+
+// setDim sizes the state bounds for the current state layout and resets
+// the state.
+func (k *EKF) setDim() {
+    k.n = StateDim
+    if k.estZ {
+        k.n = StateDimZ
+    }
     // Match C++: Only constrain Velocity (2,3) and Parameters (4,5).
     // Position (0,1) is unconstrained to allow large coordinates.
     // Setting xconstrain to false for pos and using large bounds.
@@ -68,25 +175,281 @@ func NewEKF() *EKF {
     k.xMax = make([]float64, k.n)
     k.xMin[0] = -1e9 // Effectively no limit
     k.xMin[1] = -1e9
-    k.xMin[2] = -MaxVel
-    k.xMin[3] = -MaxVel
+    k.xMin[2] = -k.maxVel
+    k.xMin[3] = -k.maxVel
     k.xMin[4] = PathLossExp[0]
     k.xMin[5] = DeltaA[0]
     k.xMax[0] = 1e9
     k.xMax[1] = 1e9
-    k.xMax[2] = MaxVel
-    k.xMax[3] = MaxVel
+    k.xMax[2] = k.maxVel
+    k.xMax[3] = k.maxVel
     k.xMax[4] = PathLossExp[2]
     k.xMax[5] = DeltaA[2]
-    k.usedMea = make([]int, 4)
-    k.BLE2Dis = make([][]float64, k.m)
-    for i := 0; i < k.m; i++ {
-        k.BLE2Dis[i] = make([]float64, 3)
+    if k.posBounded {
+        k.applyPositionBounds()
+    }
+    if k.estZ {
+        k.xconstrain = append(k.xconstrain, true, true)
+        k.xMin[IdxZ] = ZMin
+        k.xMax[IdxZ] = ZMax
+        k.xMin[IdxVz] = -MaxVelZ
+        k.xMax[IdxVz] = MaxVelZ
     }
-    k.Dc = NewDimConstrain(HistoryLen)
     k.xkk1 = make([]float64, k.n)
     k.resetState()
-    return k
+}
+
+// SetPositionBounds limits the position states to the given extent
+// (meters). The limit survives SetZState.
+func (k *EKF) SetPositionBounds(minX, maxX, minY, maxY float64) {
+    k.posBounds = [4]float64{minX, maxX, minY, maxY}
+    k.posBounded = true
+    k.applyPositionBounds()
+}
+
+func (k *EKF) applyPositionBounds() {
+    k.xMin[0], k.xMax[0] = k.posBounds[0], k.posBounds[1]
+    k.xMin[1], k.xMax[1] = k.posBounds[2], k.posBounds[3]
+}
+
+// SetMotionProfile changes the kinematic limits without resetting the
+// filter.
+func (k *EKF) SetMotionProfile(mp MotionProfile) {
+    k.maxVel = mp.MaxVel
+    k.decel = mp.Deceleration
+    k.xMin[2], k.xMin[3] = -k.maxVel, -k.maxVel
+    k.xMax[2], k.xMax[3] = k.maxVel, k.maxVel
+}
+
+// MotionProfile returns the current kinematic limits.
+func (k *EKF) MotionProfile() MotionProfile {
+    return MotionProfile{MaxVel: k.maxVel, Deceleration: k.decel}
+}
+
+// SetZState switches between the 2D state and the state extended with
+// height and vertical velocity. The filter is reset.
+func (k *EKF) SetZState(on bool) {
+    if on == k.estZ {
+        return
+    }
+    k.estZ = on
+    k.setDim()
+}
+
+// Z returns the estimated tag height; ok is false when Z is not estimated.
+func (k *EKF) Z() (z float64, ok bool) {
+    if !k.estZ {
+        return 0, false
+    }
+    return k.xk[IdxZ], true
+}
+
+// tagZ is the tag height used by the measurement model for state x.
+func (k *EKF) tagZ(x []float64, sample *EKFSample) float64 {
+    if k.estZ {
+        return x[IdxZ]
+    }
+    return sample.TagHeight
+}
+
+// SetHuberDelta sets the Huber threshold in innovation standard deviations;
+// HuberDeltaDefault is a reasonable value, <= 0 disables it.
+func (k *EKF) SetHuberDelta(delta float64) {
+    k.HuberDelta = delta
+}
+
+// SetMahalanobisGate sets the innovation gate; <= 0 restores
+// MahalanobisGateDefault.
+func (k *EKF) SetMahalanobisGate(gate float64) {
+    if gate <= 0 {
+        gate = MahalanobisGateDefault
+    }
+    k.MahalanobisGate = gate
+}
+
+// SuspectAnchors returns the anchors currently excluded from updates because
+// of persistently inconsistent innovations.
+func (k *EKF) SuspectAnchors() []int {
+    out := []int{}
+    for id, m := range k.anchorMon {
+        if m.suspect {
+            out = append(out, id)
+        }
+    }
+    sort.Ints(out)
+    return out
+}
+
+// UsedSample returns the sample the last KfUpdate ran on: its input with
+// the rows of suspect anchors removed. Nil before the first update.
+func (k *EKF) UsedSample() *EKFSample {
+    return k.used
+}
+
+// monitorAnchors scores each TWR/BLE row's innovation against the
+// prediction and returns the rows of suspect anchors to drop, or nil.
+// A row only counts against its anchor when it is an outlier against the
+// others: the rows within SuspectSigma must be the majority, pass the
+// Mahalanobis gate on their own and average no more than one sigma each.
+// Otherwise the prediction itself is off (a gated frame, or a filter that
+// is still relocating) and no anchor is scored. Suspects keep being scored
+// and clear after SuspectCount consistent updates. Exclusion is skipped if
+// it would leave fewer than two rows.
+func (k *EKF) monitorAnchors(sample *EKFSample, Py0 [][]float64) []bool {
+    ids := make([]int, 0, len(sample.TWR)+len(sample.BLE))
+    for _, tw := range sample.TWR {
+        ids = append(ids, tw.AnchorID)
+    }
+    for _, bl := range sample.BLE {
+        ids = append(ids, bl.AnchorID)
+    }
+    n := k.SuspectCount
+    if n <= 0 {
+        n = SuspectCountDefault
+    }
+    // norm is NaN for rows without a usable variance; those are not scored.
+    norm := make([]float64, len(ids))
+    rest := make([]int, 0, len(k.rk))
+    outliers := 0
+    for i := range k.rk {
+        if i >= len(ids) {
+            rest = append(rest, i)
+            continue
+        }
+        norm[i] = math.NaN()
+        if s := Py0[i][i] + k.Rk[i][i]; s > 0 {
+            norm[i] = math.Abs(k.rk[i]) / math.Sqrt(s)
+        }
+        if norm[i] > SuspectSigma {
+            outliers++
+        } else {
+            rest = append(rest, i)
+        }
+    }
+    inliers := len(ids) - outliers
+    consistent := false
+    if inliers >= 2 && outliers < inliers {
+        m := k.subsetMaha(rest, Py0)
+        consistent = m <= k.MahalanobisGate && m*m <= float64(len(rest))
+    }
+    if consistent {
+        for i, id := range ids {
+            if math.IsNaN(norm[i]) {
+                continue
+            }
+            m, ok := k.anchorMon[id]
+            if !ok {
+                m = &anchorMonitor{}
+                k.anchorMon[id] = m
+            }
+            if norm[i] > SuspectSigma {
+                m.over++
+                m.under = 0
+            } else {
+                m.under++
+                m.over = 0
+            }
+            if m.over > n {
+                m.suspect = true
+            } else if m.suspect && m.under >= n {
+                m.suspect = false
+            }
+        }
+    }
+    drop := make([]bool, len(ids))
+    good := 0
+    for i, id := range ids {
+        if m := k.anchorMon[id]; m != nil && m.suspect {
+            drop[i] = true
+        } else {
+            good++
+        }
+    }
+    if good < 2 || good == len(ids) {
+        return nil
+    }
+    return drop
+}
+
+// subsetMaha returns the Mahalanobis distance of the innovation rows in idx
+// alone, against Py0+Rk restricted to those rows.
+func (k *EKF) subsetMaha(idx []int, Py0 [][]float64) float64 {
+    S := make([][]float64, len(idx))
+    for a, i := range idx {
+        S[a] = make([]float64, len(idx))
+        for b, j := range idx {
+            S[a][b] = Py0[i][j] + k.Rk[i][j]
+        }
+    }
+    if minEig := minEigen(S); minEig < 1e-9 {
+        add := math.Abs(minEig) + 1e-9
+        for a := range S {
+            S[a][a] += add
+        }
+    }
+    inv := pinv(S)
+    tmp := 0.0
+    for a, i := range idx {
+        for b, j := range idx {
+            tmp += k.rk[i] * inv[a][b] * k.rk[j]
+        }
+    }
+    return math.Sqrt(tmp)
+}
+
+// dropRows removes the TWR/BLE rows marked in drop from the measurement
+// built for sample, along with the matching columns of Pxy and rows and
+// columns of Py, and returns the reduced sample, Pxy and Py. Dimension
+// constraint rows are kept.
+func (k *EKF) dropRows(sample *EKFSample, drop []bool, Pxy, Py [][]float64) (*EKFSample, [][]float64, [][]float64) {
+    keep := make([]int, 0, len(k.rk))
+    for i := range k.rk {
+        if i >= len(drop) || !drop[i] {
+            keep = append(keep, i)
+        }
+    }
+    cut := *sample
+    cut.TWR, cut.BLE = nil, nil
+    for i, tw := range sample.TWR {
+        if !drop[i] {
+            cut.TWR = append(cut.TWR, tw)
+        }
+    }
+    for i, bl := range sample.BLE {
+        if !drop[len(sample.TWR)+i] {
+            copy(k.BLE2Dis[len(cut.BLE)], k.BLE2Dis[i])
+            cut.BLE = append(cut.BLE, bl)
+        }
+    }
+    k.usedMea[0] = len(cut.TWR)
+    k.usedMea[1] = len(cut.BLE)
+
+    pick := func(v []float64) []float64 {
+        out := make([]float64, len(keep))
+        for j, i := range keep {
+            out[j] = v[i]
+        }
+        return out
+    }
+    sub := func(m [][]float64) [][]float64 {
+        out := make([][]float64, len(keep))
+        for j, i := range keep {
+            out[j] = pick(m[i])
+        }
+        return out
+    }
+    k.yk, k.ykk1, k.rk = pick(k.yk), pick(k.ykk1), pick(k.rk)
+    hk := make([][]float64, len(keep))
+    for j, i := range keep {
+        hk[j] = k.Hk[i]
+    }
+    k.Hk = hk
+    k.Rk, k.Rmin, k.Rmax = sub(k.Rk), sub(k.Rmin), sub(k.Rmax)
+    pxy := make([][]float64, len(Pxy))
+    for r, row := range Pxy {
+        pxy[r] = pick(row)
+    }
+    return &cut, pxy, sub(Py)
 }
 
 func (k *EKF) resetState() {
@@ -100,8 +463,15 @@ func (k *EKF) resetState() {
     k.Pxk[3][3] = Pow2(SigmaVel)
     k.Pxk[4][4] = Pow2(SigmaN0)
     k.Pxk[5][5] = Pow2(SigmaA0)
+    if k.estZ {
+        k.Pxk[IdxZ][IdxZ] = Pow2(SigmaZ0)
+        k.Pxk[IdxVz][IdxVz] = Pow2(SigmaVelZ)
+        k.zSeeded = false
+    }
     k.Phikk1 = identity(k.n)
     k.Qk = zeroMat(k.n, k.n)
+    k.PredictOnlyCount = 0
+    k.anchorMon = map[int]*anchorMonitor{}
 }
 
 func (k *EKF) Updt(dtime float64) {
@@ -127,13 +497,44 @@ func (k *EKF) Updt(dtime float64) {
     }
     k.Qk[4][4] = dtime * qn * nAScale
     k.Qk[5][5] = dtime * qA * nAScale
+    if k.estZ {
+        qz := Pow2(SigmaAccZ)
+        k.Phikk1[IdxZ][IdxVz] = dtime
+        k.Qk[IdxZ][IdxZ] = (math.Pow(dtime, 3) / 3.0) * qz
+        k.Qk[IdxZ][IdxVz] = (math.Pow(dtime, 2) / 2.0) * qz
+        k.Qk[IdxVz][IdxZ] = k.Qk[IdxZ][IdxVz]
+        k.Qk[IdxVz][IdxVz] = dtime * qz
+    }
 }
 
-func (k *EKF) UpMeas(sample *EKFSample) {
+// UpMeas builds the measurement model for sample and returns the sample it
+// was built for, which KfUpdate must be given. The measurement vector holds
+// at most MaxMeaDim TWR/BLE rows: a larger sample is copied and cut down,
+// dropping BLE rows first since TWR ranges are the more accurate, and
+// keeping the closest TWR and strongest BLE anchors. The caller's sample is
+// left whole.
+func (k *EKF) UpMeas(sample *EKFSample) *EKFSample {
+    if n := len(sample.TWR) + len(sample.BLE); n > MaxMeaDim {
+        k.TruncatedUpdates++
+        cut := *sample
+        sample = &cut
+        if len(sample.TWR) > MaxMeaDim {
+            twr := append([]TWRRow(nil), sample.TWR...)
+            sort.SliceStable(twr, func(i, j int) bool { return twr[i].Range < twr[j].Range })
+            sample.TWR = twr[:MaxMeaDim]
+        }
+        ble := append([]BLERow(nil), sample.BLE...)
+        sort.SliceStable(ble, func(i, j int) bool { return ble[i].Strength < ble[j].Strength })
+        sample.BLE = ble[:MaxMeaDim-len(sample.TWR)]
+    }
     k.usedMea[0] = len(sample.TWR)
     k.usedMea[1] = len(sample.BLE)
     k.usedMea[2] = 0
     k.usedMea[3] = 0
+    if k.estZ && !k.zSeeded {
+        k.xk[IdxZ] = sample.TagHeight
+        k.zSeeded = true
+    }
     k.Dc.DimConsDeter(sample, k)
     total := k.usedMea[0] + k.usedMea[1] + k.usedMea[3]
     k.yk = make([]float64, total)
@@ -164,7 +565,7 @@ func (k *EKF) UpMeas(sample *EKFSample) {
     for _, tw := range sample.TWR {
         dx := k.xk[0] - tw.X
         dy := k.xk[1] - tw.Y
-        dz := sample.TagHeight - tw.Z
+        dz := k.tagZ(k.xk, sample) - tw.Z
         d := math.Hypot(dx, dy)
         d = math.Sqrt(d*d + dz*dz)
         if d < MinDistance {
@@ -172,13 +573,16 @@ func (k *EKF) UpMeas(sample *EKFSample) {
         }
         k.Hk[idx][0] = dx / d
         k.Hk[idx][1] = dy / d
+        if k.estZ {
+            k.Hk[idx][IdxZ] = dz / d
+        }
         idx++
     }
     // Hk for BLE
     for _, bl := range sample.BLE {
         dx := k.xk[0] - bl.X
         dy := k.xk[1] - bl.Y
-        dz := sample.TagHeight - bl.Z
+        dz := k.tagZ(k.xk, sample) - bl.Z
         d := math.Hypot(dx, dy)
         d = math.Sqrt(d*d + dz*dz)
         if d < MinDistance {
@@ -187,6 +591,9 @@ func (k *EKF) UpMeas(sample *EKFSample) {
         common := 10.0 * k.xk[4] / (Ln10 * d * d)
         k.Hk[idx][0] = common * dx
         k.Hk[idx][1] = common * dy
+        if k.estZ {
+            k.Hk[idx][IdxZ] = common * dz
+        }
         k.Hk[idx][4] = 10.0 * math.Log10(d)
         k.Hk[idx][5] = 1.0
         idx++
@@ -231,17 +638,23 @@ func (k *EKF) UpMeas(sample *EKFSample) {
         k.Rmin[i][i] = 0.01 * k.Rk[i][i]
     }
     k.ManagePxk()
+    return sample
 }
 
 func (k *EKF) KfUpdate(sample *EKFSample) {
+    k.Residuals = nil
+    k.gated = false
+    k.used = sample
     total := k.usedMea[0] + k.usedMea[1] + k.usedMea[3]
     if total == 0 {
         // predict only
         k.xk = matVec(k.Phikk1, k.xk)
         k.Pxk = matAdd(matMul(k.Phikk1, matMul(k.Pxk, transpose(k.Phikk1))), k.Qk)
         k.ret = 1
+        k.PredictOnlyCount++
         return
     }
+    k.PredictOnlyCount = 0
 
     k.xkk1 = matVec(k.Phikk1, k.xk)
     Pxkk1 := matAdd(matMul(k.Phikk1, matMul(k.Pxk, transpose(k.Phikk1))), k.Qk)
@@ -255,7 +668,7 @@ func (k *EKF) KfUpdate(sample *EKFSample) {
     for _, tw := range sample.TWR {
         dx := k.xkk1[0] - tw.X
         dy := k.xkk1[1] - tw.Y
-        dz := sample.TagHeight - tw.Z
+        dz := k.tagZ(k.xkk1, sample) - tw.Z
         d := math.Hypot(dx, dy)
         d = math.Sqrt(d*d + dz*dz)
         if d < MinDistance {
@@ -267,7 +680,7 @@ func (k *EKF) KfUpdate(sample *EKFSample) {
     for _, bl := range sample.BLE {
         dx := k.xkk1[0] - bl.X
         dy := k.xkk1[1] - bl.Y
-        dz := sample.TagHeight - bl.Z
+        dz := k.tagZ(k.xkk1, sample) - bl.Z
         d := math.Hypot(dx, dy)
         d = math.Sqrt(d*d + dz*dz)
         if d < MinDistance {
@@ -288,8 +701,15 @@ func (k *EKF) KfUpdate(sample *EKFSample) {
         k.rk[i] = k.yk[i] - k.ykk1[i]
     }
 
-    Pxykk1 := matMul(Pxkk1, transpose(k.Hk)) // 6 x total
+    Pxykk1 := matMul(Pxkk1, transpose(k.Hk)) // n x total
     Py0 := matMul(k.Hk, Pxykk1)              // total x total
+    unscented := false
+    if k.UseUKF {
+        if pxy, py, ok := k.unscentedMeas(sample, Pxkk1); ok {
+            Pxykk1, Py0 = pxy, py
+            unscented = true
+        }
+    }
 
     if k.adaptive {
         for i := 0; i < total; i++ {
@@ -308,6 +728,16 @@ func (k *EKF) KfUpdate(sample *EKFSample) {
         k.beta = k.beta / (k.beta + k.b)
     }
 
+    if drop := k.monitorAnchors(sample, Py0); drop != nil {
+        sample, Pxykk1, Py0 = k.dropRows(sample, drop, Pxykk1, Py0)
+        total = len(k.rk)
+        k.used = sample
+    }
+
+    if k.HuberDelta > 0 && !unscented {
+        Pxykk1, Py0 = k.huberIterate(sample, Pxkk1, Py0)
+    }
+
     Pykk1 := matAdd(Py0, k.Rk)
     // ensure positive definiteness
     minEig := minEigen(Pykk1)
@@ -319,6 +749,10 @@ func (k *EKF) KfUpdate(sample *EKFSample) {
     }
     invPy := pinv(Pykk1)
 
+    if k.RecordResiduals {
+        k.recordResiduals(sample, Pykk1)
+    }
+
     // H_maha
     tmp := 0.0
     for i := 0; i < total; i++ {
@@ -328,13 +762,17 @@ func (k *EKF) KfUpdate(sample *EKFSample) {
     }
     k.HMaha = math.Sqrt(tmp)
 
-    // Innovation Gating: Reject strong outliers
-    if k.HMaha > 10.0 {
-        k.ret = -3
+    // Innovation Gating: skip strong outliers, keep the prediction
+    if k.HMaha > k.MahalanobisGate {
+        k.xk = k.xkk1
+        k.Pxk = Pxkk1
+        k.gated = true
+        k.GatedUpdates++
+        k.ret = 1
         return
     }
 
-    Kk := matMul(Pxykk1, invPy) // 6 x total
+    Kk := matMul(Pxykk1, invPy) // n x total
     // update state
     incr := matVec(Kk, k.rk)
     for i := 0; i < k.n; i++ {
@@ -366,6 +804,243 @@ func (k *EKF) KfUpdate(sample *EKFSample) {
     }
 }
 
+// huberIterate runs up to HuberIters iterated-EKF passes with Huber
+// re-weighting. Each pass re-linearizes the TWR/BLE rows around the current
+// estimate and inflates Rk for rows with large normalized innovations. It
+// leaves k.rk, k.Hk, k.ykk1 and k.Rk set so that xkk1 + K*rk is the
+// iterated estimate, and returns the matching Pxy and H*P*H'.
+func (k *EKF) huberIterate(sample *EKFSample, Pxkk1, Py0 [][]float64) ([][]float64, [][]float64) {
+    total := len(k.rk)
+    nMeas := len(sample.TWR) + len(sample.BLE)
+    R0 := make([]float64, total)
+    for i := 0; i < total; i++ {
+        R0[i] = k.Rk[i][i]
+    }
+    rDim := make([]float64, total)
+    copy(rDim, k.rk)
+
+    xi := make([]float64, k.n)
+    copy(xi, k.xkk1)
+    var Pxy [][]float64
+    for it := 0; it < HuberIters; it++ {
+        if it > 0 {
+            k.linearizeAt(sample, xi)
+            dx := make([]float64, k.n)
+            for j := 0; j < k.n; j++ {
+                dx[j] = k.xkk1[j] - xi[j]
+            }
+            hdx := matVec(k.Hk, dx)
+            for i := 0; i < nMeas; i++ {
+                k.rk[i] = k.yk[i] - k.ykk1[i] - hdx[i]
+            }
+            // dimension rows stay linearized at xkk1
+            for i := nMeas; i < total; i++ {
+                k.rk[i] = rDim[i]
+            }
+            Pxy = matMul(Pxkk1, transpose(k.Hk))
+            Py0 = matMul(k.Hk, Pxy)
+        }
+        for i := 0; i < total; i++ {
+            k.Rk[i][i] = R0[i]
+            sd := math.Sqrt(Py0[i][i] + R0[i])
+            if sd <= 0 {
+                continue
+            }
+            e := math.Abs(k.rk[i]) / sd
+            if e > k.HuberDelta {
+                k.Rk[i][i] = R0[i] * e / k.HuberDelta
+            }
+        }
+        if it == HuberIters-1 {
+            break
+        }
+        if Pxy == nil {
+            Pxy = matMul(Pxkk1, transpose(k.Hk))
+        }
+        Kk := matMul(Pxy, pinv(matAdd(Py0, k.Rk)))
+        incr := matVec(Kk, k.rk)
+        step := 0.0
+        for j := 0; j < k.n; j++ {
+            nx := k.xkk1[j] + incr[j]
+            if j < 2 {
+                step = math.Max(step, math.Abs(nx-xi[j]))
+            }
+            xi[j] = nx
+        }
+        if step < HuberTol {
+            break
+        }
+    }
+    if Pxy == nil {
+        Pxy = matMul(Pxkk1, transpose(k.Hk))
+    }
+    return Pxy, Py0
+}
+
+// unscentedMeas propagates the 2n+1 symmetric sigma points of (xkk1,
+// Pxkk1) through the measurement models. It replaces k.ykk1 and k.rk with
+// the sigma-point mean and innovation and returns the cross covariance
+// Pxy and H*P*H' equivalent Py (without Rk). ok is false, leaving the
+// Jacobian results in place, when Pxkk1 has no Cholesky factor.
+func (k *EKF) unscentedMeas(sample *EKFSample, Pxkk1 [][]float64) (Pxy, Py [][]float64, ok bool) {
+    n := k.n
+    total := len(k.rk)
+    nMeas := len(sample.TWR) + len(sample.BLE)
+    L, ok := cholesky(scalarMat(Pxkk1, float64(n)+UKFKappa))
+    if !ok {
+        return nil, nil, false
+    }
+
+    // Sigma points: xkk1, then xkk1 ± column j of L.
+    sigma := make([][]float64, 2*n+1)
+    sigma[0] = append([]float64(nil), k.xkk1...)
+    for j := 0; j < n; j++ {
+        plus := make([]float64, n)
+        minus := make([]float64, n)
+        for i := 0; i < n; i++ {
+            plus[i] = k.xkk1[i] + L[i][j]
+            minus[i] = k.xkk1[i] - L[i][j]
+        }
+        sigma[1+j] = plus
+        sigma[1+n+j] = minus
+    }
+    w0 := UKFKappa / (float64(n) + UKFKappa)
+    wi := 1.0 / (2.0 * (float64(n) + UKFKappa))
+
+    ys := make([][]float64, len(sigma))
+    yMean := make([]float64, total)
+    for s, x := range sigma {
+        y := make([]float64, total)
+        copy(y, k.measAt(sample, x))
+        // dimension rows are linear in the state
+        for i := nMeas; i < total; i++ {
+            v := k.ykk1[i]
+            for j := 0; j < n; j++ {
+                v += k.Hk[i][j] * (x[j] - k.xkk1[j])
+            }
+            y[i] = v
+        }
+        ys[s] = y
+        w := wi
+        if s == 0 {
+            w = w0
+        }
+        for i := range y {
+            yMean[i] += w * y[i]
+        }
+    }
+
+    Pxy = zeroMat(n, total)
+    Py = zeroMat(total, total)
+    for s, x := range sigma {
+        w := wi
+        if s == 0 {
+            w = w0
+        }
+        dy := make([]float64, total)
+        for i := range dy {
+            dy[i] = ys[s][i] - yMean[i]
+        }
+        for i := 0; i < n; i++ {
+            dx := x[i] - k.xkk1[i]
+            for j := 0; j < total; j++ {
+                Pxy[i][j] += w * dx * dy[j]
+            }
+        }
+        for i := 0; i < total; i++ {
+            for j := 0; j < total; j++ {
+                Py[i][j] += w * dy[i] * dy[j]
+            }
+        }
+    }
+
+    copy(k.ykk1, yMean)
+    for i := 0; i < total; i++ {
+        k.rk[i] = k.yk[i] - yMean[i]
+    }
+    return Pxy, Py, true
+}
+
+// measAt returns the predicted TWR ranges and BLE strengths at state x.
+func (k *EKF) measAt(sample *EKFSample, x []float64) []float64 {
+    out := make([]float64, 0, len(sample.TWR)+len(sample.BLE))
+    tz := k.tagZ(x, sample)
+    for _, tw := range sample.TWR {
+        dx, dy, dz := x[0]-tw.X, x[1]-tw.Y, tz-tw.Z
+        out = append(out, math.Max(math.Sqrt(dx*dx+dy*dy+dz*dz), MinDistance))
+    }
+    for _, bl := range sample.BLE {
+        dx, dy, dz := x[0]-bl.X, x[1]-bl.Y, tz-bl.Z
+        d := math.Max(math.Sqrt(dx*dx+dy*dy+dz*dz), MinDistance)
+        out = append(out, x[5]+10.0*x[4]*math.Log10(d))
+    }
+    return out
+}
+
+// linearizeAt recomputes the predicted measurement and Jacobian of the
+// TWR/BLE rows around state x.
+func (k *EKF) linearizeAt(sample *EKFSample, x []float64) {
+    idx := 0
+    tz := k.tagZ(x, sample)
+    for _, tw := range sample.TWR {
+        dx := x[0] - tw.X
+        dy := x[1] - tw.Y
+        dz := tz - tw.Z
+        d := math.Sqrt(dx*dx + dy*dy + dz*dz)
+        if d < MinDistance {
+            d = MinDistance
+        }
+        k.ykk1[idx] = d
+        k.Hk[idx][0] = dx / d
+        k.Hk[idx][1] = dy / d
+        if k.estZ {
+            k.Hk[idx][IdxZ] = dz / d
+        }
+        idx++
+    }
+    for _, bl := range sample.BLE {
+        dx := x[0] - bl.X
+        dy := x[1] - bl.Y
+        dz := tz - bl.Z
+        d := math.Sqrt(dx*dx + dy*dy + dz*dz)
+        if d < MinDistance {
+            d = MinDistance
+        }
+        k.ykk1[idx] = x[5] + 10.0*x[4]*math.Log10(d)
+        common := 10.0 * x[4] / (Ln10 * d * d)
+        k.Hk[idx][0] = common * dx
+        k.Hk[idx][1] = common * dy
+        k.Hk[idx][4] = 10.0 * math.Log10(d)
+        k.Hk[idx][5] = 1.0
+        if k.estZ {
+            k.Hk[idx][IdxZ] = common * dz
+        }
+        idx++
+    }
+}
+
+// recordResiduals stores the innovation of every TWR/BLE row; dimension
+// constraint rows are skipped.
+func (k *EKF) recordResiduals(sample *EKFSample, Pykk1 [][]float64) {
+    res := make([]AnchorResidual, 0, len(sample.TWR)+len(sample.BLE))
+    idx := 0
+    add := func(id int, typ string) {
+        norm := 0.0
+        if Pykk1[idx][idx] > 0 {
+            norm = k.rk[idx] / math.Sqrt(Pykk1[idx][idx])
+        }
+        res = append(res, AnchorResidual{AnchorID: id, Type: typ, Measured: k.yk[idx], Predicted: k.ykk1[idx], Normalized: norm})
+        idx++
+    }
+    for _, tw := range sample.TWR {
+        add(tw.AnchorID, "TWR")
+    }
+    for _, bl := range sample.BLE {
+        add(bl.AnchorID, "BLE")
+    }
+    k.Residuals = res
+}
+
 func (k *EKF) ManagePxk() {
     consFac := PxkFacWithBle
     if k.usedMea[1] == 0 {
@@ -398,8 +1073,8 @@ func (k *EKF) ManagePxk() {
 
 func (k *EKF) PredictConstrain() {
     speed := math.Hypot(k.xk[2], k.xk[3])
-    if speed > 0.01 && Deceleration > 0.01 {
-        scale := math.Max(speed-Deceleration*k.ts, 0.0) / speed
+    if speed > 0.01 && k.decel > 0.01 {
+        scale := math.Max(speed-k.decel*k.ts, 0.0) / speed
         k.xk[2] *= scale
         k.xk[3] *= scale
         for i := 0; i < 4; i++ {
@@ -418,6 +1093,29 @@ func (k *EKF) PredictConstrain() {
 
 // Helper matrix functions -------------------------------------------------
 
+// ZeroVelocityUpdate applies a virtual measurement vx = vy = 0 with
+// variance r (m/s)^2, pulling the velocity (and, through their
+// correlation, the position drift) towards a standstill.
+func (k *EKF) ZeroVelocityUpdate(r float64) {
+    H := zeroMat(2, k.n)
+    H[0][2] = 1.0
+    H[1][3] = 1.0
+    Pxy := matMul(k.Pxk, transpose(H))
+    S := matMul(H, Pxy)
+    S[0][0] += r
+    S[1][1] += r
+    if rank2(S) < 2 {
+        return
+    }
+    K := matMul(Pxy, invert2x2(S))
+    rk := []float64{-k.xk[2], -k.xk[3]}
+    incr := matVec(K, rk)
+    for i := 0; i < k.n; i++ {
+        k.xk[i] += incr[i]
+    }
+    k.Pxk = symmetrize(matSub(k.Pxk, matMul(K, matMul(S, transpose(K)))))
+}
+
 func zeroMat(r, c int) [][]float64 {
     m := make([][]float64, r)
     for i := 0; i < r; i++ {
@@ -592,6 +1290,30 @@ func minEigen(a [][]float64) float64 {
     return minDisc
 }
 
+// cholesky returns the lower-triangular L with L*L' = a, or ok=false if a
+// is not positive definite.
+func cholesky(a [][]float64) ([][]float64, bool) {
+    n := len(a)
+    L := zeroMat(n, n)
+    for i := 0; i < n; i++ {
+        for j := 0; j <= i; j++ {
+            sum := a[i][j]
+            for k := 0; k < j; k++ {
+                sum -= L[i][k] * L[j][k]
+            }
+            if i == j {
+                if sum <= 0 || math.IsNaN(sum) {
+                    return nil, false
+                }
+                L[i][i] = math.Sqrt(sum)
+            } else {
+                L[i][j] = sum / L[j][j]
+            }
+        }
+    }
+    return L, true
+}
+
 func allFinite(v []float64) bool {
     for _, x := range v {
         if math.IsNaN(x) || math.IsInf(x, 0) {
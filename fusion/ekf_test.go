@@ -0,0 +1,116 @@
+package fusion
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUpMeasTruncatesACopy(t *testing.T) {
+	sample := &EKFSample{TagID: 0xB50AC, TagHeight: 1.2}
+	for i := 0; i < 20; i++ {
+		x, y := float64(i%5)*4, float64(i/5)*4
+		sample.TWR = append(sample.TWR, TWRRow{X: x, Y: y, Z: 3, Range: 1 + float64(i), AnchorID: 0x100 + i})
+		sample.BLE = append(sample.BLE, BLERow{X: x, Y: y, Z: 3, Strength: -90 + float64(i), AnchorID: 0x200 + i})
+	}
+	k := NewEKF()
+	used := k.UpMeas(sample)
+
+	if len(sample.TWR) != 20 || len(sample.BLE) != 20 {
+		t.Fatalf("caller's sample cut to %d TWR, %d BLE rows", len(sample.TWR), len(sample.BLE))
+	}
+	if len(used.TWR) != MaxMeaDim || len(used.BLE) != 0 {
+		t.Fatalf("used %d TWR, %d BLE rows, want %d TWR", len(used.TWR), len(used.BLE), MaxMeaDim)
+	}
+	for i, tw := range used.TWR {
+		if tw.AnchorID != 0x100+i {
+			t.Errorf("row %d: anchor %X, want the closest anchors in order", i, tw.AnchorID)
+		}
+	}
+	if k.TruncatedUpdates != 1 {
+		t.Errorf("TruncatedUpdates %d, want 1", k.TruncatedUpdates)
+	}
+
+	if k.UpMeas(used) != used || k.TruncatedUpdates != 1 {
+		t.Errorf("a sample within MaxMeaDim was copied or counted")
+	}
+}
+
+func TestSuspectAnchorDropped(t *testing.T) {
+	anchors := []TWRRow{
+		{AnchorID: 1, X: 0, Y: 0, Z: 3}, {AnchorID: 2, X: 20, Y: 0, Z: 3},
+		{AnchorID: 3, X: 20, Y: 20, Z: 3}, {AnchorID: 4, X: 0, Y: 20, Z: 3},
+		{AnchorID: 5, X: 10, Y: -5, Z: 3},
+	}
+	const tx, ty, tz = 8.0, 12.0, 1.2
+	k := NewEKF()
+	k.xk[0], k.xk[1] = tx, ty
+	for step := 0; step < 40; step++ {
+		sample := &EKFSample{TagID: 1, TagHeight: tz}
+		for _, a := range anchors {
+			r := math.Sqrt((tx-a.X)*(tx-a.X) + (ty-a.Y)*(ty-a.Y) + (tz-a.Z)*(tz-a.Z))
+			if a.AnchorID == 3 {
+				r += 6 // damaged cable
+			}
+			a.Range = r
+			sample.TWR = append(sample.TWR, a)
+		}
+		k.Updt(0.1)
+		k.KfUpdate(k.UpMeas(sample))
+	}
+	if got := k.SuspectAnchors(); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("SuspectAnchors() = %v, want [3]", got)
+	}
+	if k.usedMea[0] != len(anchors)-1 || len(k.rk) != len(anchors)-1 {
+		t.Errorf("update used %d TWR rows, want the suspect's row dropped", k.usedMea[0])
+	}
+	for _, tw := range k.UsedSample().TWR {
+		if tw.AnchorID == 3 {
+			t.Errorf("UsedSample() still has the suspect's row")
+		}
+	}
+	if d := math.Hypot(k.xk[0]-tx, k.xk[1]-ty); d > 0.5 {
+		t.Errorf("estimate (%.2f, %.2f) is %.2f m off with the suspect excluded", k.xk[0], k.xk[1], d)
+	}
+
+	k.resetState()
+	if got := k.SuspectAnchors(); len(got) != 0 {
+		t.Errorf("SuspectAnchors() = %v after resetState, want none", got)
+	}
+}
+
+// A tag that jumps leaves every row inconsistent with the prediction. None
+// of them is an outlier against the others, so no anchor may be excluded
+// while the filter relocates.
+func TestSuspectAnchorsKeptOnRelocation(t *testing.T) {
+	var anchors []TWRRow
+	for i := 0; i < 6; i++ {
+		anchors = append(anchors, TWRRow{AnchorID: i + 1, X: float64(i%3) * 30, Y: float64(i/3) * 30, Z: 3})
+	}
+	const tz = 1.2
+	step := func(k *EKF, tx, ty float64) {
+		sample := &EKFSample{TagID: 1, TagHeight: tz}
+		for _, a := range anchors {
+			a.Range = math.Sqrt((tx-a.X)*(tx-a.X) + (ty-a.Y)*(ty-a.Y) + (tz-a.Z)*(tz-a.Z))
+			sample.TWR = append(sample.TWR, a)
+		}
+		k.Updt(0.1)
+		k.KfUpdate(k.UpMeas(sample))
+	}
+	k := NewEKF()
+	k.xk[0], k.xk[1] = 10, 10
+	for i := 0; i < 40; i++ {
+		step(k, 10, 10)
+	}
+	for i := 0; i < 400; i++ {
+		step(k, 40, 25)
+		if got := k.SuspectAnchors(); len(got) != 0 {
+			t.Fatalf("step %d: SuspectAnchors() = %v while relocating, want none", i, got)
+		}
+	}
+	if d := math.Hypot(k.xk[0]-40, k.xk[1]-25); d > 0.5 {
+		t.Errorf("estimate (%.2f, %.2f) is %.2f m from (40, 25)", k.xk[0], k.xk[1], d)
+	}
+	if len(k.UsedSample().TWR) != len(anchors) {
+		t.Errorf("UsedSample() has %d TWR rows, want %d", len(k.UsedSample().TWR), len(anchors))
+	}
+}
@@ -0,0 +1,134 @@
+package fusion
+
+import (
+	"math"
+	"sort"
+
+	"engine-go/binlog"
+)
+
+// EventOptions tunes EventBatches.
+type EventOptions struct {
+	// OffsetMs is added to every batch timestamp.
+	OffsetMs int64
+	// Resolver maps the short anchor IDs found in frames to configured
+	// anchors; nil keeps the IDs as decoded.
+	Resolver *AnchorResolver
+}
+
+// EventBatches converts tagID's measurements in the parsed capture into
+// ProcessBatch input, one batch per event. A batch is timed by its event's
+// arrival time plus OffsetMs; the seconds prefix is not applied (see
+// binlog.InnerFrame.SecondsPrefix).
+func EventBatches(parser *binlog.BinlogParser, tagID int, opt EventOptions) []TimedBatch {
+	batches := make([]TimedBatch, 0, len(parser.Events))
+	for _, evt := range parser.Events {
+		bleS, twrS, imuS := parser.FilterSamples(evt, uint32(tagID))
+		b := TimedBatch{
+			TimestampMs: int64(math.Round(evt.Timestamp*1000.0)) + opt.OffsetMs,
+			TagID:       tagID,
+		}
+		for _, im := range imuS {
+			b.IMU = append(b.IMU, IMUMeas{Distance: im.Distance, YawDeg: im.YawDeg, Motion: IMUMotion{
+				SpeedMps:     im.SpeedMps,
+				MotionCode:   im.MotionCode,
+				YawSigmaCode: im.YawSigmaCode,
+				DsSigmaCode:  im.DsSigmaCode,
+			}})
+		}
+		ids := make([]int, 0, len(bleS)+len(twrS))
+		for _, s := range bleS {
+			ids = append(ids, s.AnchorID)
+		}
+		for _, s := range twrS {
+			ids = append(ids, s.AnchorID)
+		}
+		if opt.Resolver != nil {
+			ids = opt.Resolver.ResolveFrame(ids)
+		}
+		for i, s := range bleS {
+			b.BLE = append(b.BLE, BLEMeas{AnchorID: ids[i], RSSIDb: s.RSSIDb})
+		}
+		for i, s := range twrS {
+			b.TWR = append(b.TWR, TWRMeas{AnchorID: ids[len(bleS)+i], Range: s.RangeM})
+		}
+		batches = append(batches, b)
+	}
+	return batches
+}
+
+// Low16Groups groups anchor IDs by their low 16 bits, sorted so
+// resolution is deterministic.
+func Low16Groups(anchors map[int]Anchor) map[int][]int {
+	m := make(map[int][]int)
+	for id := range anchors {
+		m[id&0xFFFF] = append(m[id&0xFFFF], id)
+	}
+	for _, ids := range m {
+		sort.Ints(ids)
+	}
+	return m
+}
+
+// AnchorResolver maps the short anchor IDs found in frames to configured
+// anchors. When a short ID matches several anchors it picks the one on the
+// layer the tag was last seen on, judged from the unambiguous anchors of
+// recent frames, so use one resolver per tag.
+type AnchorResolver struct {
+	anchors  map[int]Anchor
+	low16    map[int][]int
+	layer    int
+	hasLayer bool
+}
+
+// NewAnchorResolver returns a resolver over anchors.
+func NewAnchorResolver(anchors map[int]Anchor) *AnchorResolver {
+	return &AnchorResolver{anchors: anchors, low16: Low16Groups(anchors)}
+}
+
+// ResolveFrame resolves all anchor IDs of one frame together.
+func (r *AnchorResolver) ResolveFrame(ids []int) []int {
+	out := make([]int, len(ids))
+	votes := map[int]int{}
+	var ambiguous []int
+	for i, aid := range ids {
+		if a, ok := r.anchors[aid]; ok {
+			out[i] = aid
+			votes[a.Layer]++
+			continue
+		}
+		switch cands := r.low16[aid&0xFFFF]; len(cands) {
+		case 0:
+			out[i] = aid
+		case 1:
+			out[i] = cands[0]
+			votes[r.anchors[cands[0]].Layer]++
+		default:
+			ambiguous = append(ambiguous, i)
+		}
+	}
+
+	best, bestN := 0, 0
+	for layer, n := range votes {
+		if n > bestN || (n == bestN && layer < best) {
+			best, bestN = layer, n
+		}
+	}
+	if bestN > 0 {
+		r.layer, r.hasLayer = best, true
+	}
+
+	for _, i := range ambiguous {
+		cands := r.low16[ids[i]&0xFFFF]
+		out[i] = cands[0]
+		if r.hasLayer {
+			for _, id := range cands {
+				if r.anchors[id].Layer == r.layer {
+					out[i] = id
+					break
+				}
+			}
+		}
+	}
+	return out
+}
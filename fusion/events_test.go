@@ -0,0 +1,42 @@
+package fusion
+
+import (
+	"reflect"
+	"testing"
+
+	"engine-go/binlog"
+)
+
+func TestEventBatches(t *testing.T) {
+	anchors := map[int]Anchor{
+		0x10001: {ID: 0x10001, Layer: 2},
+		0x20001: {ID: 0x20001, Layer: 3},
+		0x20002: {ID: 0x20002, Layer: 3},
+	}
+	parser := &binlog.BinlogParser{Events: []binlog.Event{
+		{Timestamp: 1700000000.1234, Inner: []binlog.InnerFrame{
+			{Addr: 0xB50AC, Type: 0x50, Samples: []binlog.Sample{{AnchorID: 0x0002, RangeM: 3.5}, {AnchorID: 0x0001, RangeM: 4.25}}},
+			{Addr: 0xB50AC, Type: 0x60, Samples: []binlog.Sample{{AnchorID: 0x0001, RSSIDb: -70}}},
+			{Addr: 0xB50AC, Type: 0x90, IMU: &binlog.IMUSample{Distance: 1.5, YawDeg: 90, MotionCode: 2}},
+			{Addr: 0xB50AD, Type: 0x50, Samples: []binlog.Sample{{AnchorID: 0x0002, RangeM: 9}}},
+		}},
+	}}
+
+	got := EventBatches(parser, 0xB50AC, EventOptions{OffsetMs: -100, Resolver: NewAnchorResolver(anchors)})
+	want := []TimedBatch{{
+		TimestampMs: 1700000000023,
+		TagID:       0xB50AC,
+		BLE:         []BLEMeas{{AnchorID: 0x20001, RSSIDb: -70}},
+		TWR:         []TWRMeas{{AnchorID: 0x20002, Range: 3.5}, {AnchorID: 0x20001, Range: 4.25}},
+		IMU:         []IMUMeas{{Distance: 1.5, YawDeg: 90, Motion: IMUMotion{MotionCode: 2}}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EventBatches with resolver:\n got %+v\nwant %+v", got, want)
+	}
+
+	// Without a resolver the short IDs are kept as decoded.
+	got = EventBatches(parser, 0xB50AC, EventOptions{})
+	if len(got) != 1 || got[0].TimestampMs != 1700000000123 || got[0].TWR[1].AnchorID != 0x0001 || got[0].BLE[0].AnchorID != 0x0001 {
+		t.Errorf("EventBatches without resolver = %+v", got)
+	}
+}
@@ -0,0 +1,156 @@
+package fusion
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// GeofenceRegion is a zone outline in meters on one layer.
+type GeofenceRegion struct {
+	ID      int
+	Layer   int
+	Polygon [][2]float64
+}
+
+// GeofenceRegions lists the configured regions of every layer as polygons in
+// meters. IDs are assigned from 1 in layer order, then configuration order,
+// so they are stable for a given project.xml/wogi.xml pair.
+func (lm *LayerManager) GeofenceRegions() []GeofenceRegion {
+	ids := make([]int, 0, len(lm.layers))
+	for id := range lm.layers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	out := []GeofenceRegion{}
+	for _, lid := range ids {
+		for _, r := range lm.layers[lid].Regions {
+			poly := make([][2]float64, 0, len(r.Points))
+			for _, pt := range r.Points {
+				poly = append(poly, [2]float64{pt[0] / 100.0, pt[1] / 100.0})
+			}
+			if len(poly) < 3 {
+				poly = [][2]float64{
+					{r.XTL / 100.0, r.YTL / 100.0},
+					{r.XBR / 100.0, r.YTL / 100.0},
+					{r.XBR / 100.0, r.YBR / 100.0},
+					{r.XTL / 100.0, r.YBR / 100.0},
+				}
+			}
+			out = append(out, GeofenceRegion{ID: len(out) + 1, Layer: lid, Polygon: poly})
+		}
+	}
+	return out
+}
+
+// GeofenceMonitor turns a stream of fixes into region enter/exit events.
+// A tag enters a region once it is inside by at least the hysteresis
+// distance and exits once it is outside by the same margin, so fixes
+// jittering along an edge do not flicker. Update may be called from several
+// goroutines; the callbacks run with the monitor locked.
+type GeofenceMonitor struct {
+	regions    []GeofenceRegion
+	hysteresis float64
+	onEnter    func(tagID, regionID int, tsMs int64)
+	onExit     func(tagID, regionID int, tsMs int64, dwellMs int64)
+
+	mu     sync.Mutex
+	inside map[int]map[int]int64 // tag -> region -> entry time (ms)
+}
+
+// NewGeofenceMonitor watches regions with the given hysteresis in meters
+// (GeofenceHysteresis when <= 0).
+func NewGeofenceMonitor(regions []GeofenceRegion, hysteresis float64) *GeofenceMonitor {
+	if hysteresis <= 0 {
+		hysteresis = GeofenceHysteresis
+	}
+	return &GeofenceMonitor{
+		regions:    regions,
+		hysteresis: hysteresis,
+		inside:     map[int]map[int]int64{},
+	}
+}
+
+// OnEnter registers a callback fired when a tag enters a region.
+func (m *GeofenceMonitor) OnEnter(fn func(tagID, regionID int, tsMs int64)) {
+	m.onEnter = fn
+}
+
+// OnExit registers a callback fired when a tag leaves a region, with the
+// time spent inside.
+func (m *GeofenceMonitor) OnExit(fn func(tagID, regionID int, tsMs int64, dwellMs int64)) {
+	m.onExit = fn
+}
+
+// Update feeds one result for tagID. Only valid fixes (Flag >= 1) are
+// considered; when the result carries a layer, regions on other layers
+// count as outside.
+func (m *GeofenceMonitor) Update(tagID int, res FusionResult) {
+	if res.Flag < 1 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	in := m.inside[tagID]
+	if in == nil {
+		in = map[int]int64{}
+		m.inside[tagID] = in
+	}
+	for _, r := range m.regions {
+		d := signedPolygonDistance(res.X, res.Y, r.Polygon)
+		if res.Layer != nil && *res.Layer != r.Layer {
+			d = math.Inf(-1)
+		}
+		entered, isIn := in[r.ID]
+		switch {
+		case !isIn && d >= m.hysteresis:
+			in[r.ID] = res.TimestampMs
+			if m.onEnter != nil {
+				m.onEnter(tagID, r.ID, res.TimestampMs)
+			}
+		case isIn && d <= -m.hysteresis:
+			delete(in, r.ID)
+			if m.onExit != nil {
+				m.onExit(tagID, r.ID, res.TimestampMs, res.TimestampMs-entered)
+			}
+		}
+	}
+}
+
+// Process feeds a time-ordered sequence of results for tagID.
+func (m *GeofenceMonitor) Process(tagID int, results []FusionResult) {
+	for _, res := range results {
+		m.Update(tagID, res)
+	}
+}
+
+// signedPolygonDistance is the distance from (x, y) to the polygon outline,
+// positive inside and negative outside.
+func signedPolygonDistance(x, y float64, poly [][2]float64) float64 {
+	if len(poly) == 0 {
+		return math.Inf(-1)
+	}
+	inside := false
+	best := math.Inf(1)
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[j], poly[i]
+		if (b[1] > y) != (a[1] > y) && x < (a[0]-b[0])*(y-b[1])/(a[1]-b[1])+b[0] {
+			inside = !inside
+		}
+		best = math.Min(best, segmentDistance(x, y, a, b))
+	}
+	if inside {
+		return best
+	}
+	return -best
+}
+
+func segmentDistance(x, y float64, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	l2 := dx*dx + dy*dy
+	t := 0.0
+	if l2 > 0 {
+		t = math.Max(0, math.Min(1, ((x-a[0])*dx+(y-a[1])*dy)/l2))
+	}
+	return math.Hypot(x-(a[0]+t*dx), y-(a[1]+t*dy))
+}
@@ -0,0 +1,92 @@
+package fusion
+
+import "math"
+
+// rawImuState integrates raw accelerometer/gyroscope samples into the
+// cumulative distance + heading that ProcessIMU expects.
+type rawImuState struct {
+	has    bool
+	ax, gz float64 // previous forward accel (m/s^2) and yaw rate (deg/s)
+	speed  float64 // forward speed, m/s
+	dist   float64 // cumulative forward distance, m
+	yawDeg float64
+
+	// Last two EKF fixes, used to derive the heading of travel.
+	fix     [2][2]float64
+	nFix    int
+	fixUsed bool
+}
+
+// noteFix records a valid EKF position for heading drift correction.
+func (r *rawImuState) noteFix(x, y float64) {
+	r.fix[0] = r.fix[1]
+	r.fix[1] = [2]float64{x, y}
+	if r.nFix < 2 {
+		r.nFix++
+	}
+	r.fixUsed = false
+}
+
+// correctHeading pulls the integrated yaw toward the direction of travel
+// implied by the last two UWB fixes, once per new fix.
+func (r *rawImuState) correctHeading() {
+	if r.nFix < 2 || r.fixUsed {
+		return
+	}
+	r.fixUsed = true
+	dx := r.fix[1][0] - r.fix[0][0]
+	dy := r.fix[1][1] - r.fix[0][1]
+	if math.Hypot(dx, dy) < ImuRawMinFixStep {
+		// Too short to give a reliable heading (standing still / jitter)
+		return
+	}
+	uwbYaw := math.Atan2(dy, dx) * 180.0 / math.Pi
+	err := math.Remainder(uwbYaw-r.yawDeg, 360.0)
+	r.yawDeg = math.Remainder(r.yawDeg+ImuRawHeadingGain*err, 360.0)
+}
+
+// ProcessIMURaw accepts raw IMU samples: acceleration in m/s^2 (x forward,
+// y left, z up) and angular rate in deg/s. The device is assumed to be
+// mounted level, so only ax and gz are integrated (trapezoidal rule, dt from
+// the last processed timestamp); ay, az, gx, gy are currently unused. The
+// resulting distance and heading are fed through ProcessIMU.
+func (p *FusionPipeline) ProcessIMURaw(tsMs int64, ax, ay, az, gx, gy, gz float64) {
+	r := &p.rawImu
+	if !r.has || p.lastTS == nil {
+		r.has = true
+		r.ax, r.gz = ax, gz
+		r.speed = 0
+		p.ProcessIMU(tsMs, r.dist, r.yawDeg)
+		return
+	}
+
+	if p.dropOutOfOrder(tsMs) {
+		return
+	}
+	dt := float64(tsMs-*p.lastTS) / 1000.0
+	if dt < 0 {
+		dt = 0
+	}
+	if dt > p.resetGap {
+		// ProcessIMU resets the filter (or predicts across the gap);
+		// restart integration too
+		r.ax, r.gz = ax, gz
+		r.speed = 0
+		p.ProcessIMU(tsMs, r.dist, r.yawDeg)
+		return
+	}
+
+	r.yawDeg = math.Remainder(r.yawDeg+0.5*(r.gz+gz)*dt, 360.0)
+	if p.initialized {
+		r.correctHeading()
+	}
+
+	prevSpeed := r.speed
+	r.speed += 0.5 * (r.ax + ax) * dt
+	// Accelerometer bias makes speed drift; keep it physical
+	r.speed = clamp(r.speed, 0, KinematicSpeedMax)
+	r.dist += 0.5 * (prevSpeed + r.speed) * dt
+
+	r.ax, r.gz = ax, gz
+	p.ProcessIMU(tsMs, r.dist, r.yawDeg)
+}
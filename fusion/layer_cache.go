@@ -0,0 +1,136 @@
+package fusion
+
+import (
+	"container/list"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// layerCache is a small LRU of GetLayer decisions keyed by a position grid
+// cell plus the set of anchors heard. Entries expire after LayerCacheTTL.
+type layerCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front = most recently used
+	hits    int
+	misses  int
+}
+
+type layerCacheEntry struct {
+	key     string
+	layer   *int
+	expires time.Time
+}
+
+func newLayerCache() *layerCache {
+	return &layerCache{entries: map[string]*list.Element{}, order: list.New()}
+}
+
+func layerCacheKey(bleMeas []BLEMeas, twrMeas []TWRMeas, pos [3]float64) string {
+	ids := make([]int, 0, len(bleMeas)+len(twrMeas))
+	for _, m := range bleMeas {
+		ids = append(ids, m.AnchorID)
+	}
+	for _, m := range twrMeas {
+		ids = append(ids, m.AnchorID)
+	}
+	sort.Ints(ids)
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(int(math.Floor(pos[0] / LayerCacheCell))))
+	sb.WriteByte(',')
+	sb.WriteString(strconv.Itoa(int(math.Floor(pos[1] / LayerCacheCell))))
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+		sb.WriteByte(':')
+		sb.WriteString(strconv.Itoa(id))
+	}
+	return sb.String()
+}
+
+func (c *layerCache) get(key string) (*int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	e := el.Value.(*layerCacheEntry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		c.misses++
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	c.hits++
+	return copyLayer(e.layer), true
+}
+
+func (c *layerCache) put(key string, layer *int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	exp := time.Now().Add(LayerCacheTTL)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*layerCacheEntry)
+		e.layer, e.expires = copyLayer(layer), exp
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&layerCacheEntry{key: key, layer: copyLayer(layer), expires: exp})
+	for c.order.Len() > LayerCacheSize {
+		old := c.order.Back()
+		c.order.Remove(old)
+		delete(c.entries, old.Value.(*layerCacheEntry).key)
+	}
+}
+
+func (c *layerCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*list.Element{}
+	c.order.Init()
+}
+
+// copyLayer keeps callers from aliasing the cached value.
+func copyLayer(l *int) *int {
+	if l == nil {
+		return nil
+	}
+	v := *l
+	return &v
+}
+
+// SetCacheEnabled turns on caching of GetLayer decisions. Decisions are
+// reused for the same LayerCacheCell grid cell and anchor set for up to
+// LayerCacheTTL.
+func (lm *LayerManager) SetCacheEnabled(on bool) {
+	if on && lm.cache == nil {
+		lm.cache = newLayerCache()
+	} else if !on {
+		lm.cache = nil
+	}
+}
+
+// CacheStats returns the GetLayer cache hit and miss counts.
+func (lm *LayerManager) CacheStats() (hits, misses int) {
+	if lm.cache == nil {
+		return 0, 0
+	}
+	lm.cache.mu.Lock()
+	defer lm.cache.mu.Unlock()
+	return lm.cache.hits, lm.cache.misses
+}
+
+// ClearCache drops all cached GetLayer decisions.
+func (lm *LayerManager) ClearCache() {
+	if lm.cache != nil {
+		lm.cache.clear()
+	}
+}
@@ -5,6 +5,7 @@ import (
     "io"
     "math"
     "os"
+    "sort"
     "strconv"
     "strings"
 )
@@ -16,6 +17,9 @@ type Region struct {
     YTL float64
     XBR float64
     YBR float64
+    // Points is the outline (cm) as configured; empty for regions derived
+    // from the layer bounds.
+    Points [][2]float64
 }
 
 type Layer struct {
@@ -29,6 +33,13 @@ type Layer struct {
     YBR        float64
     ProjectIdx int
     Regions    []Region
+    // Z is the layer's height (cm). project.xml has no floor height, so
+    // it is estimated as the lowest anchor Z on the layer: anchors hang
+    // at a similar height above their floor, which makes the estimate
+    // good for telling floors apart, but it sits one mounting height
+    // above the floor itself. 0 until an anchor is known.
+    Z        float64
+    zAnchors int
 }
 
 type Project struct {
@@ -44,6 +55,7 @@ type Project struct {
 type LayerManager struct {
     layers   map[int]*Layer
     projects []*Project
+    cache    *layerCache // nil unless SetCacheEnabled(true)
 }
 
 // NewLayerManager builds from parsed layers and projects.
@@ -192,7 +204,7 @@ func parseProjectRegions(path string, layers map[int]*Layer) {
             xs = append(xs, p[0])
             ys = append(ys, p[1])
         }
-        reg := Region{XTL: minSlice(xs), YTL: minSlice(ys), XBR: maxSlice(xs), YBR: maxSlice(ys)}
+        reg := Region{XTL: minSlice(xs), YTL: minSlice(ys), XBR: maxSlice(xs), YBR: maxSlice(ys), Points: pts}
         lyr, ok := layers[layerID]
         if !ok {
             lyr = &Layer{ID: layerID}
@@ -238,7 +250,7 @@ func parseWogiZones(path string, layers map[int]*Layer) {
             xs = append(xs, p[0])
             ys = append(ys, p[1])
         }
-        reg := Region{XTL: minSlice(xs), YTL: minSlice(ys), XBR: maxSlice(xs), YBR: maxSlice(ys)}
+        reg := Region{XTL: minSlice(xs), YTL: minSlice(ys), XBR: maxSlice(xs), YBR: maxSlice(ys), Points: pts}
         lyr, ok := layers[layerID]
         if !ok {
             lyr = &Layer{ID: layerID}
@@ -278,6 +290,9 @@ func fillFromAnchors(layers map[int]*Layer, anchors map[int]Anchor) {
             }
             lyr.Width = math.Max(lyr.XBR-lyr.XTL, lyr.Width)
             lyr.Height = math.Max(lyr.YBR-lyr.YTL, lyr.Height)
+            for _, a := range lst {
+                lyr.noteAnchorZ(a.Z)
+            }
         }
         layers[lid] = lyr
     }
@@ -343,6 +358,39 @@ func LayerManagerFromConfig(projectPath, wogiPath string, anchors map[int]Anchor
     return NewLayerManager(layers, projects)
 }
 
+// AddAnchorToLayer grows the anchor's layer (created if unknown) so that it
+// covers the anchor position, the way fillFromAnchors does at startup.
+// Regions derived from the layer bounds grow with it; configured outlines
+// are left alone. Not safe for use concurrently with GetLayer.
+func (lm *LayerManager) AddAnchorToLayer(a Anchor) {
+    if lm.layers == nil {
+        lm.layers = map[int]*Layer{}
+    }
+    x := a.X * 100.0
+    y := a.Y * 100.0
+    lyr, ok := lm.layers[a.Layer]
+    if !ok {
+        lyr = &Layer{ID: a.Layer, Building: a.Building, XTL: x, YTL: y, XBR: x, YBR: y}
+        lm.layers[a.Layer] = lyr
+    }
+    old := Region{XTL: lyr.XTL, YTL: lyr.YTL, XBR: lyr.XBR, YBR: lyr.YBR}
+    lyr.XTL = math.Min(lyr.XTL, x)
+    lyr.YTL = math.Min(lyr.YTL, y)
+    lyr.XBR = math.Max(lyr.XBR, x)
+    lyr.YBR = math.Max(lyr.YBR, y)
+    lyr.Width = math.Max(lyr.XBR-lyr.XTL, lyr.Width)
+    lyr.Height = math.Max(lyr.YBR-lyr.YTL, lyr.Height)
+    lyr.noteAnchorZ(a.Z)
+    for i, reg := range lyr.Regions {
+        if len(reg.Points) == 0 && reg.XTL == old.XTL && reg.YTL == old.YTL && reg.XBR == old.XBR && reg.YBR == old.YBR {
+            lyr.Regions[i] = Region{XTL: lyr.XTL, YTL: lyr.YTL, XBR: lyr.XBR, YBR: lyr.YBR}
+        }
+    }
+    ensureRegions(map[int]*Layer{a.Layer: lyr})
+    lm.projects = buildProjects(lm.layers)
+    lm.ClearCache()
+}
+
 // Helper parsing utils ----------------------------------------------------
 
 func parsePoints(val string) [][2]float64 {
@@ -457,6 +505,82 @@ func layerTrustRate(bleMeas []BLEMeas, twrMeas []TWRMeas, pos [3]float64, layerI
 
 // GetLayer mirrors Python implementation.
 func (lm *LayerManager) GetLayer(bleMeas []BLEMeas, twrMeas []TWRMeas, pos [3]float64, rssi *BLERssi, anchors map[int]Anchor) *int {
+    return lm.GetLayerFrom(bleMeas, twrMeas, pos, rssi, anchors, nil)
+}
+
+// GetLayerFrom is GetLayer for a tag last seen on layer prev (nil if
+// unknown). When several layers of a project contain pos, prev is kept
+// unless another layer's trust rate beats it by LayerHysteresis; otherwise
+// the lowest rate wins, ties going to the lower layer ID.
+func (lm *LayerManager) GetLayerFrom(bleMeas []BLEMeas, twrMeas []TWRMeas, pos [3]float64, rssi *BLERssi, anchors map[int]Anchor, prev *int) *int {
+    if lm.cache == nil {
+        return lm.getLayer(bleMeas, twrMeas, pos, rssi, anchors, prev)
+    }
+    key := layerCacheKey(bleMeas, twrMeas, pos)
+    if prev != nil {
+        key += "@" + strconv.Itoa(*prev)
+    }
+    if layer, ok := lm.cache.get(key); ok {
+        return layer
+    }
+    layer := lm.getLayer(bleMeas, twrMeas, pos, rssi, anchors, prev)
+    lm.cache.put(key, layer)
+    return layer
+}
+
+// noteAnchorZ lowers the layer height to an anchor's Z (meters).
+func (lyr *Layer) noteAnchorZ(z float64) {
+    z *= 100.0
+    if lyr.zAnchors == 0 || z < lyr.Z {
+        lyr.Z = z
+    }
+    lyr.zAnchors++
+}
+
+// LayerZ returns the height of layer id in meters, estimated from its
+// anchors (see Layer.Z); ok is false for an unknown layer.
+func (lm *LayerManager) LayerZ(id int) (z float64, ok bool) {
+    lyr, ok := lm.layers[id]
+    if !ok {
+        return 0, false
+    }
+    return lyr.Z / 100.0, true
+}
+
+// GetNearestLayer returns the indoor layer whose bounding-region centroid is
+// closest to pos, provided pos lies within MapMargin of that layer's bounds.
+// It returns nil when no layer qualifies.
+func (lm *LayerManager) GetNearestLayer(pos [3]float64) *int {
+    x := pos[0] * 100.0
+    y := pos[1] * 100.0
+    var best *Layer
+    bestID := 0
+    bestDist := math.Inf(1)
+    for id, lyr := range lm.layers {
+        if id == OutdoorLayer || lyr.XBR <= lyr.XTL || lyr.YBR <= lyr.YTL {
+            continue
+        }
+        cx := 0.5 * (lyr.XTL + lyr.XBR)
+        cy := 0.5 * (lyr.YTL + lyr.YBR)
+        d := math.Hypot(x-cx, y-cy)
+        if d < bestDist || (d == bestDist && id < bestID) {
+            best = lyr
+            bestID = id
+            bestDist = d
+        }
+    }
+    if best == nil {
+        return nil
+    }
+    dx := math.Max(0, math.Max(best.XTL-x, x-best.XBR))
+    dy := math.Max(0, math.Max(best.YTL-y, y-best.YBR))
+    if math.Hypot(dx, dy)/100.0 > MapMargin {
+        return nil
+    }
+    return &bestID
+}
+
+func (lm *LayerManager) getLayer(bleMeas []BLEMeas, twrMeas []TWRMeas, pos [3]float64, rssi *BLERssi, anchors map[int]Anchor, prev *int) *int {
     layerList := []int{}
     outdoor := false
     for _, m := range bleMeas {
@@ -532,16 +656,27 @@ func (lm *LayerManager) GetLayer(bleMeas []BLEMeas, twrMeas []TWRMeas, pos [3]fl
         return &lid
     }
 
+    // Regions come from map iteration, so order the candidates by ID to
+    // keep ties from flapping between frames.
+    sort.Slice(layersInProj, func(i, j int) bool { return layersInProj[i].ID < layersInProj[j].ID })
     var bestLayer *int
-    bestRate := 0xFF
+    bestRate := float64(0xFF)
+    prevRate := math.Inf(1)
     for _, lyr := range layersInProj {
         rate := layerTrustRate(bleMeas, twrMeas, pos, lyr.ID, rssi, anchors)
-        if rate < float64(bestRate) {
+        if prev != nil && lyr.ID == *prev {
+            prevRate = rate
+        }
+        if rate < bestRate {
             val := lyr.ID
             bestLayer = &val
-            bestRate = int(rate)
+            bestRate = rate
         }
     }
+    if bestLayer != nil && prevRate <= bestRate+LayerHysteresis {
+        val := *prev
+        return &val
+    }
     return bestLayer
 }
 
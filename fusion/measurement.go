@@ -0,0 +1,94 @@
+package fusion
+
+import "sync"
+
+// Measurement is one sensor reading passed to ProcessWithMeasurements.
+// MeasType selects how buildSample folds it into the EKF sample.
+type Measurement interface {
+	MeasType() string
+}
+
+// Built-in measurement types.
+const (
+	MeasTypeBLE = "ble"
+	MeasTypeTWR = "twr"
+)
+
+func (BLEMeas) MeasType() string { return MeasTypeBLE }
+func (TWRMeas) MeasType() string { return MeasTypeTWR }
+
+// MeasurementHandler folds a measurement of a registered type into sample,
+// typically by appending rows. It runs after the BLE/TWR rows are built and
+// gated, so it can see them.
+type MeasurementHandler func(sample *EKFSample, m Measurement, anchors map[int]Anchor)
+
+var (
+	measHandlersMu sync.RWMutex
+	measHandlers   = map[string]MeasurementHandler{}
+)
+
+// RegisterMeasurementHandler installs h for measType, replacing any previous
+// handler. Packages adding a sensor register from init. The built-in types
+// cannot be overridden.
+func RegisterMeasurementHandler(measType string, h MeasurementHandler) {
+	if measType == MeasTypeBLE || measType == MeasTypeTWR {
+		return
+	}
+	measHandlersMu.Lock()
+	defer measHandlersMu.Unlock()
+	if h == nil {
+		delete(measHandlers, measType)
+		return
+	}
+	measHandlers[measType] = h
+}
+
+func lookupMeasurementHandler(measType string) MeasurementHandler {
+	measHandlersMu.RLock()
+	defer measHandlersMu.RUnlock()
+	return measHandlers[measType]
+}
+
+// splitMeasurements separates the built-in types from the rest.
+func splitMeasurements(meas []Measurement) ([]BLEMeas, []TWRMeas, []Measurement) {
+	ble := []BLEMeas{}
+	twr := []TWRMeas{}
+	var other []Measurement
+	for _, m := range meas {
+		switch m.MeasType() {
+		case MeasTypeBLE:
+			if v, ok := m.(BLEMeas); ok {
+				ble = append(ble, v)
+			} else if v, ok := m.(*BLEMeas); ok && v != nil {
+				ble = append(ble, *v)
+			}
+		case MeasTypeTWR:
+			if v, ok := m.(TWRMeas); ok {
+				twr = append(twr, v)
+			} else if v, ok := m.(*TWRMeas); ok && v != nil {
+				twr = append(twr, *v)
+			}
+		default:
+			other = append(other, m)
+		}
+	}
+	return ble, twr, other
+}
+
+// applyMeasurements runs the registered handler for each extra measurement.
+// Types without a handler are ignored.
+func (p *FusionPipeline) applyMeasurements(sample *EKFSample, extra []Measurement) {
+	for _, m := range extra {
+		if h := lookupMeasurementHandler(m.MeasType()); h != nil {
+			h(sample, m, p.anchors)
+		}
+	}
+}
+
+// ProcessWithMeasurements is Process for a mixed measurement list. BLEMeas
+// and TWRMeas take the usual path; other types go through their registered
+// MeasurementHandler.
+func (p *FusionPipeline) ProcessWithMeasurements(tsMs int64, tagID int, meas []Measurement, tagHeight float64) FusionResult {
+	ble, twr, extra := splitMeasurements(meas)
+	return p.process(tsMs, tagID, ble, twr, extra, tagHeight)
+}
@@ -0,0 +1,91 @@
+package fusion
+
+import "math"
+
+// DTWWarpDefault is the default Sakoe-Chiba band half-width in frames.
+const DTWWarpDefault = 50
+
+// DTWDistance is the dynamic time warping distance between two trajectories:
+// the smallest summed L2 point distance over monotone alignments that stay
+// within maxWarp frames of the (length-scaled) diagonal. Unlike a best-shift
+// RMSE it tolerates pauses and speed changes in either trajectory.
+func DTWDistance(pred, ref [][2]float64, maxWarp int) float64 {
+	d, _ := DTWPath(pred, ref, maxWarp)
+	return d
+}
+
+// DTWPath is DTWDistance that also returns the number of aligned pairs on
+// the optimal path. It runs in O(n*maxWarp) time and O(maxWarp) space.
+// Empty input yields +Inf and 0. maxWarp <= 0 uses DTWWarpDefault.
+func DTWPath(pred, ref [][2]float64, maxWarp int) (float64, int) {
+	if len(pred) == 0 || len(ref) == 0 {
+		return math.Inf(1), 0
+	}
+	if maxWarp <= 0 {
+		maxWarp = DTWWarpDefault
+	}
+	// DTW with a symmetric point cost is symmetric; iterating over the
+	// longer series keeps the band centre moving at most one column a row.
+	a, b := pred, ref
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	n, m := len(a), len(b)
+	w := maxWarp
+	width := 2*w + 1
+	center := func(i int) int {
+		if n == 1 {
+			return 0
+		}
+		return int(math.Round(float64(i) * float64(m-1) / float64(n-1)))
+	}
+
+	inf := math.Inf(1)
+	prevCost, curCost := make([]float64, width), make([]float64, width)
+	prevLen, curLen := make([]int, width), make([]int, width)
+	for k := range prevCost {
+		prevCost[k] = inf
+	}
+	prevCenter := 0
+	for i := 0; i < n; i++ {
+		c := center(i)
+		for k := 0; k < width; k++ {
+			curCost[k] = inf
+			curLen[k] = 0
+			j := c - w + k
+			if j < 0 || j >= m {
+				continue
+			}
+			cost := math.Hypot(a[i][0]-b[j][0], a[i][1]-b[j][1])
+			if i == 0 && j == 0 {
+				curCost[k], curLen[k] = cost, 1
+				continue
+			}
+			best, bestLen := inf, 0
+			// (i-1, j) and (i-1, j-1) from the previous row.
+			if i > 0 {
+				for _, pj := range [2]int{j, j - 1} {
+					pk := pj - prevCenter + w
+					if pk >= 0 && pk < width && prevCost[pk] < best {
+						best, bestLen = prevCost[pk], prevLen[pk]
+					}
+				}
+			}
+			// (i, j-1) from this row.
+			if k > 0 && curCost[k-1] < best {
+				best, bestLen = curCost[k-1], curLen[k-1]
+			}
+			if !math.IsInf(best, 1) {
+				curCost[k], curLen[k] = best+cost, bestLen+1
+			}
+		}
+		prevCost, curCost = curCost, prevCost
+		prevLen, curLen = curLen, prevLen
+		prevCenter = c
+	}
+	k := (m - 1) - prevCenter + w
+	if k < 0 || k >= width {
+		return inf, 0
+	}
+	return prevCost[k], prevLen[k]
+}
@@ -3,6 +3,7 @@ package fusion
 import (
 	"math"
 	"sort"
+	"time"
 
 	"engine-go/fusion/loose"
 )
@@ -21,11 +22,36 @@ type FusionResult struct {
 	TimestampMs int64
 	X           float64
 	Y           float64
+	Z           float64 // estimated height, only set with SetZEstimation
 	Flag        int
 	UsedMea     [2]int
+	UsedAnchors []int // anchors whose rows survived gating, TWR first
 	NumBeacons  int
 	Algo        string
 	Layer       *int
+	// HDOP is the horizontal dilution of precision from the last EKF update.
+	// 0 means the geometry matrix was rank-deficient and no DOP is available.
+	HDOP float64
+	// GatedUpdates is the running count of frames the EKF skipped for
+	// exceeding the Mahalanobis gate.
+	GatedUpdates int
+	// TruncatedUpdates is the running count of frames with more than
+	// MaxMeaDim TWR/BLE rows, of which only MaxMeaDim were used.
+	TruncatedUpdates int
+	// PredictOnlyCount is the number of consecutive predict-only EKF steps
+	// (no usable measurement) up to this result.
+	PredictOnlyCount int
+	// Quality is a 0-100 fix score from QualityScore; 0 for flags below 1.
+	Quality int
+	// Sample is the gated EKF input for this fix. Only set when sample
+	// capture is enabled with SetCaptureSample.
+	Sample *EKFSample
+	// Residuals holds per-anchor innovations. Only set when residual
+	// reporting is enabled with SetResidualDebug.
+	Residuals []AnchorResidual
+	// Stationary is set while zero-velocity updates are applied (see
+	// EnableZUPT).
+	Stationary bool
 }
 
 type mapBounds struct {
@@ -57,19 +83,67 @@ type FusionPipeline struct {
 	pendingImu   float64
 	pendingYaw   float64
 	pendingYawOk bool
+	rawImu       rawImuState
+	rssiSmooth   *rssiSmoother
+	tagID        int
+	onReset      func(tagID int, reason string, tsMs int64)
+	onResult     func(tagID int, result FusionResult)
+
+	// prevLayer is the last layer assigned to the tag, for hysteresis in
+	// layer selection.
+	prevLayer *int
+
+	tagHeights       map[int]float64
+	defaultTagHeight float64
+
+	batchWindowMs int64
+	splitUpdates  bool
+	outOfOrder    int64
+	maxTwrRange   float64
+	dimCap        int
+	bleCap        int
+	twrGateTol    float64
+
+	// Anchors taken out of service (see ExcludeAnchor)
+	excluded map[int]struct{}
+
+	looseCfg      loose.Config
+	looseSnapBack float64
+
+	captureSample bool
+	residualDebug bool
+
+	// Per-Process diagnostics output (see SetDiagnosticsWriter)
+	diag *diagWriter
+
+	zupt zuptState
+
+	dr deadReckonState
+
+	smoother smootherCapture
+
+	// Flag -3 after more than this many predict-only steps (<= 0 = never)
+	predictOnlyMax int
+
+	// Update gap (seconds) past which the filter resets, unless predictGap
+	// lets it predict across
+	resetGap   float64
+	predictGap bool
+}
+
+// zuptState tracks how long the filter velocity has stayed below the ZUPT
+// threshold.
+type zuptState struct {
+	enabled    bool
+	velThresh  float64 // m/s
+	durMs      int64
+	stillSince *int64
+	active     bool
 }
 
 func NewFusionPipeline(anchors map[int]Anchor, rssi *BLERssi, dimMap map[int][]DimMat, beaconLayer map[int]int, beaconDims map[int][]DimMat, lm *LayerManager) *FusionPipeline {
-	// Ensure Short ID aliases exist for lookups
-	for id, a := range anchors {
-		short := id & 0xFFFF
-		if _, ok := anchors[short]; !ok {
-			alias := a
-			alias.ID = short
-			anchors[short] = alias
-		}
-	}
-	return &FusionPipeline{
+	addShortAliases(anchors)
+	p := &FusionPipeline{
 		anchors:      anchors,
 		rssiModel:    rssi,
 		ekf:          NewEKF(),
@@ -79,9 +153,23 @@ func NewFusionPipeline(anchors map[int]Anchor, rssi *BLERssi, dimMap map[int][]D
 		layerManager: lm,
 		divergeCount: 0,
 		looseFusor:   loose.NewFusor(loose.DefaultConfig()),
-		bounds:       computeMapBounds(anchors, dimMap, beaconDims),
+		looseCfg:     loose.DefaultConfig(),
+		bounds:       computeMapBounds(anchors, dimMap, beaconDims, lm),
 		graph:        NewGraphSmoother(rssi, 60),
+
+		tagHeights:       map[int]float64{},
+		defaultTagHeight: DefaultTagHeight,
+		batchWindowMs:    BatchWindowMs,
+		maxTwrRange:      MaxTwrRange,
+		dimCap:           DimCap,
+		bleCap:           BleCap,
+		twrGateTol:       TWRGateTolerance,
+		looseSnapBack:    LooseSnapBackMeters,
+		predictOnlyMax:   PredictOnlyMax,
+		resetGap:         ResetGap.Seconds(),
 	}
+	p.syncEKFBounds()
+	return p
 }
 
 func (p *FusionPipeline) AddAnchor(a Anchor) {
@@ -94,6 +182,350 @@ func (p *FusionPipeline) HasAnchor(id int) bool {
 	return ok
 }
 
+// SetTagHeight stores the mounting height (meters) used for tagID when
+// Process is called with tagHeight <= 0.
+func (p *FusionPipeline) SetTagHeight(tagID int, height float64) {
+	p.tagHeights[tagID] = height
+}
+
+// SetDefaultTagHeight sets the height used when neither the caller nor
+// SetTagHeight provides one (default DefaultTagHeight).
+func (p *FusionPipeline) SetDefaultTagHeight(height float64) {
+	p.defaultTagHeight = height
+}
+
+// SetLooseSnapBack sets how far (meters) the LooseFusor output may drift from
+// the EKF before it is discarded and the fusor re-seeded from the EKF
+// (default LooseSnapBackMeters). Large sites with fast movers may need more.
+// The covariance watchdog only watches the EKF, so a generous threshold lets
+// LooseFusor output wander further before anything pulls it back; the map
+// bound and kinematic checks on the output still apply.
+func (p *FusionPipeline) SetLooseSnapBack(meters float64) {
+	if meters <= 0 {
+		meters = LooseSnapBackMeters
+	}
+	p.looseSnapBack = meters
+}
+
+// SetDimCap sets how many dimension constraints buildSample adds per frame
+// (default DimCap); <= 0 restores the default.
+func (p *FusionPipeline) SetDimCap(n int) {
+	if n <= 0 {
+		n = DimCap
+	}
+	p.dimCap = n
+}
+
+// SetBleCap limits the BLE rows fed to the EKF per frame to the n
+// strongest (default BleCap); <= 0 restores the default. The EKF itself
+// never takes more than MaxMeaDim rows.
+func (p *FusionPipeline) SetBleCap(n int) {
+	if n <= 0 {
+		n = BleCap
+	}
+	p.bleCap = n
+}
+
+// SetTWRGateTolerance sets the slack (meters) of the TWR geometry gate
+// (default TWRGateTolerance); tol <= 0 disables the gate.
+func (p *FusionPipeline) SetTWRGateTolerance(tol float64) {
+	p.twrGateTol = tol
+}
+
+// SetMaxRange sets the longest TWR range (meters) buildSample accepts
+// (default MaxTwrRange); <= 0 restores the default. TWR frames carry ranges
+// as uint16 centimeters, so no decoded range exceeds 655.35 m and a larger
+// limit only turns the check off. The firmware's extended range encoding
+// is not decoded: its meta flag and scale byte are not specified yet.
+func (p *FusionPipeline) SetMaxRange(meters float64) {
+	if meters <= 0 {
+		meters = MaxTwrRange
+	}
+	p.maxTwrRange = meters
+}
+
+// ExcludeAnchor stops measurements from anchor id being used for layer
+// selection or fusion, e.g. while a faulty anchor awaits maintenance. A
+// short (16 bit) id also excludes full ids with those low bits.
+func (p *FusionPipeline) ExcludeAnchor(id int) {
+	if p.excluded == nil {
+		p.excluded = map[int]struct{}{}
+	}
+	p.excluded[id] = struct{}{}
+}
+
+// IncludeAnchor puts an excluded anchor back in service.
+func (p *FusionPipeline) IncludeAnchor(id int) {
+	delete(p.excluded, id)
+}
+
+// ExcludedAnchors lists the excluded anchor ids in ascending order.
+func (p *FusionPipeline) ExcludedAnchors() []int {
+	ids := make([]int, 0, len(p.excluded))
+	for id := range p.excluded {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (p *FusionPipeline) isExcluded(id int) bool {
+	if len(p.excluded) == 0 {
+		return false
+	}
+	if _, ok := p.excluded[id]; ok {
+		return true
+	}
+	_, ok := p.excluded[id&0xFFFF]
+	return ok
+}
+
+// dropExcluded filters measurements from excluded anchors. The input
+// slices are left untouched.
+func (p *FusionPipeline) dropExcluded(bleMeas []BLEMeas, twrMeas []TWRMeas) ([]BLEMeas, []TWRMeas) {
+	if len(p.excluded) == 0 {
+		return bleMeas, twrMeas
+	}
+	ble := make([]BLEMeas, 0, len(bleMeas))
+	for _, m := range bleMeas {
+		if !p.isExcluded(m.AnchorID) {
+			ble = append(ble, m)
+		}
+	}
+	twr := make([]TWRMeas, 0, len(twrMeas))
+	for _, m := range twrMeas {
+		if !p.isExcluded(m.AnchorID) {
+			twr = append(twr, m)
+		}
+	}
+	return ble, twr
+}
+
+// SetLooseConfig replaces the LooseFusor tuning. The running fusor is
+// rebuilt, and every later re-seed (snap-back or watchdog reset) uses cfg.
+func (p *FusionPipeline) SetLooseConfig(cfg loose.Config) {
+	p.looseCfg = cfg
+	p.looseFusor = loose.NewFusor(cfg)
+}
+
+func (p *FusionPipeline) resolveTagHeight(tagID int, tagHeight float64) float64 {
+	if tagHeight > 0 {
+		return tagHeight
+	}
+	if h, ok := p.tagHeights[tagID]; ok {
+		return h
+	}
+	return p.defaultTagHeight
+}
+
+// SetZEstimation adds height and vertical velocity to the EKF state so
+// ranges use the estimated Z instead of the fixed tag height. It resets the
+// filter; 2D deployments should leave it off.
+func (p *FusionPipeline) SetZEstimation(on bool) {
+	p.ekf.SetZState(on)
+	p.initialized = false
+}
+
+// SetInitialPosition seeds the filter at a known position (e.g. a charging
+// dock) instead of waiting for measurements to converge. Position variance
+// starts at Pow2(InitPosSigma) and the pipeline counts as initialized, so
+// the next Process call reports (x, y) even without measurements.
+func (p *FusionPipeline) SetInitialPosition(x, y float64) {
+	p.resetFilters()
+	p.ekf.xk[0] = x
+	p.ekf.xk[1] = y
+	p.ekf.Pxk[0][0] = Pow2(InitPosSigma)
+	p.ekf.Pxk[1][1] = Pow2(InitPosSigma)
+	p.initialized = true
+	p.lastGoodPos = [2]float64{x, y}
+	p.hasLastGood = true
+}
+
+// SetMotionProfile sets the velocity clamp and deceleration for this tag
+// (default DefaultMotionProfile), e.g. faster limits for vehicles.
+func (p *FusionPipeline) SetMotionProfile(mp MotionProfile) {
+	p.ekf.SetMotionProfile(mp)
+}
+
+// SetMahalanobisGate sets the innovation distance above which a frame's
+// measurements are skipped and the filter only predicts (default
+// MahalanobisGateDefault). Gated frames are counted in
+// FusionResult.GatedUpdates.
+func (p *FusionPipeline) SetMahalanobisGate(gate float64) {
+	p.ekf.SetMahalanobisGate(gate)
+}
+
+// SetHuberDelta enables the robust Huber-weighted iterated EKF update;
+// d <= 0 disables it (the default).
+func (p *FusionPipeline) SetHuberDelta(d float64) {
+	p.ekf.SetHuberDelta(d)
+}
+
+// SetUKF switches the filter's measurement update between the Jacobian
+// (default) and the unscented transform, which follows the BLE log-distance
+// model better at short ranges.
+func (p *FusionPipeline) SetUKF(on bool) {
+	p.ekf.UseUKF = on
+}
+
+// SetPredictOnlyLimit flags results -3 once the EKF has run more than n
+// consecutive predict-only steps; n <= 0 disables the check. The default
+// is PredictOnlyMax.
+func (p *FusionPipeline) SetPredictOnlyLimit(n int) {
+	p.predictOnlyMax = n
+}
+
+// SetResetGap sets how long a tag may go without an update before the
+// filter is reset; gap <= 0 restores ResetGap. Raise it for tags that
+// report less often than that.
+func (p *FusionPipeline) SetResetGap(gap time.Duration) {
+	if gap <= 0 {
+		gap = ResetGap
+	}
+	p.resetGap = gap.Seconds()
+}
+
+// SetPredictAcrossGap makes a gap longer than the reset gap predict the
+// filter forward instead of resetting it, provided the tag has a position
+// inside the map bounds. The stale velocity is cleared first, so the
+// position holds while its covariance grows with the gap; a gap long enough
+// to blow the covariance up still resets.
+func (p *FusionPipeline) SetPredictAcrossGap(on bool) {
+	p.predictGap = on
+}
+
+// gapReset reports whether an update dt seconds after the last one must
+// reset the filter. With SetPredictAcrossGap it clears the velocity and
+// returns false instead when the tag has an in-bounds position to carry.
+func (p *FusionPipeline) gapReset(dt float64) bool {
+	if dt <= p.resetGap {
+		return false
+	}
+	if !p.predictGap || !p.initialized || !p.hasLastGood || p.outOfBounds(p.ekf.xk[0], p.ekf.xk[1]) {
+		return true
+	}
+	p.ekf.xk[2], p.ekf.xk[3] = 0, 0
+	if p.ekf.estZ {
+		p.ekf.xk[IdxVz] = 0
+	}
+	return false
+}
+
+// EnableZUPT turns on zero-velocity updates: once the filter speed has
+// stayed below velocityThresholdMs (m/s) for durationMs, every update also
+// applies a vx = vy = 0 pseudo measurement (variance ZUPTNoise) until the
+// speed rises above the threshold again. This keeps a tag left on a desk
+// from drifting. A threshold <= 0 disables it.
+func (p *FusionPipeline) EnableZUPT(velocityThresholdMs, durationMs float64) {
+	p.zupt = zuptState{
+		enabled:   velocityThresholdMs > 0,
+		velThresh: velocityThresholdMs,
+		durMs:     int64(durationMs),
+	}
+}
+
+// applyZUPT updates the stationary detector after a filter update at tsMs
+// and, while it is active, applies the zero-velocity update. It reports
+// whether the tag is considered stationary.
+func (p *FusionPipeline) applyZUPT(tsMs int64) bool {
+	z := &p.zupt
+	if !z.enabled {
+		return false
+	}
+	if math.Hypot(p.ekf.xk[2], p.ekf.xk[3]) >= z.velThresh {
+		z.stillSince = nil
+		z.active = false
+		return false
+	}
+	if z.stillSince == nil {
+		z.stillSince = new(int64)
+		*z.stillSince = tsMs
+	}
+	z.active = tsMs-*z.stillSince >= z.durMs
+	if z.active {
+		p.ekf.ZeroVelocityUpdate(ZUPTNoise)
+	}
+	return z.active
+}
+
+// SetCaptureSample makes Process attach the gated EKFSample to each result
+// for per-anchor diagnostics.
+func (p *FusionPipeline) SetCaptureSample(on bool) {
+	p.captureSample = on
+}
+
+// SetResidualDebug enables per-anchor innovation reporting in
+// FusionResult.Residuals. It adds per-update allocations, so leave it off in
+// production.
+func (p *FusionPipeline) SetResidualDebug(on bool) {
+	p.residualDebug = on
+	p.ekf.RecordResiduals = on || p.diag != nil
+}
+
+// SetSuspectCount sets how many consecutive inconsistent updates exclude an
+// anchor (default SuspectCountDefault).
+func (p *FusionPipeline) SetSuspectCount(n int) {
+	p.ekf.SuspectCount = n
+}
+
+// SuspectAnchors returns anchors currently excluded by the EKF.
+func (p *FusionPipeline) SuspectAnchors() []int {
+	return p.ekf.SuspectAnchors()
+}
+
+// Reasons passed to the OnReset callback.
+const (
+	ResetDtGap       = "dt_gap"        // longer than the reset gap since the last update
+	ResetCovariance  = "covariance"    // position variance exploded
+	ResetDivergence  = "divergence"    // too many consecutive gated updates
+	ResetNaN         = "nan"           // output position was NaN
+	ResetOutOfBounds = "out_of_bounds" // output outside the map bounds
+	ResetKinematic   = "kinematic"     // output moved faster than KinematicSpeedMax
+	ResetJump        = "jump"          // output jumped more than MaxJumpPerStep
+	ResetLooseSnap   = "loose_snap"    // LooseFusor drifted from the EKF and was re-seeded
+)
+
+// OnReset registers fn to be called whenever the pipeline resets its
+// filters (or re-seeds the LooseFusor), with one of the Reset* reasons.
+// tagID is the tag of the most recent Process call.
+func (p *FusionPipeline) OnReset(fn func(tagID int, reason string, tsMs int64)) {
+	p.onReset = fn
+}
+
+// SetResultCallback registers fn to receive the results of ProcessAsync
+// that carry a position (Flag >= 1). A nil fn removes the callback.
+func (p *FusionPipeline) SetResultCallback(fn func(tagID int, result FusionResult)) {
+	p.onResult = fn
+}
+
+// ProcessAsync runs Process in the calling goroutine and hands a result
+// with a position to the SetResultCallback callback instead of returning
+// it.
+func (p *FusionPipeline) ProcessAsync(tsMs int64, tagID int, bleMeas []BLEMeas, twrMeas []TWRMeas, tagHeight float64) {
+	res := p.Process(tsMs, tagID, bleMeas, twrMeas, tagHeight)
+	if res.Flag >= 1 && p.onResult != nil {
+		p.onResult(tagID, res)
+	}
+}
+
+func (p *FusionPipeline) emitReset(reason string, tsMs int64) {
+	if p.onReset != nil {
+		p.onReset(p.tagID, reason, tsMs)
+	}
+}
+
+// Reset clears all filter state, as if the pipeline were newly created,
+// and drops cached layer decisions.
+func (p *FusionPipeline) Reset() {
+	p.resetFilters()
+	if p.rssiSmooth != nil {
+		p.rssiSmooth.reset()
+	}
+	if p.layerManager != nil {
+		p.layerManager.ClearCache()
+	}
+}
+
 func (p *FusionPipeline) resetFilters() {
 	p.ekf.resetState()
 	p.initialized = false
@@ -102,7 +534,14 @@ func (p *FusionPipeline) resetFilters() {
 	p.lastImuDist = nil
 	p.hasLastGood = false
 	p.lastGoodTs = nil
-	p.looseFusor = loose.NewFusor(loose.DefaultConfig())
+	p.looseFusor = loose.NewFusor(p.looseCfg)
+	p.rawImu.has = false
+	p.rawImu.nFix = 0
+	p.zupt.stillSince = nil
+	p.zupt.active = false
+	p.prevLayer = nil
+	p.dr = deadReckonState{gapMs: p.dr.gapMs}
+	p.smoother.broken = true
 }
 
 func (p *FusionPipeline) outOfBounds(x, y float64) bool {
@@ -131,6 +570,7 @@ func (p *FusionPipeline) extendBounds(x, y float64) {
 			maxY: y + MapMargin,
 			has:  true,
 		}
+		p.syncEKFBounds()
 		return
 	}
 	if x-MapMargin < p.bounds.minX {
@@ -145,9 +585,21 @@ func (p *FusionPipeline) extendBounds(x, y float64) {
 	if y+MapMargin > p.bounds.maxY {
 		p.bounds.maxY = y + MapMargin
 	}
+	p.syncEKFBounds()
+}
+
+// syncEKFBounds hands the map bounds to the EKF so that its own state
+// clamp (IMU dead reckoning, constrained updates) uses the map extent.
+func (p *FusionPipeline) syncEKFBounds() {
+	if !p.bounds.has {
+		return
+	}
+	p.ekf.SetPositionBounds(p.bounds.minX, p.bounds.maxX, p.bounds.minY, p.bounds.maxY)
 }
 
-func (p *FusionPipeline) chooseLayer(bleMeas []BLEMeas, twrMeas []TWRMeas, currentPos [2]float64) *int {
+// chooseLayer picks the layer for this frame, preferring prev (the tag's
+// previous layer) when it is about as good as the best candidate.
+func (p *FusionPipeline) chooseLayer(bleMeas []BLEMeas, twrMeas []TWRMeas, currentPos [2]float64, prev *int) *int {
 	if p.layerManager == nil {
 		return nil
 	}
@@ -182,11 +634,40 @@ func (p *FusionPipeline) chooseLayer(bleMeas []BLEMeas, twrMeas []TWRMeas, curre
 			pos3 = [3]float64{0, 0, 0}
 		}
 	}
-	layer := p.layerManager.GetLayer(bleMeas, twrMeas, pos3, p.rssiModel, p.anchors)
+	layer := p.layerManager.GetLayerFrom(bleMeas, twrMeas, pos3, p.rssiModel, p.anchors, prev)
 	return layer
 }
 
-func (p *FusionPipeline) buildSample(tsMs int64, tagID int, bleMeas []BLEMeas, twrMeas []TWRMeas, tagHeight float64, layerSel *int, currentPos [2]float64, initialized bool) (*EKFSample, []DimMat) {
+// SetRssiSmoothing enables per-anchor exponential smoothing of BLE strength
+// with time constant tau before range conversion. tau <= 0 disables it,
+// which is the default so offline runs match raw-sample behaviour.
+func (p *FusionPipeline) SetRssiSmoothing(tau time.Duration) {
+	if tau <= 0 {
+		p.rssiSmooth = nil
+		return
+	}
+	p.rssiSmooth = newRssiSmoother(tau, RssiStaleAfter)
+}
+
+// bleStrengths returns the strength to use per anchor for this sample,
+// smoothed when SetRssiSmoothing is on.
+func (p *FusionPipeline) bleStrengths(tsMs int64, bleMeas []BLEMeas) map[int]int {
+	out := make(map[int]int, len(bleMeas))
+	for _, m := range bleMeas {
+		strength := p.rssiModel.StrengthFromDbm(m.RSSIDb)
+		if p.rssiSmooth != nil {
+			strength = p.rssiSmooth.filter(m.AnchorID, tsMs, strength)
+		}
+		out[m.AnchorID] = strength
+	}
+	if p.rssiSmooth != nil {
+		p.rssiSmooth.evict(tsMs)
+	}
+	return out
+}
+
+func (p *FusionPipeline) buildSample(tsMs int64, tagID int, bleMeas []BLEMeas, twrMeas []TWRMeas, extra []Measurement, tagHeight float64, layerSel *int, currentPos [2]float64, initialized bool) (*EKFSample, []DimMat) {
+	strengths := p.bleStrengths(tsMs, bleMeas)
 	bleRows := []BLERow{}
 	bleEstRanges := []float64{}
 	for _, m := range bleMeas {
@@ -194,20 +675,25 @@ func (p *FusionPipeline) buildSample(tsMs int64, tagID int, bleMeas []BLEMeas, t
 		if !ok {
 			continue
 		}
-		strength := p.rssiModel.StrengthFromDbm(m.RSSIDb)
+		strength := strengths[m.AnchorID]
 		bleRows = append(bleRows, BLERow{X: a.X, Y: a.Y, Z: a.Z, Strength: float64(strength), AnchorID: m.AnchorID, Layer: a.Layer})
 		if p.rssiModel.ValidRssi(strength) {
 			bleEstRanges = append(bleEstRanges, 0.01*float64(p.rssiModel.Rssi2Range(strength)))
 		}
 	}
 
+	if len(bleRows) > p.bleCap {
+		sort.SliceStable(bleRows, func(i, j int) bool { return bleRows[i].Strength < bleRows[j].Strength })
+		bleRows = bleRows[:p.bleCap]
+	}
+
 	twrRows := []TWRRow{}
 	for _, m := range twrMeas {
 		a, ok := p.anchors[m.AnchorID]
 		if !ok {
 			continue
 		}
-		if m.Range < 0.01 || m.Range > 400.0 {
+		if m.Range < 0.01 || m.Range > p.maxTwrRange {
 			continue
 		}
 
@@ -234,6 +720,7 @@ func (p *FusionPipeline) buildSample(tsMs int64, tagID int, bleMeas []BLEMeas, t
 		}
 		twrRows = append(twrRows, TWRRow{X: a.X, Y: a.Y, Z: a.Z, Range: m.Range, AnchorID: m.AnchorID, Layer: a.Layer})
 	}
+	twrRows = p.gateTWRGeometry(twrRows)
 
 	// dim constraints
 	dimPos := []DimMat{}
@@ -245,14 +732,14 @@ func (p *FusionPipeline) buildSample(tsMs int64, tagID int, bleMeas []BLEMeas, t
 		if _, ok := p.anchors[m.AnchorID]; !ok {
 			continue
 		}
-		strength := p.rssiModel.StrengthFromDbm(m.RSSIDb)
+		strength := strengths[m.AnchorID]
 		bleList = append(bleList, struct {
 			aid      int
 			strength int
 		}{aid: m.AnchorID, strength: strength})
 	}
 	sort.Slice(bleList, func(i, j int) bool { return bleList[i].strength < bleList[j].strength })
-	dimCap := 5
+	dimCap := p.dimCap
 	for _, item := range bleList {
 		if len(dimPos) >= dimCap {
 			break
@@ -298,22 +785,79 @@ func (p *FusionPipeline) buildSample(tsMs int64, tagID int, bleMeas []BLEMeas, t
 		TWR:       twrRows,
 		DimPos:    dimPos,
 	}
-	return sample, dimPos
+	p.applyMeasurements(sample, extra)
+	return sample, sample.DimPos
+}
+
+// gateTWRGeometry drops ranges that break the triangle inequality against
+// most other anchors: for a true position, |r_i - r_j| cannot exceed the
+// distance between anchors i and j. A row is an outlier when that fails,
+// by more than the gate tolerance, for over half of the other rows. Unlike
+// the distance gate it needs no filter state, so it also works before the
+// first fix. Fewer than three rows cannot be judged and pass unchanged.
+func (p *FusionPipeline) gateTWRGeometry(rows []TWRRow) []TWRRow {
+	if p.twrGateTol <= 0 || len(rows) < 3 {
+		return rows
+	}
+	kept := make([]TWRRow, 0, len(rows))
+	for i, ri := range rows {
+		bad := 0
+		for j, rj := range rows {
+			if i == j {
+				continue
+			}
+			sep := math.Sqrt(Pow2(ri.X-rj.X) + Pow2(ri.Y-rj.Y) + Pow2(ri.Z-rj.Z))
+			if math.Abs(ri.Range-rj.Range) > sep+p.twrGateTol {
+				bad++
+			}
+		}
+		if 2*bad <= len(rows)-1 {
+			kept = append(kept, ri)
+		}
+	}
+	return kept
 }
 
+// Process fuses one BLE/TWR measurement set. See ProcessWithMeasurements
+// for other sensor types.
 func (p *FusionPipeline) Process(tsMs int64, tagID int, bleMeas []BLEMeas, twrMeas []TWRMeas, tagHeight float64) FusionResult {
+	return p.process(tsMs, tagID, bleMeas, twrMeas, nil, tagHeight)
+}
+
+func (p *FusionPipeline) process(tsMs int64, tagID int, bleMeas []BLEMeas, twrMeas []TWRMeas, extra []Measurement, tagHeight float64) FusionResult {
+	if p.diag == nil {
+		return p.step(tsMs, tagID, bleMeas, twrMeas, extra, tagHeight)
+	}
+	// Residuals of an earlier call must not show up when this one returns
+	// before the update.
+	p.ekf.Residuals = nil
+	res := p.step(tsMs, tagID, bleMeas, twrMeas, extra, tagHeight)
+	p.writeDiag(tsMs, tagID, bleMeas, twrMeas, res)
+	return res
+}
+
+// step runs one filter update for process.
+func (p *FusionPipeline) step(tsMs int64, tagID int, bleMeas []BLEMeas, twrMeas []TWRMeas, extra []Measurement, tagHeight float64) FusionResult {
+	p.tagID = tagID
+	tagHeight = p.resolveTagHeight(tagID, tagHeight)
 	if p.lastTS == nil {
 		p.lastTS = new(int64)
 		*p.lastTS = tsMs
 	}
+	if p.dropOutOfOrder(tsMs) {
+		return FusionResult{TimestampMs: tsMs, X: p.ekf.xk[0], Y: p.ekf.xk[1], Flag: -1, UsedMea: [2]int{0, 0}, NumBeacons: 0, Algo: "NA"}
+	}
 	currentPos := [2]float64{0, 0}
 	if p.initialized {
 		currentPos[0] = p.ekf.xk[0]
 		currentPos[1] = p.ekf.xk[1]
 	}
 
-	layerSel := p.chooseLayer(bleMeas, twrMeas, currentPos)
-	sample, dimUsed := p.buildSample(tsMs, tagID, bleMeas, twrMeas, tagHeight, layerSel, currentPos, p.initialized)
+	// Excluded anchors are dropped once here, so layer selection, the EKF
+	// sample and the graph smoother all ignore them.
+	bleMeas, twrMeas = p.dropExcluded(bleMeas, twrMeas)
+	layerSel := p.chooseLayer(bleMeas, twrMeas, currentPos, p.prevLayer)
+	sample, dimUsed := p.buildSample(tsMs, tagID, bleMeas, twrMeas, extra, tagHeight, layerSel, currentPos, p.initialized)
 
 	// Feed sliding-window graph (probabilistic smoother)
 	p.graph.AddStep(float64(tsMs)/1000.0, p.pendingImu, p.pendingYaw, bleMeas, twrMeas, p.anchors)
@@ -346,36 +890,45 @@ func (p *FusionPipeline) Process(tsMs int64, tagID int, bleMeas []BLEMeas, twrMe
 		p.divergeCount = 0
 	}
 
-	if tsMs <= *p.lastTS {
-		tsMs = *p.lastTS + 1
+	// Slightly late frames are treated as simultaneous with the last one.
+	if tsMs < *p.lastTS {
+		tsMs = *p.lastTS
 	}
 	dt := float64(tsMs-*p.lastTS) / 1000.0
-	if dt > 30.0 {
+	if p.gapReset(dt) {
+		p.emitReset(ResetDtGap, tsMs)
 		p.resetFilters()
 		p.lastTS = new(int64)
 		*p.lastTS = tsMs
 		return FusionResult{TimestampMs: tsMs, X: 0, Y: 0, Flag: -2, UsedMea: [2]int{0, 0}, NumBeacons: 0, Algo: "NA", Layer: layerSel}
 	}
 
-	p.ekf.Updt(math.Max(dt, 0.01))
-	p.ekf.UpMeas(sample)
+	p.ekf.Updt(predictDt(dt))
+	sample = p.ekf.UpMeas(sample)
 	p.ekf.KfUpdate(sample)
 	*p.lastTS = tsMs
 	flag := p.ekf.ret
+	if flag == -2 {
+		// KfUpdate produced a non-finite state and reset itself
+		p.emitReset(ResetNaN, tsMs)
+	}
 
 	// Watchdog: If state covariance explodes (Sigma > 100m), reset
 	// This allows large coordinates but catches filter divergence.
 	if p.ekf.Pxk[0][0] > 10000.0 || p.ekf.Pxk[1][1] > 10000.0 {
+		p.emitReset(ResetCovariance, tsMs)
 		p.resetFilters()
 		p.lastTS = new(int64)
 		*p.lastTS = tsMs
 		return FusionResult{TimestampMs: tsMs, X: 0, Y: 0, Flag: -2, UsedMea: [2]int{0, 0}, NumBeacons: 0, Algo: "NA", Layer: layerSel}
 	}
 
-	// Check for divergence/rejection
-	if flag == -3 {
+	// Check for divergence/rejection. A gated frame counts too: a tag that
+	// really jumped keeps failing the gate until the reset relocates it.
+	if flag == -3 || p.ekf.gated {
 		p.divergeCount++
 		if p.divergeCount > 5 {
+			p.emitReset(ResetDivergence, tsMs)
 			p.resetFilters()
 			p.lastTS = new(int64)
 			*p.lastTS = tsMs
@@ -389,11 +942,16 @@ func (p *FusionPipeline) Process(tsMs int64, tagID int, bleMeas []BLEMeas, twrMe
 	if flag == 1 {
 		p.ekf.PredictConstrain()
 	}
+	stationary := false
+	if flag >= 1 {
+		stationary = p.applyZUPT(tsMs)
+	}
 
 	// Feed valid EKF positions to LooseFusor as "UWB Fixes"
 	// This allows LooseFusor to benefit from the geometry solver of EKF
 	tsSec := float64(tsMs) / 1000.0
 	if flag == 2 { // 2 = Measurement Updated
+		p.rawImu.noteFix(p.ekf.xk[0], p.ekf.xk[1])
 		uwbFix := loose.UwbFix{X: p.ekf.xk[0], Y: p.ekf.xk[1]}
 		p.looseFusor.IngestBatch(loose.SensorBatch{
 			Timestamp: tsSec,
@@ -403,11 +961,16 @@ func (p *FusionPipeline) Process(tsMs int64, tagID int, bleMeas []BLEMeas, twrMe
 
 	if p.layerManager != nil {
 		curr := [3]float64{p.ekf.xk[0], p.ekf.xk[1], 0}
-		chk := p.layerManager.GetLayer(bleMeas, twrMeas, curr, p.rssiModel, p.anchors)
+		chk := p.layerManager.GetLayerFrom(bleMeas, twrMeas, curr, p.rssiModel, p.anchors, p.prevLayer)
+		if chk == nil && p.initialized {
+			// just outside every layer: keep the tag on the nearest one
+			chk = p.layerManager.GetNearestLayer(curr)
+		}
 		if chk == nil {
 			flag = -1
 		} else {
 			layerSel = chk
+			p.prevLayer = chk
 		}
 	}
 
@@ -433,13 +996,14 @@ func (p *FusionPipeline) Process(tsMs int64, tagID int, bleMeas []BLEMeas, twrMe
 			// Divergence Check: If LooseFusor drifts too far from EKF (Ground Truth),
 			// snap back to EKF and reset LooseFusor.
 			dist := math.Hypot(lX-p.ekf.xk[0], lY-p.ekf.xk[1])
-			if dist > 20.0 {
+			if dist > p.looseSnapBack {
 				// Divergence detected! Trust EKF.
 				// Log for debugging
 				// log.Printf("Divergence: EKF(%.1f, %.1f) Loose(%.1f, %.1f) Dist=%.1f", p.ekf.xk[0], p.ekf.xk[1], lX, lY, dist)
 				outX, outY = p.ekf.xk[0], p.ekf.xk[1]
 				// Reset LooseFusor to snap it back
-				p.looseFusor = loose.NewFusor(loose.DefaultConfig())
+				p.emitReset(ResetLooseSnap, tsMs)
+				p.looseFusor = loose.NewFusor(p.looseCfg)
 				// Seed new fusor with current EKF state
 				p.looseFusor.IngestBatch(loose.SensorBatch{
 					Timestamp: tsSec,
@@ -455,8 +1019,13 @@ func (p *FusionPipeline) Process(tsMs int64, tagID int, bleMeas []BLEMeas, twrMe
 		}
 	}
 
+	if flag >= 1 {
+		outX, outY = p.blendDeadReckon(tsMs, outX, outY)
+	}
+
 	// Final Watchdog on Output
 	if math.IsNaN(outX) || math.IsNaN(outY) {
+		p.emitReset(ResetNaN, tsMs)
 		p.resetFilters()
 		p.lastTS = new(int64)
 		*p.lastTS = tsMs
@@ -465,6 +1034,7 @@ func (p *FusionPipeline) Process(tsMs int64, tagID int, bleMeas []BLEMeas, twrMe
 
 	// Map-bound and kinematic watchdogs
 	if p.outOfBounds(outX, outY) {
+		p.emitReset(ResetOutOfBounds, tsMs)
 		p.resetFilters()
 		p.lastTS = new(int64)
 		*p.lastTS = tsMs
@@ -476,6 +1046,7 @@ func (p *FusionPipeline) Process(tsMs int64, tagID int, bleMeas []BLEMeas, twrMe
 			step := math.Hypot(outX-p.lastGoodPos[0], outY-p.lastGoodPos[1])
 			allowed := KinematicSpeedMax*dtg + KinematicSlack
 			if step > allowed {
+				p.emitReset(ResetKinematic, tsMs)
 				p.resetFilters()
 				p.lastTS = new(int64)
 				*p.lastTS = tsMs
@@ -486,6 +1057,7 @@ func (p *FusionPipeline) Process(tsMs int64, tagID int, bleMeas []BLEMeas, twrMe
 	if p.hasLastGood {
 		step := math.Hypot(outX-p.lastGoodPos[0], outY-p.lastGoodPos[1])
 		if step > MaxJumpPerStep {
+			p.emitReset(ResetJump, tsMs)
 			p.resetFilters()
 			p.lastTS = new(int64)
 			*p.lastTS = tsMs
@@ -499,22 +1071,117 @@ func (p *FusionPipeline) Process(tsMs int64, tagID int, bleMeas []BLEMeas, twrMe
 		p.lastGoodTs = new(int64)
 	}
 	*p.lastGoodTs = tsMs
+	if flag >= 1 {
+		p.noteFix(tsMs)
+	}
 
-	return FusionResult{
+	res := FusionResult{
 		TimestampMs: tsMs,
 		X:           outX,
 		Y:           outY,
 		Flag:        flag,
 		UsedMea:     used,
+		UsedAnchors: usedAnchorIDs(p.ekf.UsedSample()),
 		NumBeacons:  len(sample.BLE) + len(sample.TWR),
 		Algo:        algo,
 		Layer:       layerSel,
+		HDOP:        p.ekf.HDOP,
+
+		GatedUpdates:     p.ekf.GatedUpdates,
+		TruncatedUpdates: p.ekf.TruncatedUpdates,
+		PredictOnlyCount: p.ekf.PredictOnlyCount,
+		Stationary:       stationary,
+	}
+	if p.predictOnlyMax > 0 && res.PredictOnlyCount > p.predictOnlyMax {
+		// Coasting this long is no better than a diverged filter.
+		res.Flag = -3
+	}
+	if z, ok := p.ekf.Z(); ok {
+		res.Z = z
+	}
+	if res.Flag >= 1 {
+		maha := p.ekf.HMaha
+		if res.PredictOnlyCount > 0 {
+			maha = math.Inf(1)
+		}
+		res.Quality = QualityScore(len(res.UsedAnchors), p.ekf.HDOP, maha, p.ekf.MahalanobisGate, p.ekf.Pxk[0][0]+p.ekf.Pxk[1][1])
+	}
+	if p.captureSample {
+		res.Sample = sample
+	}
+	if p.residualDebug {
+		res.Residuals = p.ekf.Residuals
 	}
+	p.captureStep(res)
+	return res
+}
+
+// usedAnchorIDs lists the anchors with a TWR or BLE row in sample, each once.
+func usedAnchorIDs(sample *EKFSample) []int {
+	ids := make([]int, 0, len(sample.TWR)+len(sample.BLE))
+	seen := make(map[int]bool, cap(ids))
+	for _, t := range sample.TWR {
+		if !seen[t.AnchorID] {
+			seen[t.AnchorID] = true
+			ids = append(ids, t.AnchorID)
+		}
+	}
+	for _, b := range sample.BLE {
+		if !seen[b.AnchorID] {
+			seen[b.AnchorID] = true
+			ids = append(ids, b.AnchorID)
+		}
+	}
+	return ids
+}
+
+// IMUMotion carries the motion state an IMU frame reports alongside distance
+// and yaw. The codes are passed to the LooseFusor unchanged.
+type IMUMotion struct {
+	SpeedMps     float64
+	MotionCode   int
+	YawSigmaCode int
+	DsSigmaCode  int
+}
+
+// dropOutOfOrder reports whether a frame at tsMs is older than the last
+// processed one by more than OutOfOrderToleranceMs. Such frames are skipped
+// and counted rather than moved forward in time.
+func (p *FusionPipeline) dropOutOfOrder(tsMs int64) bool {
+	if p.lastTS == nil || tsMs >= *p.lastTS-OutOfOrderToleranceMs {
+		return false
+	}
+	p.outOfOrder++
+	return true
+}
+
+// OutOfOrderDropped returns how many frames were skipped for arriving too
+// far behind the last processed timestamp.
+func (p *FusionPipeline) OutOfOrderDropped() int64 {
+	return p.outOfOrder
+}
+
+// predictDt is the predict interval for a gap of dt seconds. Frames sharing
+// a timestamp get a zero-length predict, so consecutive updates at the same
+// instant act as one combined update.
+func predictDt(dt float64) float64 {
+	if dt <= 0 {
+		return 0
+	}
+	return math.Max(dt, 0.01)
 }
 
 // ProcessIMU advances the filter using dead-reckoning distance/yaw (degrees).
 // It performs a predict step with dt from last timestamp, then shifts position along yaw.
+// The tag is assumed to be moving; use ProcessIMUMotion when the frame
+// carries motion/speed fields.
 func (p *FusionPipeline) ProcessIMU(tsMs int64, distance float64, yawDeg float64) {
+	p.ProcessIMUMotion(tsMs, distance, yawDeg, IMUMotion{MotionCode: 1})
+}
+
+// ProcessIMUMotion is ProcessIMU with the frame's speed, motion code and
+// sigma codes forwarded to the LooseFusor.
+func (p *FusionPipeline) ProcessIMUMotion(tsMs int64, distance float64, yawDeg float64, motion IMUMotion) {
 	if p.lastTS == nil {
 		p.lastTS = new(int64)
 		*p.lastTS = tsMs
@@ -522,6 +1189,9 @@ func (p *FusionPipeline) ProcessIMU(tsMs int64, distance float64, yawDeg float64
 		*p.lastImuDist = distance
 		return
 	}
+	if p.dropOutOfOrder(tsMs) {
+		return
+	}
 	if p.lastImuDist == nil {
 		p.lastImuDist = new(float64)
 		*p.lastImuDist = distance
@@ -530,11 +1200,12 @@ func (p *FusionPipeline) ProcessIMU(tsMs int64, distance float64, yawDeg float64
 	deltaDist := distance - *p.lastImuDist
 	*p.lastImuDist = distance
 
-	if tsMs <= *p.lastTS {
-		tsMs = *p.lastTS + 1
+	if tsMs < *p.lastTS {
+		tsMs = *p.lastTS
 	}
 	dt := float64(tsMs-*p.lastTS) / 1000.0
-	if dt > 30.0 {
+	if p.gapReset(dt) {
+		p.emitReset(ResetDtGap, tsMs)
 		p.resetFilters()
 		p.lastTS = new(int64)
 		*p.lastTS = tsMs
@@ -559,18 +1230,18 @@ func (p *FusionPipeline) ProcessIMU(tsMs int64, distance float64, yawDeg float64
 	tsSec := float64(tsMs) / 1000.0
 	imuRep := loose.ImuReport{
 		YawDeg:       yawDeg,
-		SpeedMps:     0, // Not provided, derived
+		SpeedMps:     motion.SpeedMps,
 		ForwardDisM:  distance,
-		MotionCode:   1, // Assume moving if receiving IMU
-		YawSigmaCode: 0,
-		DsSigmaCode:  0,
+		MotionCode:   motion.MotionCode,
+		YawSigmaCode: motion.YawSigmaCode,
+		DsSigmaCode:  motion.DsSigmaCode,
 	}
 	p.looseFusor.IngestBatch(loose.SensorBatch{
 		Timestamp: tsSec,
 		Imu:       &imuRep,
 	})
 
-	p.ekf.Updt(math.Max(dt, 0.01))
+	p.ekf.Updt(predictDt(dt))
 	// predict state (no measurements)
 	p.ekf.xk = matVec(p.ekf.Phikk1, p.ekf.xk)
 	p.ekf.Pxk = matAdd(matMul(p.ekf.Phikk1, matMul(p.ekf.Pxk, transpose(p.ekf.Phikk1))), p.ekf.Qk)
@@ -589,6 +1260,7 @@ func (p *FusionPipeline) ProcessIMU(tsMs int64, distance float64, yawDeg float64
 
 	// Watchdog: If state covariance explodes (Sigma > 100m), reset
 	if p.ekf.Pxk[0][0] > 10000.0 || p.ekf.Pxk[1][1] > 10000.0 {
+		p.emitReset(ResetCovariance, tsMs)
 		p.resetFilters()
 		p.lastTS = new(int64)
 		*p.lastTS = tsMs
@@ -602,8 +1274,8 @@ func (p *FusionPipeline) ProcessIMU(tsMs int64, distance float64, yawDeg float64
 		vy := dy / dt
 		// clamp velocities
 		speed := math.Hypot(vx, vy)
-		if speed > MaxVel {
-			scale := MaxVel / speed
+		if maxVel := p.ekf.maxVel; speed > maxVel {
+			scale := maxVel / speed
 			vx *= scale
 			vy *= scale
 		}
@@ -615,8 +1287,9 @@ func (p *FusionPipeline) ProcessIMU(tsMs int64, distance float64, yawDeg float64
 	// IMU is relative. We need TWR/BLE to establish absolute position.
 }
 
-// computeMapBounds builds a loose bounding box around anchors/dimensions (meters) with padding.
-func computeMapBounds(anchors map[int]Anchor, dimMap map[int][]DimMat, beaconDims map[int][]DimMat) mapBounds {
+// computeMapBounds builds a loose bounding box around anchors/dimensions and
+// indoor layer extents (meters) with padding.
+func computeMapBounds(anchors map[int]Anchor, dimMap map[int][]DimMat, beaconDims map[int][]DimMat, lm *LayerManager) mapBounds {
 	minX, minY := math.Inf(1), math.Inf(1)
 	maxX, maxY := math.Inf(-1), math.Inf(-1)
 
@@ -654,6 +1327,16 @@ func computeMapBounds(anchors map[int]Anchor, dimMap map[int][]DimMat, beaconDim
 	}
 	addMats(dimMap)
 	addMats(beaconDims)
+	if lm != nil {
+		for id, lyr := range lm.layers {
+			if id == OutdoorLayer || lyr.XBR <= lyr.XTL || lyr.YBR <= lyr.YTL {
+				continue
+			}
+			// Layer extents are in centimeters.
+			push(lyr.XTL/100.0, lyr.YTL/100.0)
+			push(lyr.XBR/100.0, lyr.YBR/100.0)
+		}
+	}
 
 	if math.IsInf(minX, 1) || math.IsInf(maxX, -1) || math.IsInf(minY, 1) || math.IsInf(maxY, -1) {
 		return mapBounds{}
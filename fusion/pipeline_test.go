@@ -0,0 +1,130 @@
+package fusion
+
+import (
+	"math"
+	"testing"
+)
+
+// testPipeline returns a pipeline over n anchors on a 4 m grid, five to a
+// row, all on layer 1.
+func testPipeline(n int) (*FusionPipeline, map[int]Anchor) {
+	anchors := map[int]Anchor{}
+	for i := 0; i < n; i++ {
+		id := 0x100 + i
+		anchors[id] = Anchor{ID: id, X: float64(i%5) * 4, Y: float64(i/5) * 4, Z: 3, Layer: 1}
+	}
+	lm := NewLayerManager(map[int]*Layer{}, nil)
+	return NewFusionPipeline(anchors, NewBLERssi(2, 0, 0), map[int][]DimMat{}, map[int]int{}, map[int][]DimMat{}, lm), anchors
+}
+
+func twrRanges(anchors map[int]Anchor, x, y, z float64) []TWRMeas {
+	out := []TWRMeas{}
+	for id, a := range anchors {
+		out = append(out, TWRMeas{AnchorID: id, Range: math.Sqrt((x-a.X)*(x-a.X) + (y-a.Y)*(y-a.Y) + (z-a.Z)*(z-a.Z))})
+	}
+	return out
+}
+
+func TestProcessAsyncCallback(t *testing.T) {
+	p, anchors := testPipeline(4)
+	twin, _ := testPipeline(4)
+	calls, want := 0, 0
+	p.SetResultCallback(func(tagID int, res FusionResult) {
+		calls++
+		if tagID != 0xB50AC || res.Flag < 1 {
+			t.Errorf("callback for tag %X with flag %d", tagID, res.Flag)
+		}
+	})
+	for i := 0; i < 100; i++ {
+		ts := int64(1700000000000 + i*100)
+		var twr []TWRMeas
+		if i%10 != 9 {
+			twr = twrRanges(anchors, 5+float64(i)*0.02, 8, 1.2)
+		}
+		p.ProcessAsync(ts, 0xB50AC, nil, twr, 1.2)
+		if twin.Process(ts, 0xB50AC, nil, twr, 1.2).Flag >= 1 {
+			want++
+		}
+	}
+	// Steps without measurements carry no position and reach no callback.
+	if calls != want || calls == 0 || calls == 100 {
+		t.Errorf("%d callbacks for 100 measurements, want %d", calls, want)
+	}
+
+	// With the callback removed results are dropped, not delivered to nil.
+	p.SetResultCallback(nil)
+	p.ProcessAsync(1700000010000, 0xB50AC, nil, twrRanges(anchors, 7, 8, 1.2), 1.2)
+}
+
+// TestProcessManyAnchors feeds more TWR and BLE anchors than MaxMeaDim,
+// which used to write past BLE2Dis and panic.
+func TestProcessManyAnchors(t *testing.T) {
+	p, anchors := testPipeline(20)
+	var res FusionResult
+	for i := 0; i < 20; i++ {
+		twr := twrRanges(anchors, 6, 5, 1.2)
+		ble := []BLEMeas{}
+		for id, a := range anchors {
+			ble = append(ble, BLEMeas{AnchorID: id, RSSIDb: -45 - int(2*math.Hypot(a.X-6, a.Y-5))})
+		}
+		res = p.Process(int64(1700000000000+i*100), 0xB50AC, ble, twr, 1.2)
+	}
+	if res.Flag < 1 || res.UsedMea[0]+res.UsedMea[1] > MaxMeaDim {
+		t.Fatalf("flag %d with %v rows used, want a position from at most %d rows", res.Flag, res.UsedMea, MaxMeaDim)
+	}
+	if d := math.Hypot(res.X-6, res.Y-5); d > 0.5 {
+		t.Errorf("estimate (%.2f, %.2f) is %.2f m off", res.X, res.Y, d)
+	}
+}
+
+// TestGatedJumpResets moves the tag further than a tight gate lets through.
+// Every later frame is gated, so after more than five of them the pipeline
+// resets and relocates the tag.
+func TestGatedJumpResets(t *testing.T) {
+	p, anchors := testPipeline(10)
+	p.SetMahalanobisGate(5)
+	var reasons []string
+	p.OnReset(func(_ int, reason string, _ int64) { reasons = append(reasons, reason) })
+	ts := int64(1700000000000)
+	for i := 0; i < 30; i++ {
+		p.Process(ts, 0xB50AC, nil, twrRanges(anchors, 2, 2, 1.2), 1.2)
+		ts += 100
+	}
+	gatedBefore := p.ekf.GatedUpdates
+	var res FusionResult
+	for i := 0; i < 30; i++ {
+		res = p.Process(ts, 0xB50AC, nil, twrRanges(anchors, 14, 6, 1.2), 1.2)
+		ts += 100
+	}
+	if len(reasons) != 1 || reasons[0] != ResetDivergence {
+		t.Fatalf("resets %v, want one %q", reasons, ResetDivergence)
+	}
+	if p.ekf.GatedUpdates-gatedBefore < 6 {
+		t.Errorf("%d gated updates before the reset, want more than 5", p.ekf.GatedUpdates-gatedBefore)
+	}
+	if d := math.Hypot(res.X-14, res.Y-6); res.Flag < 1 || d > 0.5 {
+		t.Errorf("flag %d at (%.2f, %.2f), want the tag relocated to (14, 6)", res.Flag, res.X, res.Y)
+	}
+}
+
+// TestPredictOnlyLimitZeroesQuality coasts past the predict-only limit; the
+// -3 result must not carry the quality of a position fix.
+func TestPredictOnlyLimitZeroesQuality(t *testing.T) {
+	p, anchors := testPipeline(6)
+	// An indoor layer under the tag keeps coasting steps on a layer.
+	p.layerManager = NewLayerManager(map[int]*Layer{2: {ID: 2, XBR: 2000, YBR: 2000}}, nil)
+	p.SetPredictOnlyLimit(2)
+	ts := int64(1700000000000)
+	for i := 0; i < 20; i++ {
+		p.Process(ts, 0xB50AC, nil, twrRanges(anchors, 5, 3, 1.2), 1.2)
+		ts += 100
+	}
+	var res FusionResult
+	for i := 0; i < 3; i++ {
+		res = p.Process(ts, 0xB50AC, nil, nil, 1.2)
+		ts += 100
+	}
+	if res.Flag != -3 || res.Quality != 0 {
+		t.Errorf("flag %d quality %d after %d predict-only steps, want -3 and 0", res.Flag, res.Quality, res.PredictOnlyCount)
+	}
+}
@@ -0,0 +1,50 @@
+package fusion
+
+import "math"
+
+// Reference scales for QualityScore. Each term maps its input to [0, 1]:
+//
+//	count = min(n, QualityFullMeas) / QualityFullMeas
+//	hdop  = 1 / max(HDOP, 1)                 (0.5 when HDOP is unavailable)
+//	maha  = max(0, 1 - d / gate)
+//	sigma = 1 / (1 + sqrt(trace(P_xy)) / QualitySigmaRef)
+//
+// and the score is 100 * (count + hdop + maha + sigma) / 4, rounded.
+// Representative values with the default gate of 10:
+//
+//	6 anchors, HDOP 1, d 0, trace 0          -> 100
+//	4 anchors, HDOP 1.5, d 2, trace 0.25     ->  70
+//	2 anchors, HDOP 4, d 6, trace 4          ->  33
+//	no measurements, HDOP 0, d 10+, trace 25 ->  17
+const (
+	QualityFullMeas = 6
+	QualitySigmaRef = 1.0 // meters of 1-sigma position spread that halve the sigma term
+)
+
+// QualityScore condenses the usual fix diagnostics into a single 0-100
+// number: how many measurements were fused, the geometry (HDOP), how well
+// they agreed with the prediction (Mahalanobis distance d) and the
+// remaining horizontal position covariance trace (m^2). gate is the EKF's
+// Mahalanobis gate; <= 0 means MahalanobisGateDefault. Pass math.Inf(1) as
+// maha for a predict-only step, whose last distance is stale, so the maha
+// term scores 0.
+func QualityScore(nMeas int, hdop, maha, gate, posTrace float64) int {
+	count := math.Min(float64(nMeas), QualityFullMeas) / QualityFullMeas
+	if count < 0 {
+		count = 0
+	}
+	geo := 0.5
+	if hdop > 0 {
+		geo = 1 / math.Max(hdop, 1)
+	}
+	if gate <= 0 {
+		gate = MahalanobisGateDefault
+	}
+	fit := math.Max(0, 1-maha/gate)
+	sigma := 1 / (1 + math.Sqrt(math.Max(posTrace, 0))/QualitySigmaRef)
+	score := 100 * (count + geo + fit + sigma) / 4
+	if math.IsNaN(score) {
+		return 0
+	}
+	return int(math.Round(score))
+}
@@ -0,0 +1,32 @@
+package fusion
+
+import (
+	"math"
+	"testing"
+)
+
+func TestQualityScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		nMeas    int
+		hdop     float64
+		maha     float64
+		gate     float64
+		posTrace float64
+		want     int
+	}{
+		{"ideal", 6, 1, 0, 0, 0, 100},
+		{"typical", 4, 1.5, 2, 0, 0.25, 70},
+		{"poor", 2, 4, 6, 0, 4, 33},
+		{"no measurements", 0, 0, 12, 0, 25, 17},
+		{"typical, default gate", 4, 1.5, 2, MahalanobisGateDefault, 0.25, 70},
+		{"typical, gate 4", 4, 1.5, 2, 4, 0.25, 63},
+		{"predict only", 4, 1.5, math.Inf(1), 0, 0.25, 50},
+		{"NaN trace", 4, 1.5, 2, 0, math.NaN(), 0},
+	}
+	for _, tt := range tests {
+		if got := QualityScore(tt.nMeas, tt.hdop, tt.maha, tt.gate, tt.posTrace); got != tt.want {
+			t.Errorf("%s: QualityScore = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
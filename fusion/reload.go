@@ -0,0 +1,74 @@
+package fusion
+
+import "math"
+
+// ReloadConfig swaps in a new site configuration (anchors, wogi dims and
+// layer manager) while keeping the filter state, tag heights, exclusions
+// and tuning. Like the rest of the pipeline it is not safe for concurrent
+// use; callers serialise it with Process under their own lock.
+func (p *FusionPipeline) ReloadConfig(anchors map[int]Anchor, dimMap map[int][]DimMat, beaconLayer map[int]int, beaconDims map[int][]DimMat, lm *LayerManager) {
+	addShortAliases(anchors)
+	p.anchors = anchors
+	p.dimMap = dimMap
+	p.beaconLayer = beaconLayer
+	p.beaconDims = beaconDims
+	p.layerManager = lm
+	p.bounds = computeMapBounds(anchors, dimMap, beaconDims, lm)
+	p.syncEKFBounds()
+	if p.hasLastGood {
+		// Keep a tag that was legitimately outside the new bounds from
+		// being clamped on its next update.
+		p.extendBounds(p.lastGoodPos[0], p.lastGoodPos[1])
+	}
+}
+
+// addShortAliases makes short (low 16 bit) IDs resolve to their full-ID
+// anchors, unless two full IDs share the low 16 bits and an alias would
+// pick one of them arbitrarily.
+func addShortAliases(anchors map[int]Anchor) {
+	fullPerShort := map[int]int{}
+	for id := range anchors {
+		if id > 0xFFFF {
+			fullPerShort[id&0xFFFF]++
+		}
+	}
+	for id, a := range anchors {
+		short := id & 0xFFFF
+		if fullPerShort[short] > 1 {
+			continue
+		}
+		if _, ok := anchors[short]; !ok {
+			alias := a
+			alias.ID = short
+			anchors[short] = alias
+		}
+	}
+}
+
+// AnchorMoveTolerance is how far (meters) an anchor must move between two
+// configurations for DiffAnchors to report it.
+const AnchorMoveTolerance = 0.01
+
+// DiffAnchors compares two anchor maps by ID and returns, in ascending
+// order, the IDs only in next, only in prev, and in both but moved by more
+// than AnchorMoveTolerance or onto another layer.
+func DiffAnchors(prev, next map[int]Anchor) (added, removed, moved []int) {
+	for _, id := range sortedKeys(next) {
+		a := next[id]
+		old, ok := prev[id]
+		if !ok {
+			added = append(added, id)
+			continue
+		}
+		d := math.Sqrt((a.X-old.X)*(a.X-old.X) + (a.Y-old.Y)*(a.Y-old.Y) + (a.Z-old.Z)*(a.Z-old.Z))
+		if d > AnchorMoveTolerance || a.Layer != old.Layer {
+			moved = append(moved, id)
+		}
+	}
+	for _, id := range sortedKeys(prev) {
+		if _, ok := next[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed, moved
+}
@@ -0,0 +1,70 @@
+package fusion
+
+import "math"
+
+// CalibSample is one RSSI reading taken at a surveyed distance from an
+// anchor. RSSIDb may be given as signed dBm or as the engine's positive
+// strength.
+type CalibSample struct {
+	AnchorID    int
+	KnownRangeM float64
+	RSSIDb      int
+}
+
+// CalibReport is the least-squares fit of the BLE path-loss model to a set
+// of CalibSamples. Residuals are estimated minus known range in meters, in
+// sample order (NaN for samples left out of the fit).
+type CalibReport struct {
+	Factor     float64
+	AdjustRSSI float64
+	Samples    int
+	Residuals  []float64
+	RMSE       float64
+}
+
+// CalibrateFromSamples fits Factor and AdjustRSSI of the model
+// log10(range) = (rssi + adjust) / (10 * factor) by linear regression of
+// strength on log10(range), then reports the range residuals under the
+// fitted model. The receiver is not modified. Samples at or below 0 m are
+// ignored. With fewer than two distinct usable ranges the fit is
+// undetermined and the report has Factor 0 and no residuals.
+func (r *BLERssi) CalibrateFromSamples(samples []CalibSample) CalibReport {
+	rep := CalibReport{Residuals: make([]float64, len(samples))}
+	var sx, sy, sxx, sxy float64
+	for _, s := range samples {
+		if s.KnownRangeM <= 0 {
+			continue
+		}
+		x := math.Log10(s.KnownRangeM)
+		y := float64(r.StrengthFromDbm(s.RSSIDb))
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+		rep.Samples++
+	}
+	n := float64(rep.Samples)
+	den := n*sxx - sx*sx
+	if rep.Samples < 2 || den <= 0 {
+		rep.Residuals = nil
+		return rep
+	}
+	// strength = 10*factor*log10(range) - adjust
+	slope := (n*sxy - sx*sy) / den
+	icpt := (sy - slope*sx) / n
+	rep.Factor = slope / 10.0
+	rep.AdjustRSSI = -icpt
+
+	var sum float64
+	for i, s := range samples {
+		if s.KnownRangeM <= 0 {
+			rep.Residuals[i] = math.NaN()
+			continue
+		}
+		est := math.Pow(10, (float64(r.StrengthFromDbm(s.RSSIDb))+rep.AdjustRSSI)/(10.0*rep.Factor))
+		rep.Residuals[i] = est - s.KnownRangeM
+		sum += rep.Residuals[i] * rep.Residuals[i]
+	}
+	rep.RMSE = math.Sqrt(sum / n)
+	return rep
+}
@@ -0,0 +1,59 @@
+package fusion
+
+import (
+	"math"
+	"time"
+)
+
+// rssiSmoother is a per-anchor exponential filter on BLE strength. The
+// smoothing factor follows the gap between samples, so an anchor heard
+// rarely tracks its raw value more closely than one heard every cycle.
+type rssiSmoother struct {
+	tauMs   float64
+	staleMs int64
+	entries map[int]*rssiEntry
+}
+
+type rssiEntry struct {
+	value  float64
+	lastMs int64
+}
+
+func newRssiSmoother(tau, stale time.Duration) *rssiSmoother {
+	return &rssiSmoother{
+		tauMs:   float64(tau.Milliseconds()),
+		staleMs: stale.Milliseconds(),
+		entries: map[int]*rssiEntry{},
+	}
+}
+
+// filter folds a new strength sample for anchorID into its estimate and
+// returns the smoothed strength.
+func (s *rssiSmoother) filter(anchorID int, tsMs int64, strength int) int {
+	e, ok := s.entries[anchorID]
+	if !ok || tsMs-e.lastMs > s.staleMs || tsMs < e.lastMs {
+		s.entries[anchorID] = &rssiEntry{value: float64(strength), lastMs: tsMs}
+		return strength
+	}
+	dt := float64(tsMs - e.lastMs)
+	alpha := 1.0
+	if s.tauMs > 0 {
+		alpha = 1.0 - math.Exp(-dt/s.tauMs)
+	}
+	e.value += alpha * (float64(strength) - e.value)
+	e.lastMs = tsMs
+	return int(math.Round(e.value))
+}
+
+// evict drops anchors not heard for longer than the stale window.
+func (s *rssiSmoother) evict(tsMs int64) {
+	for id, e := range s.entries {
+		if tsMs-e.lastMs > s.staleMs {
+			delete(s.entries, id)
+		}
+	}
+}
+
+func (s *rssiSmoother) reset() {
+	s.entries = map[int]*rssiEntry{}
+}
@@ -0,0 +1,133 @@
+package fusion
+
+// EKFStateSnapshot is the filter state after one EKF step: the posterior
+// state Xk and covariance Pxk, and the transition Phikk1 and process noise
+// Qk that predicted this step from the previous one.
+type EKFStateSnapshot struct {
+	TimestampMs int64
+	Xk          []float64
+	Pxk         [][]float64
+	Phikk1      [][]float64
+	Qk          [][]float64
+	// Reset marks the first step after a filter reset; it is not linked to
+	// the snapshot before it.
+	Reset bool
+}
+
+// snapshot copies the EKF's current state.
+func (k *EKF) snapshot(tsMs int64) EKFStateSnapshot {
+	return EKFStateSnapshot{
+		TimestampMs: tsMs,
+		Xk:          append([]float64(nil), k.xk...),
+		Pxk:         copyMat(k.Pxk),
+		Phikk1:      copyMat(k.Phikk1),
+		Qk:          copyMat(k.Qk),
+	}
+}
+
+// RTSSmoother is a Rauch-Tung-Striebel fixed-interval smoother over a
+// recorded forward EKF pass.
+type RTSSmoother struct{}
+
+// Smooth runs the RTS backward pass over forwardStates, oldest first, and
+// returns the smoothed states. Each run between resets (or changes of state
+// dimension) is smoothed on its own; the input is not modified.
+func (RTSSmoother) Smooth(forwardStates []EKFStateSnapshot) []EKFStateSnapshot {
+	out := make([]EKFStateSnapshot, len(forwardStates))
+	if len(forwardStates) == 0 {
+		return out
+	}
+	last := len(forwardStates) - 1
+	out[last] = copySnapshot(forwardStates[last])
+	for k := last - 1; k >= 0; k-- {
+		cur, next := forwardStates[k], forwardStates[k+1]
+		out[k] = copySnapshot(cur)
+		if next.Reset || len(next.Xk) != len(cur.Xk) {
+			continue
+		}
+		// Ppred = Phi P Phi' + Q, C = P Phi' Ppred^-1
+		phiT := transpose(next.Phikk1)
+		xPred := matVec(next.Phikk1, cur.Xk)
+		pPred := matAdd(matMul(next.Phikk1, matMul(cur.Pxk, phiT)), next.Qk)
+		c := matMul(matMul(cur.Pxk, phiT), pinv(pPred))
+
+		dx := make([]float64, len(xPred))
+		for i := range dx {
+			dx[i] = out[k+1].Xk[i] - xPred[i]
+		}
+		corr := matVec(c, dx)
+		for i := range out[k].Xk {
+			out[k].Xk[i] += corr[i]
+		}
+		dP := matMul(c, matMul(matSub(out[k+1].Pxk, pPred), transpose(c)))
+		out[k].Pxk = matAdd(cur.Pxk, dP)
+	}
+	return out
+}
+
+// smootherCapture records the forward pass for GetSmoothedTrajectory.
+type smootherCapture struct {
+	enabled bool
+	states  []EKFStateSnapshot
+	results []FusionResult
+	broken  bool // a reset happened since the last snapshot
+}
+
+// EnableSmootherCapture starts recording every EKF step made by Process so
+// GetSmoothedTrajectory can smooth the track afterwards. The record grows
+// with every frame, so use it for offline processing only. Calling it again
+// discards what was recorded.
+func (p *FusionPipeline) EnableSmootherCapture() {
+	p.smoother = smootherCapture{enabled: true, broken: true}
+}
+
+// captureStep records the EKF state behind res.
+func (p *FusionPipeline) captureStep(res FusionResult) {
+	s := &p.smoother
+	if !s.enabled {
+		return
+	}
+	if res.Flag == -2 {
+		// KfUpdate reset itself
+		s.broken = true
+		return
+	}
+	snap := p.ekf.snapshot(res.TimestampMs)
+	snap.Reset = s.broken
+	s.broken = false
+	s.states = append(s.states, snap)
+	s.results = append(s.results, res)
+}
+
+// GetSmoothedTrajectory runs the RTS smoother over the steps recorded since
+// EnableSmootherCapture and returns their results with X, Y (and Z when
+// estimated) replaced by the smoothed EKF position. Results are otherwise
+// as Process returned them; frames that reset the filter are left out.
+func (p *FusionPipeline) GetSmoothedTrajectory() []FusionResult {
+	s := &p.smoother
+	smoothed := RTSSmoother{}.Smooth(s.states)
+	out := make([]FusionResult, 0, len(smoothed))
+	for i, st := range smoothed {
+		res := s.results[i]
+		res.X, res.Y = st.Xk[0], st.Xk[1]
+		if len(st.Xk) > IdxZ {
+			res.Z = st.Xk[IdxZ]
+		}
+		out = append(out, res)
+	}
+	return out
+}
+
+func copySnapshot(s EKFStateSnapshot) EKFStateSnapshot {
+	s.Xk = append([]float64(nil), s.Xk...)
+	s.Pxk = copyMat(s.Pxk)
+	return s
+}
+
+func copyMat(a [][]float64) [][]float64 {
+	m := make([][]float64, len(a))
+	for i, row := range a {
+		m[i] = append([]float64(nil), row...)
+	}
+	return m
+}
@@ -0,0 +1,27 @@
+package fusion
+
+import "math"
+
+// OutputTransform maps fused map coordinates (meters, project origin) to a
+// consumer's frame at the output boundary: rotate by RotationDeg
+// (counter-clockwise) about the project origin, multiply by Scale, then add
+// OffsetX/OffsetY (in output units). The zero value leaves coordinates
+// unchanged.
+type OutputTransform struct {
+	Scale       float64 // output units per meter; 0 means 1
+	OffsetX     float64
+	OffsetY     float64
+	RotationDeg float64
+}
+
+// Apply transforms one map point.
+func (t OutputTransform) Apply(x, y float64) (float64, float64) {
+	if t.RotationDeg != 0 {
+		sin, cos := math.Sincos(t.RotationDeg * math.Pi / 180)
+		x, y = x*cos-y*sin, x*sin+y*cos
+	}
+	if t.Scale != 0 {
+		x, y = x*t.Scale, y*t.Scale
+	}
+	return x + t.OffsetX, y + t.OffsetY
+}
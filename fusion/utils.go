@@ -11,6 +11,7 @@ type Anchor struct {
     X, Y, Z  float64
     Layer    int
     Building int
+    FullID   int64 // full device ID from project.xml; 0 when unknown
 }
 
 // BLERow mirrors one BLE measurement row (x,y,z,strength,anchorID,layer,reserved).
@@ -84,17 +85,24 @@ func RandomModel(x float64, modelType string) float64 {
     }
 }
 
-// Chi2Inv returns approximate inverse chi-square for df (1..10) at p=0.99 or 0.95.
+// Chi2Inv returns the inverse chi-square for df at p=0.99 or 0.95: exact
+// table values for df 1..10, the Wilson-Hilferty approximation above
+// (within 0.2% of the true quantile from df 11 on).
 func Chi2Inv(p float64, df int) float64 {
     table := chi2_05
+    z := 1.6448536269514722 // standard normal quantile at 0.95
     if p >= 0.97 {
         table = chi2_01
+        z = 2.3263478740408408 // at 0.99
     }
     if df < 1 {
         return table[0]
     }
-    if df > 10 {
-        return table[len(table)-1]
+    if df > len(table) {
+        k := float64(df)
+        c := 2.0 / (9.0 * k)
+        t := 1.0 - c + z*math.Sqrt(c)
+        return k * t * t * t
     }
     return table[df-1]
 }
@@ -0,0 +1,41 @@
+package fusion
+
+import (
+	"math"
+	"testing"
+)
+
+func TestChi2Inv(t *testing.T) {
+	for df := 1; df <= len(chi2_05); df++ {
+		if got := Chi2Inv(0.95, df); got != chi2_05[df-1] {
+			t.Errorf("Chi2Inv(0.95, %d) = %v, want table value %v", df, got, chi2_05[df-1])
+		}
+		if got := Chi2Inv(0.99, df); got != chi2_01[df-1] {
+			t.Errorf("Chi2Inv(0.99, %d) = %v, want table value %v", df, got, chi2_01[df-1])
+		}
+	}
+
+	// Published quantiles above the table, where Wilson-Hilferty takes over.
+	tests := []struct {
+		df       int
+		p95, p99 float64
+	}{
+		{11, 19.675, 24.725},
+		{15, 24.996, 30.578},
+		{20, 31.410, 37.566},
+		{30, 43.773, 50.892},
+		{50, 67.505, 76.154},
+	}
+	for _, tt := range tests {
+		for _, c := range []struct{ p, want float64 }{{0.95, tt.p95}, {0.99, tt.p99}} {
+			got := Chi2Inv(c.p, tt.df)
+			if math.Abs(got-c.want)/c.want > 0.002 {
+				t.Errorf("Chi2Inv(%v, %d) = %.3f, want %.3f within 0.2%%", c.p, tt.df, got, c.want)
+			}
+		}
+	}
+
+	if got := Chi2Inv(0.95, 0); got != chi2_05[0] {
+		t.Errorf("Chi2Inv(0.95, 0) = %v, want the df 1 value", got)
+	}
+}
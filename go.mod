@@ -7,6 +7,8 @@ toolchain go1.24.11
 require github.com/gorilla/websocket v1.5.1
 
 require (
-	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/net v0.17.0
 	gonum.org/v1/gonum v0.16.0 // indirect
 )
+
+require golang.org/x/sys v0.13.0 // indirect
@@ -0,0 +1,138 @@
+// Package logging is a minimal leveled logger shared by the server, rbc
+// and web packages, which log through the package-level functions. The
+// default logger writes through the standard log package and drops Debug
+// messages; SetLogger replaces it.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level orders log messages by severity.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// ParseLevel accepts debug, info, warn (or warning) and error, in any case.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// Logger is the interface the packages log through. Implementations must
+// be safe for concurrent use.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+// Std is a Logger backed by a standard library *log.Logger. Messages below
+// Min are dropped; the rest are prefixed with their level.
+type Std struct {
+	Out *log.Logger
+	Min Level
+}
+
+// NewStd returns a Std writing to out, or to the standard logger when out
+// is nil.
+func NewStd(out *log.Logger, min Level) *Std {
+	if out == nil {
+		out = log.Default()
+	}
+	return &Std{Out: out, Min: min}
+}
+
+// Default returns a Std on the standard logger at LevelInfo.
+func Default() *Std {
+	return NewStd(nil, LevelInfo)
+}
+
+// logf logs at level l; depth is the number of frames between logf and
+// the caller to report, for Lshortfile.
+func (s *Std) logf(depth int, l Level, format string, args []any) {
+	if l < s.Min {
+		return
+	}
+	s.Out.Output(depth+1, l.String()+" "+fmt.Sprintf(format, args...))
+}
+
+func (s *Std) Debugf(format string, args ...any) { s.logf(2, LevelDebug, format, args) }
+func (s *Std) Infof(format string, args ...any)  { s.logf(2, LevelInfo, format, args) }
+func (s *Std) Warnf(format string, args ...any)  { s.logf(2, LevelWarn, format, args) }
+func (s *Std) Errorf(format string, args ...any) { s.logf(2, LevelError, format, args) }
+
+type holder struct{ l Logger }
+
+var current atomic.Pointer[holder]
+
+func init() {
+	current.Store(&holder{Default()})
+}
+
+// SetLogger routes the log output of every package logging through this
+// one to l; a nil l restores the default.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = Default()
+	}
+	current.Store(&holder{l})
+}
+
+// logf logs through the current Logger. A Std is called directly so that
+// Lshortfile still points at the caller of the package-level function.
+func logf(l Level, format string, args []any) {
+	lg := current.Load().l
+	if std, ok := lg.(*Std); ok {
+		std.logf(3, l, format, args)
+		return
+	}
+	switch l {
+	case LevelDebug:
+		lg.Debugf(format, args...)
+	case LevelInfo:
+		lg.Infof(format, args...)
+	case LevelWarn:
+		lg.Warnf(format, args...)
+	default:
+		lg.Errorf(format, args...)
+	}
+}
+
+// Debugf, Infof, Warnf and Errorf log through the Logger set by SetLogger.
+func Debugf(format string, args ...any) { logf(LevelDebug, format, args) }
+func Infof(format string, args ...any)  { logf(LevelInfo, format, args) }
+func Warnf(format string, args ...any)  { logf(LevelWarn, format, args) }
+func Errorf(format string, args ...any) { logf(LevelError, format, args) }
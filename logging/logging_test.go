@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+type recorder struct{ lines []string }
+
+func (r *recorder) Debugf(format string, args ...any) { r.lines = append(r.lines, "D "+format) }
+func (r *recorder) Infof(format string, args ...any)  { r.lines = append(r.lines, "I "+format) }
+func (r *recorder) Warnf(format string, args ...any)  { r.lines = append(r.lines, "W "+format) }
+func (r *recorder) Errorf(format string, args ...any) { r.lines = append(r.lines, "E "+format) }
+
+func TestSetLogger(t *testing.T) {
+	defer SetLogger(nil)
+
+	var buf bytes.Buffer
+	SetLogger(NewStd(log.New(&buf, "", log.Lshortfile), LevelInfo))
+	Debugf("hidden")
+	Warnf("disk %d%% full", 95)
+	if got := buf.String(); !strings.HasPrefix(got, "logging_test.go:") || !strings.Contains(got, "WARN disk 95% full") || strings.Contains(got, "hidden") {
+		t.Errorf("logged %q, want only the warning, attributed to the caller", got)
+	}
+
+	r := &recorder{}
+	SetLogger(r)
+	Debugf("a")
+	Infof("b")
+	Errorf("c")
+	if got := strings.Join(r.lines, ","); got != "D a,I b,E c" {
+		t.Errorf("custom logger got %q", got)
+	}
+}
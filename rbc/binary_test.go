@@ -0,0 +1,92 @@
+package rbc
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTagPosBinaryRoundTrip(t *testing.T) {
+	tests := []TagPosMsg{
+		{ID: 0xB50AC, Ts: 1700000000123, Region: 3, X: 12.5, Y: -3.25, Z: 1.2, Flag: 2},
+		{ID: 0x7FFFFFFFFFFFFFFF, Ts: 0, Region: 0xFFFF, X: 0, Y: 0, Z: 0, Flag: 255},
+		{ID: 1, Ts: -1, Region: 0, X: -1234.5678, Y: 9876.54321, Z: -0.001, Flag: 1},
+	}
+	for _, want := range tests {
+		b := FormatTagPosBinary(want.ID, want.Ts, want.Flag, want.Region, want.X, want.Y, want.Z)
+		if len(b) != BinaryTagPosLen {
+			t.Fatalf("encoded %d bytes, want %d", len(b), BinaryTagPosLen)
+		}
+		if b[0] != 0x42 || b[1] != 0x52 {
+			t.Errorf("magic bytes % X, want 42 52", b[:2])
+		}
+		got, err := ParseTagPosBinary(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != want.ID || got.Ts != want.Ts || got.Region != want.Region || got.Flag != want.Flag {
+			t.Errorf("decoded %+v, want %+v", got, want)
+		}
+		for _, c := range [][2]float64{{got.X, want.X}, {got.Y, want.Y}, {got.Z, want.Z}} {
+			if c[0] != float64(float32(c[1])) {
+				t.Errorf("coordinate %v, want %v at float32 precision", c[0], c[1])
+			}
+		}
+	}
+}
+
+func TestParseTagPosBinaryRejects(t *testing.T) {
+	b := FormatTagPosBinary(1, 2, 1, 3, 4, 5, 6)
+	if _, err := ParseTagPosBinary(b[:BinaryTagPosLen-1]); err == nil {
+		t.Error("short message accepted")
+	}
+	b[0] ^= 0xFF
+	if _, err := ParseTagPosBinary(b); err == nil {
+		t.Error("bad magic accepted")
+	}
+}
+
+func TestSenderBinaryMode(t *testing.T) {
+	ln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("no loopback UDP: %v", err)
+	}
+	defer ln.Close()
+
+	s := NewSender()
+	if err := s.AddUDPSender(ln.LocalAddr().String(), FlagPosition); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	want := TagPosMsg{ID: 0xB50AC, Ts: 1700000000123, Region: 2, X: 1.5, Y: 2.5, Flag: 2}
+	recv := func() []byte {
+		t.Helper()
+		buf := make([]byte, 256)
+		ln.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := ln.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf[:n]
+	}
+
+	s.SendTagPos(want)
+	if text := string(recv()); text != string(FormatTagPos(want.ID, want.Ts, 0, want.Region, want.X, want.Y, want.Z)) {
+		t.Errorf("text mode sent %q", text)
+	}
+
+	s.SetBinaryMode(true)
+	s.SendTagPos(want)
+	got, err := ParseTagPosBinary(recv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != want.ID || got.Flag != want.Flag || math.Abs(got.X-want.X) > 1e-6 {
+		t.Errorf("binary mode sent %+v, want %+v", got, want)
+	}
+}
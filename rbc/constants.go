@@ -16,3 +16,12 @@ const (
 	FlagWarningSize  = 0x2000
 	FlagSummarySize  = 0x4000
 )
+
+// Warning codes used with FormatWarning.
+const (
+	WarnFilterReset     = 1
+	WarnForbiddenRegion = 2
+	WarnRegionEnter     = 3
+	WarnRegionExit      = 4
+	WarnTagLost         = 5
+)
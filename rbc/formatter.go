@@ -2,12 +2,67 @@ package rbc
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Default RBC message header. The last three characters of the header are
+// the placeholder the decimal message length is written into.
+const (
+	DefaultHeader    = "display:   "
+	DefaultDelimiter = ","
+)
+
+// MaxMessageLen is the longest message the 3-digit length field can
+// describe; longer messages are cut to it.
+const MaxMessageLen = 999
+
+// Timestamp layouts for Formatter.TimestampLayout. Any other value is used
+// as a time.Format layout in local time.
+const (
+	DefaultTimestampLayout = "20060102150405.000"
+	TimestampUnixMs        = "unixms" // decimal Unix milliseconds
+)
+
+// Formatter builds RBC messages with a configurable header keyword and
+// timestamp layout.
+type Formatter struct {
+	header string
+	delim  string
+
+	// TimestampLayout formats message times; empty means
+	// DefaultTimestampLayout. Some receivers want "2006-01-02 15:04:05.000"
+	// or TimestampUnixMs.
+	TimestampLayout string
+}
+
+// DefaultFormatter formats the "display:   ," messages of the package-level
+// Format* functions, with DefaultTimestampLayout times.
+var DefaultFormatter = &Formatter{header: DefaultHeader, delim: DefaultDelimiter}
+
+// NewFormatter returns a formatter whose messages start with header+delim.
+// The length field is written into the last three bytes of header, so the
+// header must be at least 3 characters. An empty delim means ",".
+func NewFormatter(header, delim string) (*Formatter, error) {
+	if len(header) < 3 {
+		return nil, fmt.Errorf("rbc header %q too short: need room for the 3-digit length field", header)
+	}
+	if delim == "" {
+		delim = DefaultDelimiter
+	}
+	return &Formatter{header: header, delim: delim}, nil
+}
+
 // FormatTagPos formats a position message for RBC.
 // Matches RBCRmtPkgTagPos in RBCWrap.cpp
 func FormatTagPos(id int, ts int64, seq uint16, region int, x, y, z float64) []byte {
+	return DefaultFormatter.FormatTagPos(id, ts, seq, region, x, y, z)
+}
+
+// FormatTagPos is the package-level FormatTagPos with this formatter's
+// header and timestamp layout.
+func (f *Formatter) FormatTagPos(id int, ts int64, seq uint16, region int, x, y, z float64) []byte {
 	// Header: "display:   ,"
 	// ID: 16 hex chars (or less depending on config, but standard is 16)
 	// Seq: uint16
@@ -23,15 +78,14 @@ func FormatTagPos(id int, ts int64, seq uint16, region int, x, y, z float64) []b
 	// But in RBCWrap.cpp: "display:   ," + ID + "," + Seq + "," + Time + "," + Rgn + "," + X + "," + Y + "," + Z
 	
 	// Let's assume a standard format for now: YYYYMMDDHHmmssSSS
-	t := time.UnixMilli(ts)
-	timeStr := t.Format("20060102150405.000")
+	timeStr := f.timestamp(ts)
 	
 	// C++ ID formatting: %0*PRIX64
 	idStr := fmt.Sprintf("%016X", id)
 	
 	// Body
-	body := fmt.Sprintf("display:   ,%s,%d,%s,%d,%.2f,%.2f,%.2f\r\n", 
-		idStr, seq, timeStr, region, x, y, z)
+	body := fmt.Sprintf("%s%s%s,%d,%s,%d,%.2f,%.2f,%.2f\r\n",
+		f.header, f.delim, idStr, seq, timeStr, region, x, y, z)
 		
 	// RBC Protocol often has a length field at bytes 8-10 if header is "display:   ," (11 chars).
 	// The C++ code `RBCFillLengthField` writes length to buf[8], buf[9], buf[10].
@@ -40,15 +94,98 @@ func FormatTagPos(id int, ts int64, seq uint16, region int, x, y, z float64) []b
 	// d i s p l a y :   ,
 	// It overwrites spaces at 8,9,10 with length digits?
 	// C++: buf[9]=0x30+((nLen/10)%10); buf[10]=0x30+(nLen%10); if(nLen>=100) buf[8]=0x30+(nLen/100);
-	// Yes.
+	// Yes. For other headers the field is the last three bytes of the header.
 	
-	b := []byte(body)
+	return f.fillLength([]byte(body))
+}
+
+// FormatTagPosQuality is FormatTagPos with the fix quality score (0-100)
+// appended as an extra trailing field. Receivers built against the plain
+// RBCRmtPkgTagPos layout must opt in to it.
+func FormatTagPosQuality(id int, ts int64, seq uint16, region int, x, y, z float64, quality int) []byte {
+	return DefaultFormatter.FormatTagPosQuality(id, ts, seq, region, x, y, z, quality)
+}
+
+// FormatTagPosQuality is the package-level FormatTagPosQuality with this
+// formatter's header and timestamp layout.
+func (f *Formatter) FormatTagPosQuality(id int, ts int64, seq uint16, region int, x, y, z float64, quality int) []byte {
+	timeStr := f.timestamp(ts)
+	body := fmt.Sprintf("%s%s%016X,%d,%s,%d,%.2f,%.2f,%.2f,%d\r\n",
+		f.header, f.delim, id, seq, timeStr, region, x, y, z, quality)
+	return f.fillLength([]byte(body))
+}
+
+// FormatWarning formats an alarm message for RBC (sent with FlagWarning).
+// Commas and line breaks in msg are replaced so the record stays one CSV line,
+// and msg is cut short if the message would exceed MaxMessageLen.
+func FormatWarning(id int, ts int64, code int, msg string) []byte {
+	return DefaultFormatter.FormatWarning(id, ts, code, msg)
+}
+
+// FormatWarning is the package-level FormatWarning with this formatter's
+// header and timestamp layout.
+func (f *Formatter) FormatWarning(id int, ts int64, code int, msg string) []byte {
+	timeStr := f.timestamp(ts)
+	msg = strings.NewReplacer(",", " ", "\r", " ", "\n", " ").Replace(msg)
+	body := fmt.Sprintf("%s%s%016X,%s,%d,%s\r\n", f.header, f.delim, id, timeStr, code, msg)
+	return f.fillLength([]byte(body))
+}
+
+// FormatPosWarning formats a position-bound alarm (e.g. geofence entry) for
+// RBC, sent with FlagWarning. The warning code takes the place of the
+// sequence number of FormatTagPos.
+func FormatPosWarning(tagID int, ts int64, warnCode int, region int, x, y float64) []byte {
+	return DefaultFormatter.FormatPosWarning(tagID, ts, warnCode, region, x, y)
+}
+
+// FormatPosWarning is the package-level FormatPosWarning with this
+// formatter's header and timestamp layout.
+func (f *Formatter) FormatPosWarning(tagID int, ts int64, warnCode int, region int, x, y float64) []byte {
+	timeStr := f.timestamp(ts)
+	body := fmt.Sprintf("%s%s%016X,%d,%s,%d,%.2f,%.2f\r\n", f.header, f.delim, tagID, warnCode, timeStr, region, x, y)
+	return f.fillLength([]byte(body))
+}
+
+// FormatSummary formats a periodic tag count summary for RBC (sent with
+// FlagSummary).
+func FormatSummary(totalTags, activeTags int, ts int64) []byte {
+	return DefaultFormatter.FormatSummary(totalTags, activeTags, ts)
+}
+
+// FormatSummary is the package-level FormatSummary with this formatter's
+// header and timestamp layout.
+func (f *Formatter) FormatSummary(totalTags, activeTags int, ts int64) []byte {
+	timeStr := f.timestamp(ts)
+	body := fmt.Sprintf("%s%s%s,%d,%d\r\n", f.header, f.delim, timeStr, totalTags, activeTags)
+	return f.fillLength([]byte(body))
+}
+
+// timestamp renders ts (Unix ms) with the formatter's TimestampLayout.
+func (f *Formatter) timestamp(ts int64) string {
+	switch f.TimestampLayout {
+	case "":
+		return time.UnixMilli(ts).Format(DefaultTimestampLayout)
+	case TimestampUnixMs:
+		return strconv.FormatInt(ts, 10)
+	}
+	return time.UnixMilli(ts).Format(f.TimestampLayout)
+}
+
+// fillLength writes the message length into the last three bytes of the
+// header, as RBCFillLengthField does in C++ for "display:   ,":
+// buf[9]=0x30+((nLen/10)%10); buf[10]=0x30+(nLen%10); if(nLen>=100) buf[8]=0x30+(nLen/100);
+// A message longer than MaxMessageLen is cut to it, keeping the
+// trailing "\r\n".
+func (f *Formatter) fillLength(b []byte) []byte {
+	off := len(f.header) - 3
+	if len(b) > MaxMessageLen {
+		b = append(b[:MaxMessageLen-2], '\r', '\n')
+	}
 	nLen := len(b)
 	if nLen >= 100 {
-		b[8] = byte('0' + (nLen / 100))
+		b[off] = byte('0' + (nLen / 100))
 	}
-	b[9] = byte('0' + ((nLen / 10) % 10))
-	b[10] = byte('0' + (nLen % 10))
-	
+	b[off+1] = byte('0' + ((nLen / 10) % 10))
+	b[off+2] = byte('0' + (nLen % 10))
 	return b
 }
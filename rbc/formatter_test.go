@@ -0,0 +1,85 @@
+package rbc
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lengthField returns the decimal length written into the last three bytes
+// of header at the start of msg.
+func lengthField(t *testing.T, header string, msg []byte) int {
+	t.Helper()
+	off := len(header) - 3
+	n, err := strconv.Atoi(strings.TrimSpace(string(msg[off : off+3])))
+	if err != nil {
+		t.Fatalf("length field %q: %v", msg[off:off+3], err)
+	}
+	return n
+}
+
+func TestLengthField(t *testing.T) {
+	const ts = 1700000000123
+	custom, err := NewFormatter("POSITION:   ", ";")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		header string
+		msg    []byte
+	}{
+		{"tag pos", DefaultHeader, FormatTagPos(0xB50AC, ts, 7, 1, 12.5, -3.25, 0)},
+		{"tag pos quality", DefaultHeader, FormatTagPosQuality(0xB50AC, ts, 7, 1, 12.5, -3.25, 0, 88)},
+		{"pos warning", DefaultHeader, FormatPosWarning(0xB50AC, ts, WarnForbiddenRegion, 1, 1, 2)},
+		{"summary", DefaultHeader, FormatSummary(120, 87, ts)},
+		{"short warning", DefaultHeader, FormatWarning(0xB50AC, ts, WarnFilterReset, "filter reset")},
+		{"100+ bytes", DefaultHeader, FormatWarning(0xB50AC, ts, WarnFilterReset, strings.Repeat("x", 80))},
+		{"900+ bytes", DefaultHeader, FormatWarning(0xB50AC, ts, WarnFilterReset, strings.Repeat("x", 900))},
+		{"custom header", custom.header, custom.FormatWarning(0xB50AC, ts, WarnFilterReset, strings.Repeat("y", 200))},
+	}
+	for _, tt := range tests {
+		if got := lengthField(t, tt.header, tt.msg); got != len(tt.msg) {
+			t.Errorf("%s: length field %d, message is %d bytes", tt.name, got, len(tt.msg))
+		}
+		if !bytes.HasSuffix(tt.msg, []byte("\r\n")) {
+			t.Errorf("%s: message %q does not end in CRLF", tt.name, tt.msg)
+		}
+	}
+}
+
+func TestLengthFieldOverflow(t *testing.T) {
+	for _, n := range []int{MaxMessageLen - 60, MaxMessageLen, 5000} {
+		msg := FormatWarning(0xB50AC, 1700000000123, WarnFilterReset, strings.Repeat("z", n))
+		if len(msg) > MaxMessageLen {
+			t.Errorf("msg of %d: %d-byte message", n, len(msg))
+		}
+		if got := lengthField(t, DefaultHeader, msg); got != len(msg) {
+			t.Errorf("msg of %d: length field %d, message is %d bytes", n, got, len(msg))
+		}
+		if !bytes.HasSuffix(msg, []byte("\r\n")) {
+			t.Errorf("msg of %d: cut message does not end in CRLF", n)
+		}
+	}
+}
+
+func TestFormatPosWarningRoundTrip(t *testing.T) {
+	msg := FormatPosWarning(0xB50AC, 1700000000123, WarnForbiddenRegion, 3, 12.5, -3.25)
+	if got := lengthField(t, DefaultHeader, msg); got != len(msg) {
+		t.Fatalf("length field %d, message is %d bytes", got, len(msg))
+	}
+	fields := strings.Split(strings.TrimSuffix(string(msg), "\r\n"), ",")
+	want := []string{fields[0], "00000000000B50AC", "2", DefaultFormatter.timestamp(1700000000123), "3", "12.50", "-3.25"}
+	if len(fields) != len(want) {
+		t.Fatalf("fields %q, want %q", fields, want)
+	}
+	if !strings.HasPrefix(fields[0], "display:") {
+		t.Errorf("header %q", fields[0])
+	}
+	for i := 1; i < len(want); i++ {
+		if fields[i] != want[i] {
+			t.Errorf("field %d: %q, want %q", i, fields[i], want[i])
+		}
+	}
+}
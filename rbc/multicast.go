@@ -0,0 +1,83 @@
+package rbc
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"engine-go/logging"
+)
+
+// DefaultMulticastTTL keeps multicast RBC traffic on the local segment.
+const DefaultMulticastTTL = 1
+
+// AddMulticastUDPSender sends messages matching flag to the multicast group
+// groupAddr (host:port) from a socket of its own on the named interface
+// (the system default when iface is empty). The TTL is the sender's
+// multicast TTL, DefaultMulticastTTL unless changed with SetMulticastTTL.
+func (s *Sender) AddMulticastUDPSender(groupAddr string, iface string, flag uint32) error {
+	gaddr, err := net.ResolveUDPAddr("udp", groupAddr)
+	if err != nil {
+		return err
+	}
+	if !gaddr.IP.IsMulticast() {
+		return fmt.Errorf("%s is not a multicast address", gaddr.IP)
+	}
+	var ifi *net.Interface
+	if iface != "" {
+		if ifi, err = net.InterfaceByName(iface); err != nil {
+			return err
+		}
+	}
+	network := "udp4"
+	if gaddr.IP.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return err
+	}
+	t := &UdpTarget{addr: gaddr, flag: flag, conn: conn}
+	if ifi != nil {
+		if err := setMulticastInterface(t, ifi); err != nil {
+			conn.Close()
+			return fmt.Errorf("multicast interface %s: %w", iface, err)
+		}
+	}
+	if err := setMulticastTTL(t, s.mcastTTL); err != nil {
+		conn.Close()
+		return fmt.Errorf("multicast ttl: %w", err)
+	}
+	s.udpTargets = append(s.udpTargets, t)
+	return nil
+}
+
+// SetMulticastTTL changes the TTL (hop limit) of multicast targets, both
+// existing and added later.
+func (s *Sender) SetMulticastTTL(ttl int) {
+	s.mcastTTL = ttl
+	for _, t := range s.udpTargets {
+		if t.conn == nil {
+			continue
+		}
+		if err := setMulticastTTL(t, ttl); err != nil {
+			logging.Warnf("Setting multicast TTL for %s failed: %v", t.addr, err)
+		}
+	}
+}
+
+func setMulticastTTL(t *UdpTarget, ttl int) error {
+	if t.addr.IP.To4() != nil {
+		return ipv4.NewPacketConn(t.conn).SetMulticastTTL(ttl)
+	}
+	return ipv6.NewPacketConn(t.conn).SetMulticastHopLimit(ttl)
+}
+
+func setMulticastInterface(t *UdpTarget, ifi *net.Interface) error {
+	if t.addr.IP.To4() != nil {
+		return ipv4.NewPacketConn(t.conn).SetMulticastInterface(ifi)
+	}
+	return ipv6.NewPacketConn(t.conn).SetMulticastInterface(ifi)
+}
@@ -1,10 +1,12 @@
 package rbc
 
 import (
-	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"engine-go/logging"
 )
 
 type Message struct {
@@ -15,6 +17,12 @@ type Message struct {
 type UdpTarget struct {
 	addr *net.UDPAddr
 	flag uint32
+	// conn is the target's own socket (multicast targets); nil means the
+	// sender's shared socket.
+	conn *net.UDPConn
+
+	Sent   atomic.Uint64
+	Errors atomic.Uint64
 }
 
 type TcpClient struct {
@@ -23,6 +31,26 @@ type TcpClient struct {
 	queue   chan *Message
 	running bool
 	wg      sync.WaitGroup
+
+	// Sent counts messages written to the socket; Dropped counts messages
+	// discarded because the queue was full or the target was unreachable.
+	Sent    atomic.Uint64
+	Dropped atomic.Uint64
+}
+
+// QueueDepth is the approximate number of messages waiting to be sent.
+func (c *TcpClient) QueueDepth() int {
+	return len(c.queue)
+}
+
+// SenderStats is a snapshot of one RBC target's counters. UDP targets have
+// no queue, so QueueDepth and Dropped stay zero for them.
+type SenderStats struct {
+	Proto      string `json:"proto"` // "tcp" or "udp"
+	Sent       uint64 `json:"sent"`
+	Dropped    uint64 `json:"dropped"`
+	Errors     uint64 `json:"errors"`
+	QueueDepth int    `json:"queue_depth"`
 }
 
 type Sender struct {
@@ -31,13 +59,39 @@ type Sender struct {
 	connUDP    *net.UDPConn
 	header     []byte
 	running    bool
+
+	// TTL for multicast targets (see SetMulticastTTL)
+	mcastTTL int
+
+	// Formatter of position and warning messages (see SetFormatter)
+	formatter *Formatter
+
+	// Position messages as BinaryTagPos (see SetBinaryMode)
+	binary bool
 }
 
 func NewSender() *Sender {
 	return &Sender{
 		udpTargets: make([]*UdpTarget, 0),
 		tcpClients: make([]*TcpClient, 0),
+		mcastTTL:   DefaultMulticastTTL,
+		formatter:  DefaultFormatter,
+	}
+}
+
+// SetFormatter sets the Formatter used for the sender's position messages
+// (see SendTagPos); nil restores DefaultFormatter. Callers building other
+// messages should use Formatter so they share its header and timestamps.
+func (s *Sender) SetFormatter(f *Formatter) {
+	if f == nil {
+		f = DefaultFormatter
 	}
+	s.formatter = f
+}
+
+// Formatter returns the sender's Formatter.
+func (s *Sender) Formatter() *Formatter {
+	return s.formatter
 }
 
 func (s *Sender) SetHeader(hdr string) {
@@ -66,6 +120,18 @@ func (s *Sender) AddTCPSender(addr string, flag uint32) {
 	s.tcpClients = append(s.tcpClients, client)
 }
 
+// Stats returns the counters of every target keyed by its address.
+func (s *Sender) Stats() map[string]SenderStats {
+	out := make(map[string]SenderStats, len(s.udpTargets)+len(s.tcpClients))
+	for _, t := range s.udpTargets {
+		out[t.addr.String()] = SenderStats{Proto: "udp", Sent: t.Sent.Load(), Errors: t.Errors.Load()}
+	}
+	for _, c := range s.tcpClients {
+		out[c.addr] = SenderStats{Proto: "tcp", Sent: c.Sent.Load(), Dropped: c.Dropped.Load(), QueueDepth: c.QueueDepth()}
+	}
+	return out
+}
+
 func (s *Sender) Start() error {
 	conn, err := net.ListenUDP("udp", nil)
 	if err != nil {
@@ -85,6 +151,11 @@ func (s *Sender) Stop() {
 	if s.connUDP != nil {
 		s.connUDP.Close()
 	}
+	for _, t := range s.udpTargets {
+		if t.conn != nil {
+			t.conn.Close()
+		}
+	}
 	for _, c := range s.tcpClients {
 		c.Stop()
 	}
@@ -109,9 +180,16 @@ func (s *Sender) Send(data []byte, flag uint32) {
 	// UDP
 	for _, t := range s.udpTargets {
 		if (t.flag & flag) == flag {
-			_, err := s.connUDP.WriteToUDP(msgData, t.addr)
+			conn := s.connUDP
+			if t.conn != nil {
+				conn = t.conn
+			}
+			_, err := conn.WriteToUDP(msgData, t.addr)
 			if err != nil {
-				// log.Printf("UDP send error: %v", err)
+				// logging.Debugf("UDP send error: %v", err)
+				t.Errors.Add(1)
+			} else {
+				t.Sent.Add(1)
 			}
 		}
 	}
@@ -123,6 +201,7 @@ func (s *Sender) Send(data []byte, flag uint32) {
 			case c.queue <- msg:
 			default:
 				// Drop if full
+				c.Dropped.Add(1)
 			}
 		}
 	}
@@ -167,6 +246,7 @@ func (c *TcpClient) loop() {
 			// If we block here, we block the queue.
 			time.Sleep(500 * time.Millisecond)
 			if !connect() {
+				c.Dropped.Add(1)
 				continue // drop this message
 			}
 		}
@@ -174,10 +254,13 @@ func (c *TcpClient) loop() {
 		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
 		_, err = conn.Write(msg.Data)
 		if err != nil {
-			log.Printf("TCP write to %s failed: %v", c.addr, err)
+			logging.Warnf("TCP write to %s failed: %v", c.addr, err)
 			conn.Close()
 			conn = nil
 			time.Sleep(100 * time.Millisecond)
+			c.Dropped.Add(1)
+		} else {
+			c.Sent.Add(1)
 		}
 	}
 	if conn != nil {
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+)
+
+// AnchorStatus is the latest heartbeat of one anchor.
+type AnchorStatus struct {
+	ID          string `json:"id"` // hex
+	RSSI        int    `json:"rssi"`
+	BatteryMV   int    `json:"battery_mv"`
+	Temperature int    `json:"temperature_c"`
+	LastSeenMs  int64  `json:"last_seen_ms"`
+}
+
+// SendAnchorStatus stores hb, received at ts (ms), as the anchor's current
+// status for AnchorStatuses.
+func (s *UdpServer) SendAnchorStatus(hb *AnchorHeartbeat, ts int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.anchorStatus == nil {
+		s.anchorStatus = map[int]*AnchorStatus{}
+	}
+	s.anchorStatus[int(hb.AnchorID)] = &AnchorStatus{
+		ID:          fmt.Sprintf("%X", hb.AnchorID),
+		RSSI:        int(hb.RSSI),
+		BatteryMV:   int(hb.BatteryMV),
+		Temperature: int(hb.Temperature),
+		LastSeenMs:  ts,
+	}
+}
+
+// AnchorStatuses lists the latest heartbeat of every anchor heard, by ID.
+func (s *UdpServer) AnchorStatuses() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]int, 0, len(s.anchorStatus))
+	for id := range s.anchorStatus {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	out := make([]AnchorStatus, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, *s.anchorStatus[id])
+	}
+	return out
+}
@@ -0,0 +1,489 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"engine-go/fusion"
+	"engine-go/logging"
+)
+
+// Position is a tag's latest fused state, as pushed to web clients.
+type Position = wsPos
+
+// Engine runs one fusion pipeline per tag without any transport: callers
+// feed measurements they have already decoded and read results back from
+// the Feed* return values, the OnResult callback or Positions. UdpServer is
+// an adapter that decodes UNIB packets into Engine calls.
+type Engine struct {
+	// cfg is held shared by every update and exclusively while settings or
+	// site configuration shared by the pipelines change, so different tags
+	// update in parallel but never see a half-applied change.
+	cfg sync.RWMutex
+	// mu guards the per-tag maps; taken after cfg and a tag's lock.
+	mu sync.Mutex
+
+	// Map TagID -> dedicated fusion pipeline (stateful)
+	pipelines map[int]*tagPipeline
+	// Map TagID -> Last Known Position
+	states map[int]*wsPos
+	// Map TagID -> mounting height (m)
+	tagHeights map[int]float64
+	// Height for tags without one (0 = fusion.DefaultTagHeight)
+	defaultTagHeight float64
+	// Map TagID -> kinematic limits, for tags that differ from the default
+	motionProfiles map[int]fusion.MotionProfile
+	// BLE strength smoothing applied to new pipelines (0 = off)
+	rssiTau time.Duration
+	// TWR range gate for new pipelines (0 = fusion.MaxTwrRange)
+	maxRange float64
+	// Anchors taken out of service on every pipeline
+	excluded map[int]bool
+	// Unscented measurement update on every pipeline
+	ukf bool
+	// IMU-only output after this long without anchors (0 = off)
+	deadReckonGap time.Duration
+	// Filter reset gap (0 = fusion.ResetGap) and whether longer gaps
+	// predict instead
+	resetGap   time.Duration
+	predictGap bool
+	// Map TagID -> wall-clock time of the last fusion result
+	lastSeen map[int]time.Time
+
+	onResult func(tagID int, ts int64, res fusion.FusionResult)
+
+	// Shared configuration for constructing pipelines
+	anchors      map[int]fusion.Anchor
+	rssiModel    *fusion.BLERssi
+	dimMap       map[int][]fusion.DimMat
+	beaconLayer  map[int]int
+	beaconDims   map[int][]fusion.DimMat
+	layerManager *fusion.LayerManager
+}
+
+// tagPipeline is one tag's pipeline and the lock serializing its updates.
+type tagPipeline struct {
+	mu sync.Mutex
+	p  *fusion.FusionPipeline
+}
+
+// NewEngine builds an engine over the given site configuration. The anchor
+// map is copied; later anchors are added with AddAnchor.
+func NewEngine(anchors map[int]fusion.Anchor, rssi *fusion.BLERssi, dimMap map[int][]fusion.DimMat, beaconLayer map[int]int, beaconDims map[int][]fusion.DimMat, lm *fusion.LayerManager) *Engine {
+	anchCopy := make(map[int]fusion.Anchor, len(anchors))
+	for k, v := range anchors {
+		anchCopy[k] = v
+	}
+	return &Engine{
+		pipelines:      make(map[int]*tagPipeline),
+		states:         make(map[int]*wsPos),
+		tagHeights:     make(map[int]float64),
+		motionProfiles: make(map[int]fusion.MotionProfile),
+		excluded:       make(map[int]bool),
+		lastSeen:       make(map[int]time.Time),
+		anchors:        anchCopy,
+		rssiModel:      rssi,
+		dimMap:         dimMap,
+		beaconLayer:    beaconLayer,
+		beaconDims:     beaconDims,
+		layerManager:   lm,
+	}
+}
+
+// OnResult registers a callback fired after every TWR or BLE update, outside
+// the engine locks.
+func (e *Engine) OnResult(fn func(tagID int, ts int64, res fusion.FusionResult)) {
+	e.onResult = fn
+}
+
+// FeedTWR fuses one frame of TWR ranges for tagID.
+func (e *Engine) FeedTWR(tagID int, ts int64, meas []fusion.TWRMeas) fusion.FusionResult {
+	res, _ := e.feed(tagID, ts, nil, meas, nil)
+	return res
+}
+
+// FeedBLE fuses one frame of BLE strengths for tagID.
+func (e *Engine) FeedBLE(tagID int, ts int64, meas []fusion.BLEMeas) fusion.FusionResult {
+	res, _ := e.feed(tagID, ts, meas, nil, nil)
+	return res
+}
+
+// FeedIMU applies one pedometer step report for tagID. It only propagates
+// the filter; ok is true when dead reckoning (see SetDeadReckoning)
+// produced a FlagDeadReckoned result, which is then recorded like a fused
+// one.
+func (e *Engine) FeedIMU(tagID int, ts int64, distanceM, yawDeg float64, motion fusion.IMUMotion) (res fusion.FusionResult, ok bool) {
+	res, _, ok = e.feedIMU(tagID, ts, distanceM, yawDeg, motion, nil)
+	return res, ok
+}
+
+// feedIMU is FeedIMU that also applies note to the recorded state, as
+// feed does, and returns a copy of it.
+func (e *Engine) feedIMU(tagID int, ts int64, distanceM, yawDeg float64, motion fusion.IMUMotion, note func(p *wsPos)) (fusion.FusionResult, wsPos, bool) {
+	e.cfg.RLock()
+	tp := e.lockTag(tagID)
+	tp.p.ProcessIMUMotion(ts, distanceM, yawDeg, motion)
+	res, ok := tp.p.DeadReckon(ts)
+	if !ok {
+		tp.mu.Unlock()
+		e.cfg.RUnlock()
+		return res, wsPos{}, false
+	}
+	pos, fn := e.recordLocked(tagID, ts, res, note)
+	tp.mu.Unlock()
+	e.cfg.RUnlock()
+
+	if fn != nil {
+		fn(tagID, ts, res)
+	}
+	return res, pos, true
+}
+
+// feed fuses one frame for tagID under the tag's lock, records the result
+// and applies note (if non-nil) to the recorded state in the same critical
+// section, and returns the result and a copy of the state.
+func (e *Engine) feed(tagID int, ts int64, ble []fusion.BLEMeas, twr []fusion.TWRMeas, note func(p *wsPos)) (fusion.FusionResult, wsPos) {
+	if ble == nil {
+		ble = []fusion.BLEMeas{}
+	}
+	if twr == nil {
+		twr = []fusion.TWRMeas{}
+	}
+	e.cfg.RLock()
+	tp := e.lockTag(tagID)
+	// Height 0 picks the tag's configured height or the default
+	res := tp.p.Process(ts, tagID, ble, twr, 0.0)
+	// Hard safety clamp: drop the point to avoid contaminating downstream outputs
+	if math.Abs(res.X) > 1000.0 || math.Abs(res.Y) > 1000.0 {
+		logging.Debugf("Large Coordinate detected! Tag=%x X=%.2f Y=%.2f", tagID, res.X, res.Y)
+		res.Flag = -2
+		res.X, res.Y = 0, 0
+	}
+	pos, fn := e.recordLocked(tagID, ts, res, note)
+	tp.mu.Unlock()
+	e.cfg.RUnlock()
+
+	if fn != nil {
+		fn(tagID, ts, res)
+	}
+	return res, pos
+}
+
+// lockTag returns tagID's pipeline with its lock held, creating the
+// pipeline if needed. Caller holds e.cfg shared; creating a pipeline
+// briefly trades it for the exclusive lock, as pipelines are built from
+// the shared site configuration.
+func (e *Engine) lockTag(tagID int) *tagPipeline {
+	e.mu.Lock()
+	tp, ok := e.pipelines[tagID]
+	e.mu.Unlock()
+	if !ok {
+		e.cfg.RUnlock()
+		e.cfg.Lock()
+		e.mu.Lock()
+		tp = e.pipeline(tagID)
+		e.mu.Unlock()
+		e.cfg.Unlock()
+		e.cfg.RLock()
+	}
+	tp.mu.Lock()
+	return tp
+}
+
+// recordLocked records res, applies note and returns a copy of the state
+// and the result callback. Caller holds the tag's lock.
+func (e *Engine) recordLocked(tagID int, ts int64, res fusion.FusionResult, note func(p *wsPos)) (wsPos, func(int, int64, fusion.FusionResult)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record(tagID, ts, res)
+	st := e.states[tagID]
+	if note != nil {
+		note(st)
+	}
+	return *st, e.onResult
+}
+
+// record stores res as the tag's latest state (always, even if invalid or
+// predictive), keeping the last environment readings. Caller holds e.mu.
+func (e *Engine) record(tagID int, ts int64, res fusion.FusionResult) {
+	region := 0
+	if res.Layer != nil {
+		region = *res.Layer
+	}
+	pos := &wsPos{
+		ID:      int64(tagID),
+		TS:      ts,
+		X:       res.X,
+		Y:       res.Y,
+		Z:       res.Z,
+		Layer:   region,
+		Flag:    res.Flag,
+		Quality: res.Quality,
+	}
+	if old, ok := e.states[tagID]; ok {
+		pos.Pressure = old.Pressure
+		pos.Temperature = old.Temperature
+		pos.GwAddr = old.GwAddr
+	}
+	e.states[tagID] = pos
+	e.lastSeen[tagID] = time.Now()
+}
+
+// annotate applies fn to the tag's state, creating an empty one if needed,
+// and returns a copy of the result.
+func (e *Engine) annotate(tagID int, fn func(p *wsPos)) wsPos {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st, ok := e.states[tagID]
+	if !ok {
+		st = &wsPos{ID: int64(tagID)}
+		e.states[tagID] = st
+	}
+	fn(st)
+	return *st
+}
+
+// Positions returns a snapshot of every tag's latest state, by tag ID.
+func (e *Engine) Positions() []Position {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]Position, 0, len(e.states))
+	for _, st := range e.states {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// SweepIdle marks every tag without a fusion result for longer than
+// timeout as lost (Flag = FlagLost) and returns those states. With remove
+// set the tags' states and pipelines are dropped instead, so they start
+// fresh if they reappear. Tags already reported lost are skipped.
+func (e *Engine) SweepIdle(now time.Time, timeout time.Duration, remove bool) []Position {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lost := []Position{}
+	for tagID, seen := range e.lastSeen {
+		st, ok := e.states[tagID]
+		if !ok || st.Flag == FlagLost || now.Sub(seen) < timeout {
+			continue
+		}
+		st.Flag = FlagLost
+		st.TS = now.UnixMilli()
+		lost = append(lost, *st)
+		if remove {
+			delete(e.states, tagID)
+			delete(e.pipelines, tagID)
+			delete(e.lastSeen, tagID)
+		}
+	}
+	sort.Slice(lost, func(i, j int) bool { return lost[i].ID < lost[j].ID })
+	return lost
+}
+
+// hasTag reports whether the engine holds a state for tagID.
+func (e *Engine) hasTag(tagID int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.states[tagID]
+	return ok
+}
+
+// SetTagMotionProfile assigns kinematic limits (e.g. a forklift profile)
+// to one tag.
+func (e *Engine) SetTagMotionProfile(tagID int, mp fusion.MotionProfile) {
+	defer e.lockConfig()()
+	e.motionProfiles[tagID] = mp
+	if tp, ok := e.pipelines[tagID]; ok {
+		tp.p.SetMotionProfile(mp)
+	}
+}
+
+// SetRssiSmoothing enables BLE strength smoothing with time constant tau on
+// tag pipelines.
+func (e *Engine) SetRssiSmoothing(tau time.Duration) {
+	defer e.lockConfig()()
+	e.rssiTau = tau
+	for _, tp := range e.pipelines {
+		tp.p.SetRssiSmoothing(tau)
+	}
+}
+
+// SetMaxRange sets the longest TWR range (meters) tag pipelines accept.
+func (e *Engine) SetMaxRange(meters float64) {
+	defer e.lockConfig()()
+	e.maxRange = meters
+	for _, tp := range e.pipelines {
+		tp.p.SetMaxRange(meters)
+	}
+}
+
+// SetUKF switches tag pipelines to the unscented measurement update.
+func (e *Engine) SetUKF(on bool) {
+	defer e.lockConfig()()
+	e.ukf = on
+	for _, tp := range e.pipelines {
+		tp.p.SetUKF(on)
+	}
+}
+
+// SetDeadReckoning makes tag pipelines emit IMU dead-reckoned positions
+// once no anchor fix has been made for gap; 0 disables it.
+func (e *Engine) SetDeadReckoning(gap time.Duration) {
+	defer e.lockConfig()()
+	e.deadReckonGap = gap
+	for _, tp := range e.pipelines {
+		tp.p.EnableDeadReckoning(gap.Milliseconds())
+	}
+}
+
+// SetResetGap sets the update gap past which tag filters reset (0 =
+// fusion.ResetGap); with predict, longer gaps predict the filter forward
+// instead while the tag is inside the map.
+func (e *Engine) SetResetGap(gap time.Duration, predict bool) {
+	defer e.lockConfig()()
+	e.resetGap = gap
+	e.predictGap = predict
+	for _, tp := range e.pipelines {
+		tp.p.SetResetGap(gap)
+		tp.p.SetPredictAcrossGap(predict)
+	}
+}
+
+// SetTagHeight records a tag's mounting height and applies it to its
+// pipeline if one exists.
+func (e *Engine) SetTagHeight(tagID int, height float64) {
+	defer e.lockConfig()()
+	e.tagHeights[tagID] = height
+	if tp, ok := e.pipelines[tagID]; ok {
+		tp.p.SetTagHeight(tagID, height)
+	}
+}
+
+// SetDefaultTagHeight sets the mounting height (m) of tags without one of
+// their own on every pipeline (0 = fusion.DefaultTagHeight).
+func (e *Engine) SetDefaultTagHeight(height float64) {
+	defer e.lockConfig()()
+	e.defaultTagHeight = height
+	if height <= 0 {
+		height = fusion.DefaultTagHeight
+	}
+	for _, tp := range e.pipelines {
+		tp.p.SetDefaultTagHeight(height)
+	}
+}
+
+// ExcludeAnchor takes anchor id out of service on every tag pipeline,
+// current and future, until IncludeAnchor.
+func (e *Engine) ExcludeAnchor(id int) {
+	defer e.lockConfig()()
+	e.excluded[id] = true
+	for _, tp := range e.pipelines {
+		tp.p.ExcludeAnchor(id)
+	}
+}
+
+// IncludeAnchor puts an excluded anchor back in service.
+func (e *Engine) IncludeAnchor(id int) {
+	defer e.lockConfig()()
+	delete(e.excluded, id)
+	for _, tp := range e.pipelines {
+		tp.p.IncludeAnchor(id)
+	}
+}
+
+// ExcludedAnchors lists the excluded anchor ids in ascending order.
+func (e *Engine) ExcludedAnchors() []int {
+	e.cfg.RLock()
+	defer e.cfg.RUnlock()
+	ids := make([]int, 0, len(e.excluded))
+	for id := range e.excluded {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// AddAnchor updates the shared anchor store, the layer manager and all
+// live pipelines.
+func (e *Engine) AddAnchor(a fusion.Anchor) {
+	defer e.lockConfig()()
+	// overwrite to keep latest coordinates
+	e.anchors[a.ID] = a
+	if e.layerManager != nil {
+		e.layerManager.AddAnchorToLayer(a)
+	}
+	for _, tp := range e.pipelines {
+		if !tp.p.HasAnchor(a.ID) {
+			tp.p.AddAnchor(a)
+		}
+	}
+}
+
+// ReloadConfig swaps a new site configuration into the engine and every
+// live pipeline under the engine lock, so no update sees a half-applied
+// reload. Filter state survives; anchors added since startup through
+// AddAnchor are dropped unless the new map has them. The anchor map is
+// copied.
+func (e *Engine) ReloadConfig(anchors map[int]fusion.Anchor, dimMap map[int][]fusion.DimMat, beaconLayer map[int]int, beaconDims map[int][]fusion.DimMat, lm *fusion.LayerManager) {
+	anchCopy := make(map[int]fusion.Anchor, len(anchors))
+	for k, v := range anchors {
+		anchCopy[k] = v
+	}
+	defer e.lockConfig()()
+	e.anchors = anchCopy
+	e.dimMap = dimMap
+	e.beaconLayer = beaconLayer
+	e.beaconDims = beaconDims
+	e.layerManager = lm
+	for _, tp := range e.pipelines {
+		tp.p.ReloadConfig(e.anchors, dimMap, beaconLayer, beaconDims, lm)
+	}
+}
+
+// lockConfig waits for running updates and blocks new ones, then locks
+// the tag maps, for changing settings the pipelines share. It returns the
+// matching unlock.
+func (e *Engine) lockConfig() func() {
+	e.cfg.Lock()
+	e.mu.Lock()
+	return func() {
+		e.mu.Unlock()
+		e.cfg.Unlock()
+	}
+}
+
+// pipeline returns a per-tag fusion pipeline, creating one if missing.
+// Caller holds e.cfg exclusively and e.mu.
+func (e *Engine) pipeline(tagID int) *tagPipeline {
+	if tp, ok := e.pipelines[tagID]; ok {
+		return tp
+	}
+	p := fusion.NewFusionPipeline(e.anchors, e.rssiModel, e.dimMap, e.beaconLayer, e.beaconDims, e.layerManager)
+	p.OnReset(func(tagID int, reason string, tsMs int64) {
+		logging.Infof("Tag %X filter reset at %d: %s", tagID, tsMs, reason)
+	})
+	if h, ok := e.tagHeights[tagID]; ok {
+		p.SetTagHeight(tagID, h)
+	}
+	if e.defaultTagHeight > 0 {
+		p.SetDefaultTagHeight(e.defaultTagHeight)
+	}
+	p.SetRssiSmoothing(e.rssiTau)
+	p.SetMaxRange(e.maxRange)
+	p.SetUKF(e.ukf)
+	p.EnableDeadReckoning(e.deadReckonGap.Milliseconds())
+	p.SetResetGap(e.resetGap)
+	p.SetPredictAcrossGap(e.predictGap)
+	if mp, ok := e.motionProfiles[tagID]; ok {
+		p.SetMotionProfile(mp)
+	}
+	for id := range e.excluded {
+		p.ExcludeAnchor(id)
+	}
+	tp := &tagPipeline{p: p}
+	e.pipelines[tagID] = tp
+	return tp
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"engine-go/fusion"
+)
+
+func testEngine() (*Engine, map[int]fusion.Anchor) {
+	anchors := map[int]fusion.Anchor{
+		1: {ID: 1, X: 0, Y: 0, Z: 3, Layer: 1},
+		2: {ID: 2, X: 20, Y: 0, Z: 3, Layer: 1},
+		3: {ID: 3, X: 20, Y: 20, Z: 3, Layer: 1},
+		4: {ID: 4, X: 0, Y: 20, Z: 3, Layer: 1},
+	}
+	lm := fusion.NewLayerManager(map[int]*fusion.Layer{}, nil)
+	return NewEngine(anchors, fusion.NewBLERssi(2, 0, 0), map[int][]fusion.DimMat{}, map[int]int{}, map[int][]fusion.DimMat{}, lm), anchors
+}
+
+func ranges(anchors map[int]fusion.Anchor, x, y float64) []fusion.TWRMeas {
+	out := []fusion.TWRMeas{}
+	for id, a := range anchors {
+		out = append(out, fusion.TWRMeas{AnchorID: id, Range: math.Sqrt((x-a.X)*(x-a.X) + (y-a.Y)*(y-a.Y) + (1.2-a.Z)*(1.2-a.Z))})
+	}
+	return out
+}
+
+// TestEngineConcurrentFeeds runs feeds for several tags, the same tag from
+// two goroutines, setting changes and readers at once; run with -race.
+func TestEngineConcurrentFeeds(t *testing.T) {
+	e, anchors := testEngine()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			tagID := 0xB5000 + g%4
+			for i := 0; i < 50; i++ {
+				ts := int64(1700000000000 + i*100)
+				e.feed(tagID, ts, nil, ranges(anchors, 5+float64(g%4), 8), func(p *wsPos) { p.GwAddr = "gw" })
+			}
+		}(g)
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			e.SetMaxRange(float64(300 + i))
+			e.ExcludeAnchor(99)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			e.Positions()
+		}
+	}()
+	wg.Wait()
+
+	pos := e.Positions()
+	if len(pos) != 4 {
+		t.Fatalf("%d tag states, want 4", len(pos))
+	}
+	for _, p := range pos {
+		if p.GwAddr != "gw" {
+			t.Errorf("tag %X: note not applied to the recorded state", p.ID)
+		}
+	}
+}
+
+func TestEngineFeedAppliesNote(t *testing.T) {
+	e, anchors := testEngine()
+	pressure := 101325.0
+	res, pos := e.feed(0xB50AC, 1700000000000, nil, ranges(anchors, 5, 8), func(p *wsPos) { p.Pressure = &pressure })
+	if pos.Pressure == nil || *pos.Pressure != pressure || pos.Flag != res.Flag {
+		t.Errorf("returned state %+v does not carry the note and result", pos)
+	}
+	if got := e.Positions(); len(got) != 1 || got[0].Pressure == nil {
+		t.Errorf("recorded states %+v", got)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// forwardQueueLen bounds the packets waiting to be forwarded; further
+// packets are dropped until the forwarder catches up.
+const forwardQueueLen = 1024
+
+// packetForwarder copies raw input packets to a monitoring address.
+type packetForwarder struct {
+	conn  *net.UDPConn
+	queue chan []byte
+	quit  chan struct{}
+	once  sync.Once
+}
+
+// SetForwardAddr sends a copy of every received raw packet, unchanged and
+// in arrival order, to addr over UDP. Forwarding never blocks the receive
+// loop: packets are dropped while the queue is full. An empty addr stops
+// forwarding. Call before Start.
+func (s *UdpServer) SetForwardAddr(addr string) error {
+	if s.fwd != nil {
+		s.fwd.stop()
+		s.fwd = nil
+	}
+	if addr == "" {
+		return nil
+	}
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("resolve forward addr: %w", err)
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return fmt.Errorf("dial forward addr: %w", err)
+	}
+	f := &packetForwarder{
+		conn:  conn,
+		queue: make(chan []byte, forwardQueueLen),
+		quit:  make(chan struct{}),
+	}
+	go f.run()
+	s.fwd = f
+	return nil
+}
+
+// forward queues data for forwarding. data must not be modified afterwards.
+func (s *UdpServer) forward(data []byte) {
+	if s.fwd == nil {
+		return
+	}
+	select {
+	case s.fwd.queue <- data:
+	default:
+	}
+}
+
+func (f *packetForwarder) run() {
+	for {
+		select {
+		case pkt := <-f.queue:
+			_, _ = f.conn.Write(pkt)
+		case <-f.quit:
+			f.conn.Close()
+			return
+		}
+	}
+}
+
+// stop ends forwarding; packets still queued are discarded. The queue is
+// left open so late senders on other goroutines never panic.
+func (f *packetForwarder) stop() {
+	f.once.Do(func() { close(f.quit) })
+}
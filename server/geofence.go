@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"engine-go/fusion"
+	"engine-go/rbc"
+)
+
+// ForbiddenRegion is a rectangle (meters) on a layer that tags must not
+// enter. Code is sent as the RBC warning code; 0 means WarnForbiddenRegion.
+type ForbiddenRegion struct {
+	Layer int     `json:"layer"`
+	XTL   float64 `json:"x_tl"`
+	YTL   float64 `json:"y_tl"`
+	XBR   float64 `json:"x_br"`
+	YBR   float64 `json:"y_br"`
+	Code  int     `json:"code,omitempty"`
+}
+
+func (r ForbiddenRegion) contains(layer int, x, y float64) bool {
+	return layer == r.Layer && x >= r.XTL && x <= r.XBR && y >= r.YTL && y <= r.YBR
+}
+
+// LoadForbiddenRegions reads a JSON array of ForbiddenRegion.
+func LoadForbiddenRegions(path string) ([]ForbiddenRegion, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var regions []ForbiddenRegion
+	if err := json.Unmarshal(b, &regions); err != nil {
+		return nil, err
+	}
+	return regions, nil
+}
+
+// SetForbiddenRegions configures the geofence regions. A FlagWarning RBC
+// message is sent each time a tag enters one of them.
+func (s *UdpServer) SetForbiddenRegions(regions []ForbiddenRegion) {
+	s.mu.Lock()
+	s.forbidden = regions
+	s.inForbidden = make(map[int]map[int]bool)
+	s.mu.Unlock()
+}
+
+// checkForbidden sends a warning for each forbidden region the tag entered
+// since its previous valid position. Regions are tested against x, y in
+// map meters; the warning reports outX, outY, the position in output units
+// (see SetOutputTransform) as in the position messages.
+func (s *UdpServer) checkForbidden(tagID int, ts int64, layer int, x, y, outX, outY float64) {
+	s.mu.Lock()
+	if len(s.forbidden) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	inside := s.inForbidden[tagID]
+	if inside == nil {
+		inside = make(map[int]bool)
+		s.inForbidden[tagID] = inside
+	}
+	var entered []ForbiddenRegion
+	for i, r := range s.forbidden {
+		in := r.contains(layer, x, y)
+		if in && !inside[i] {
+			entered = append(entered, r)
+		}
+		inside[i] = in
+	}
+	s.mu.Unlock()
+
+	if s.sender == nil {
+		return
+	}
+	for _, r := range entered {
+		code := r.Code
+		if code == 0 {
+			code = rbc.WarnForbiddenRegion
+		}
+		s.sender.Send(s.sender.Formatter().FormatPosWarning(tagID, ts, code, layer, outX, outY), rbc.FlagWarning)
+	}
+}
+
+// wsZoneEvent is the websocket message for a zone enter/exit.
+type wsZoneEvent struct {
+	Event   string `json:"event"` // "enter" or "exit"
+	ID      int64  `json:"id"`
+	Region  int    `json:"region"`
+	TS      int64  `json:"ts"`
+	DwellMs int64  `json:"dwell_ms,omitempty"`
+}
+
+// SetGeofenceMonitor routes every fused position through m and reports its
+// enter/exit events as websocket messages and RBC warnings
+// (WarnRegionEnter/WarnRegionExit). Call before Start.
+func (s *UdpServer) SetGeofenceMonitor(m *fusion.GeofenceMonitor) {
+	m.OnEnter(func(tagID, regionID int, tsMs int64) {
+		s.emitZoneEvent(wsZoneEvent{Event: "enter", ID: int64(tagID), Region: regionID, TS: tsMs}, rbc.WarnRegionEnter)
+	})
+	m.OnExit(func(tagID, regionID int, tsMs int64, dwellMs int64) {
+		s.emitZoneEvent(wsZoneEvent{Event: "exit", ID: int64(tagID), Region: regionID, TS: tsMs, DwellMs: dwellMs}, rbc.WarnRegionExit)
+	})
+	s.geoMon = m
+}
+
+func (s *UdpServer) emitZoneEvent(ev wsZoneEvent, code int) {
+	if s.webHub != nil {
+		b, _ := json.Marshal(ev)
+		s.webHub.BroadcastTag(ev.ID, b)
+	}
+	if s.sender != nil {
+		msg := fmt.Sprintf("region %d %s", ev.Region, ev.Event)
+		if ev.Event == "exit" {
+			msg = fmt.Sprintf("%s after %d ms", msg, ev.DwellMs)
+		}
+		s.sender.Send(s.sender.Formatter().FormatWarning(int(ev.ID), ev.TS, code, msg), rbc.FlagWarning)
+	}
+}
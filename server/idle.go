@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"engine-go/logging"
+	"engine-go/rbc"
+)
+
+// FlagLost is the position flag sent for a tag that stopped reporting (see
+// SetIdleTimeout).
+const FlagLost = -9
+
+// DefaultIdleTimeout is how long a tag may go without a fusion result before
+// it is reported lost.
+const DefaultIdleTimeout = 30 * time.Second
+
+type idleSweeper struct {
+	timeout time.Duration
+	sendRbc bool
+	remove  bool
+	quit    chan struct{}
+	once    sync.Once
+}
+
+// SetIdleTimeout starts a background sweep that reports tags without a
+// fusion result for timeout as lost: a websocket position with flag
+// FlagLost and, with sendRbc, an RBC WarnTagLost warning. With remove the
+// tag's state and filter are dropped as well. timeout <= 0 stops the sweep.
+// Stop also stops it.
+func (s *UdpServer) SetIdleTimeout(timeout time.Duration, sendRbc, remove bool) {
+	s.mu.Lock()
+	old := s.idle
+	s.idle = nil
+	if timeout > 0 {
+		s.idle = &idleSweeper{timeout: timeout, sendRbc: sendRbc, remove: remove, quit: make(chan struct{})}
+	}
+	sw := s.idle
+	s.mu.Unlock()
+
+	if old != nil {
+		old.stop()
+	}
+	if sw != nil {
+		go s.runIdleSweeper(sw)
+	}
+}
+
+func (s *UdpServer) runIdleSweeper(sw *idleSweeper) {
+	period := sw.timeout / 4
+	if period < time.Second {
+		period = time.Second
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sw.quit:
+			return
+		case now := <-ticker.C:
+			for _, pos := range s.engine.SweepIdle(now, sw.timeout, sw.remove) {
+				s.reportLost(pos, sw.sendRbc)
+			}
+		}
+	}
+}
+
+// reportLost announces a lost tag to web clients and, optionally, RBC.
+func (s *UdpServer) reportLost(pos Position, sendRbc bool) {
+	logging.Infof("Tag %X lost: no update for the idle timeout", pos.ID)
+	if s.webHub != nil {
+		b, _ := json.Marshal(pos)
+		s.webHub.BroadcastPos(pos.ID, pos.Layer, b)
+	}
+	if sendRbc && s.sender != nil {
+		msg := s.sender.Formatter().FormatWarning(int(pos.ID), pos.TS, rbc.WarnTagLost, "tag lost")
+		s.sender.Send(msg, rbc.FlagWarning)
+	}
+}
+
+func (sw *idleSweeper) stop() {
+	sw.once.Do(func() { close(sw.quit) })
+}
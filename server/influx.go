@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"engine-go/fusion"
+	"engine-go/logging"
+)
+
+// InfluxDB output batching.
+const (
+	InfluxBatchSize     = 100
+	InfluxFlushInterval = 500 * time.Millisecond
+	influxQueueLen      = 4096
+	influxHTTPTimeout   = 5 * time.Second
+)
+
+// InfluxConfig is the InfluxDB v2 HTTP write endpoint positions are
+// written to as line protocol.
+type InfluxConfig struct {
+	URL    string
+	Org    string
+	Bucket string
+	Token  string
+}
+
+// influxWriter batches line-protocol points and writes them from its own
+// goroutine so slow endpoints never block the receive loop.
+type influxWriter struct {
+	queue chan string
+	quit  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+
+	client   *http.Client
+	writeURL string
+	token    string
+}
+
+// SetInflux writes every valid position (Flag >= 1) as a "position" point
+// with tag_id tag and x, y (meters), flag and hdop fields. Points are sent
+// in batches of InfluxBatchSize or every InfluxFlushInterval; a batch that
+// fails to write is logged and dropped, not retried. A zero cfg stops the
+// output. Call before Start.
+func (s *UdpServer) SetInflux(cfg InfluxConfig) error {
+	if s.influx != nil {
+		s.influx.stop()
+		s.influx = nil
+	}
+	if cfg.URL == "" {
+		return nil
+	}
+	if cfg.Bucket == "" {
+		return fmt.Errorf("influx: bucket is required")
+	}
+	q := url.Values{}
+	q.Set("org", cfg.Org)
+	q.Set("bucket", cfg.Bucket)
+	q.Set("precision", "ns")
+	w := &influxWriter{
+		queue:    make(chan string, influxQueueLen),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		client:   &http.Client{Timeout: influxHTTPTimeout},
+		writeURL: strings.TrimRight(cfg.URL, "/") + "/api/v2/write?" + q.Encode(),
+		token:    cfg.Token,
+	}
+	go w.run()
+	s.influx = w
+	return nil
+}
+
+// influxLine formats one position point; ts is in milliseconds.
+func influxLine(tagID int, ts int64, res fusion.FusionResult) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "position,tag_id=%X x=%s,y=%s,flag=%di", tagID,
+		strconv.FormatFloat(res.X, 'f', 4, 64), strconv.FormatFloat(res.Y, 'f', 4, 64), res.Flag)
+	// Line protocol has no NaN or Inf.
+	if !math.IsNaN(res.HDOP) && !math.IsInf(res.HDOP, 0) {
+		sb.WriteString(",hdop=")
+		sb.WriteString(strconv.FormatFloat(res.HDOP, 'f', 3, 64))
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatInt(ts*int64(time.Millisecond), 10))
+	return sb.String()
+}
+
+// writeInflux queues a position; it is dropped while the queue is full.
+func (s *UdpServer) writeInflux(tagID int, ts int64, res fusion.FusionResult) {
+	if s.influx == nil || res.Flag < 1 {
+		return
+	}
+	select {
+	case s.influx.queue <- influxLine(tagID, ts, res):
+	default:
+	}
+}
+
+func (w *influxWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(InfluxFlushInterval)
+	defer ticker.Stop()
+	batch := make([]string, 0, InfluxBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := w.write(batch); err != nil {
+			logging.Warnf("Influx write failed, dropped %d points: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case line := <-w.queue:
+			batch = append(batch, line)
+			if len(batch) >= InfluxBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-w.quit:
+			for {
+				select {
+				case line := <-w.queue:
+					batch = append(batch, line)
+					if len(batch) >= InfluxBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *influxWriter) write(batch []string) error {
+	body := []byte(strings.Join(batch, "\n") + "\n")
+	req, err := http.NewRequest(http.MethodPost, w.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// stop flushes queued points and waits for the final write.
+func (w *influxWriter) stop() {
+	w.once.Do(func() { close(w.quit) })
+	<-w.done
+}
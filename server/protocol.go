@@ -22,6 +22,8 @@ const (
 
 	TypeExdBaroTemp = 0x8C
 	TypeUpExd = 0x21
+
+	TypeAnchorHeartbeat = 0x20
 )
 
 type UnibHeader struct {
@@ -49,8 +51,20 @@ type RssiSample struct {
 }
 
 type ImuData struct {
-	DistanceM float64
-	YawDeg    float64
+	DistanceM    float64
+	YawDeg       float64
+	SpeedMps     float64
+	MotionCode   int
+	YawSigmaCode int
+	DsSigmaCode  int
+}
+
+// AnchorHeartbeat is the periodic status an anchor broadcasts.
+type AnchorHeartbeat struct {
+	AnchorID    uint32
+	RSSI        int8   // dBm, as heard by the gateway
+	BatteryMV   uint16 // millivolts
+	Temperature int8   // degrees Celsius
 }
 
 type ExdData struct {
@@ -169,6 +183,11 @@ func PackageHeader(buf []byte, typ uint16, addr uint32, bodyLen int) {
 	buf[8] = lenHigh
 }
 
+// PackageSetTagReq builds a TypeLoraSetDevReq (0x44) downlink for tagID,
+// relayed by gateway gwID. As in the C++ engine, the UNIB address field
+// carries the gateway; the tag ID travels in the EPSETREQ body together
+// with the setting index and its data. The trailing CRC16 covers
+// header and body. ParseSetTagReq decodes the body.
 func PackageSetTagReq(gwID uint32, tagID uint32, setIdx uint8, data []byte) []byte {
 	// Body = struct EPSETREQ { uint32 id; uint8 cmd; uint8 dat[0]; } + data
 	// Size = 4 + 1 + len(data)
@@ -195,6 +214,26 @@ func PackageSetTagReq(gwID uint32, tagID uint32, setIdx uint8, data []byte) []by
 	return buf
 }
 
+// ParseSetTagReq decodes the EPSETREQ body of a TypeLoraSetDevReq packet
+// built by PackageSetTagReq.
+func ParseSetTagReq(body []byte) (tagID uint32, setIdx uint8, data []byte, err error) {
+	if len(body) < 5 {
+		return 0, 0, nil, fmt.Errorf("set tag request too short")
+	}
+	return binary.LittleEndian.Uint32(body[0:4]), body[4], body[5:], nil
+}
+
+// VerifyUnibCRC reports whether the CRC16 following the body of the UNIB
+// packet at the start of data matches its header and body. It is false
+// when data is too short to hold the CRC.
+func VerifyUnibCRC(data []byte, hdr *UnibHeader) bool {
+	end := UnibHdrLen + hdr.BodyLen
+	if end+2 > len(data) {
+		return false
+	}
+	return Crc16Ccitt(data[:end]) == binary.LittleEndian.Uint16(data[end:end+2])
+}
+
 // ParseHeader parses the UNIB header from the beginning of the packet.
 func ParseHeader(data []byte) (*UnibHeader, error) {
 	if len(data) < UnibHdrLen {
@@ -236,6 +275,8 @@ func ParseHeader(data []byte) (*UnibHeader, error) {
 	}, nil
 }
 
+// ParseTwrFrame decodes a TWR body. Ranges are uint16 centimeters, as in
+// binlog, so they never exceed 655.35 m.
 func ParseTwrFrame(body []byte) ([]TwrSample, []byte, error) {
 	if len(body) < 2 {
 		return nil, nil, fmt.Errorf("twr frame too short")
@@ -358,12 +399,34 @@ func ParseImuFrame(body []byte) (*ImuData, []byte, error) {
 		return nil, nil, err
 	}
 
+	// word1: yaw:13, motion:3, yaw_sigma:3, ds_sigma:3 (LSB first)
+	// word2: speed in cm/s
 	word1 := binary.LittleEndian.Uint32(body[5:9])
+	word2 := binary.LittleEndian.Uint16(body[9:11])
 	yawCode := word1 & 0x1FFF
 	yawDeg := float64(yawCode) * (360.0 / 8192.0)
 
 	return &ImuData{
-		DistanceM: float64(distance),
-		YawDeg:    yawDeg,
+		DistanceM:    float64(distance),
+		YawDeg:       yawDeg,
+		SpeedMps:     float64(word2) / 100.0,
+		MotionCode:   int((word1 >> 13) & 0x7),
+		YawSigmaCode: int((word1 >> 16) & 0x7),
+		DsSigmaCode:  int((word1 >> 19) & 0x7),
 	}, body[11:], nil
 }
+
+// ParseAnchorHeartbeat decodes a heartbeat body: anchor ID (u32 LE), RSSI
+// (i8), battery (u16 LE, mV) and temperature (i8). Trailing bytes are
+// ignored.
+func ParseAnchorHeartbeat(body []byte) (*AnchorHeartbeat, error) {
+	if len(body) < 8 {
+		return nil, fmt.Errorf("anchor heartbeat too short")
+	}
+	return &AnchorHeartbeat{
+		AnchorID:    binary.LittleEndian.Uint32(body[0:4]),
+		RSSI:        int8(body[4]),
+		BatteryMV:   binary.LittleEndian.Uint16(body[5:7]),
+		Temperature: int8(body[7]),
+	}, nil
+}
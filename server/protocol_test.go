@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestPackageSetTagReqRoundTrip(t *testing.T) {
+	const gwID, tagID = 0x5A5A, 0xB50AC
+	data := []byte{0x01, 0x02, 0xFF}
+	pkt := PackageSetTagReq(gwID, tagID, 7, data)
+
+	hdr, err := ParseHeader(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Type != TypeLoraSetDevReq || hdr.Addr != gwID || hdr.BodyLen != 5+len(data) {
+		t.Fatalf("header %+v, want type 0x%X to gateway %X with a %d-byte body", *hdr, TypeLoraSetDevReq, gwID, 5+len(data))
+	}
+	if len(pkt) != UnibHdrLen+hdr.BodyLen+2 || !VerifyUnibCRC(pkt, hdr) {
+		t.Fatalf("%d-byte packet fails its CRC", len(pkt))
+	}
+	gotTag, setIdx, gotData, err := ParseSetTagReq(pkt[UnibHdrLen : UnibHdrLen+hdr.BodyLen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotTag != tagID || setIdx != 7 || !bytes.Equal(gotData, data) {
+		t.Errorf("body decoded to tag %X, index %d, data % X", gotTag, setIdx, gotData)
+	}
+
+	pkt[UnibHdrLen] ^= 0xFF
+	if VerifyUnibCRC(pkt, hdr) {
+		t.Error("CRC still matches after corrupting the body")
+	}
+	if _, _, _, err := ParseSetTagReq(pkt[UnibHdrLen : UnibHdrLen+4]); err == nil {
+		t.Error("4-byte body accepted")
+	}
+}
+
+func TestParseTwrFrameTruncated(t *testing.T) {
+	// seq 7, two samples: 0x1A2B3C at 3.21 m and 0x1A2B3D at 4.56 m.
+	body := []byte{7, 2 << 4, 0x3C, 0x2B, 0x1A, 0, 0, 0x3D, 0x2B, 0x1A, 0, 0}
+	binary.LittleEndian.PutUint16(body[5:], 321)
+	binary.LittleEndian.PutUint16(body[10:], 456)
+
+	samples, rest, err := ParseTwrFrame(body)
+	if err != nil || len(samples) != 2 || len(rest) != 0 {
+		t.Fatalf("decoded %+v, rest %d bytes, %v", samples, len(rest), err)
+	}
+	if samples[1].AnchorID != 0x1A2B3D || samples[1].RangeM != 4.56 {
+		t.Errorf("second sample %+v", samples[1])
+	}
+	for n := 0; n < len(body); n++ {
+		if samples, _, err := ParseTwrFrame(body[:n]); err == nil {
+			t.Errorf("%d of %d bytes decoded to %+v", n, len(body), samples)
+		}
+	}
+}
+
+func TestParseTwrFrameSTruncated(t *testing.T) {
+	body := []byte{7, 2 << 4, 0x3C, 0x2B, 0, 0, 0x3D, 0x2B, 0, 0}
+	binary.LittleEndian.PutUint16(body[4:], 321)
+	binary.LittleEndian.PutUint16(body[8:], 456)
+
+	samples, _, err := ParseTwrFrameS(body)
+	if err != nil || len(samples) != 2 || samples[0].AnchorID != 0x2B3C || samples[0].RangeM != 3.21 {
+		t.Fatalf("decoded %+v, %v", samples, err)
+	}
+	for n := 0; n < len(body); n++ {
+		if samples, _, err := ParseTwrFrameS(body[:n]); err == nil {
+			t.Errorf("%d of %d bytes decoded to %+v", n, len(body), samples)
+		}
+	}
+}
@@ -4,12 +4,12 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 	"net"
-	"os"
 	"time"
 
+	"engine-go/binlog"
 	"engine-go/fusion"
+	"engine-go/logging"
 )
 
 const (
@@ -51,8 +51,60 @@ func (s *UdpServer) parseAnchorBlock(payload []byte, itemnum int, itemsize int)
 	}
 }
 
+// parseTagBlock applies the tag mounting heights from a pcap tag block.
+func (s *UdpServer) parseTagBlock(payload []byte, itemnum int, itemsize int) {
+	for i := 0; i < itemnum; i++ {
+		start := i * itemsize
+		end := start + itemsize
+		if end > len(payload) || itemsize < 12 {
+			return
+		}
+		chunk := payload[start:end]
+		tagID := int(uint32(binary.LittleEndian.Uint64(chunk[0:8])))
+		height := int32(binary.LittleEndian.Uint32(chunk[8:12]))
+		s.setTagHeight(tagID, float64(height)/100.0)
+	}
+}
+
+// SetRebaseTime makes Replay shift packet timestamps so the first packet
+// maps to the current time, keeping inter-packet deltas. The shifted time
+// is what the pipeline and any pcap re-logging see. Each further Replay
+// call (looping) starts after the previous one ended, so timestamps keep
+// increasing. Call before Replay.
+func (s *UdpServer) SetRebaseTime(enabled bool) {
+	s.rebaseTime = enabled
+}
+
+// ReplayProgressEvery is how many data packets Replay processes between
+// progress reports.
+const ReplayProgressEvery = 1000
+
+// ReplayProgress is a snapshot of a running Replay; its ETA comes from the
+// share of the file read so far, without a second pass over the file.
+type ReplayProgress = binlog.ReadProgress
+
+// SetReplayProgressFunc registers fn to be called every ReplayProgressEvery
+// packets during Replay. fn runs on its own goroutine so the replay never
+// waits for it; a report due while the previous call is still running is
+// dropped. Call before Replay.
+func (s *UdpServer) SetReplayProgressFunc(fn func(ReplayProgress)) {
+	s.replayProgress = fn
+}
+
+// reportReplayProgress hands p to the progress callback without blocking.
+func (s *UdpServer) reportReplayProgress(p ReplayProgress) {
+	fn := s.replayProgress
+	if fn == nil || !s.replayProgressBusy.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.replayProgressBusy.Store(false)
+		fn(p)
+	}()
+}
+
 func (s *UdpServer) Replay(path string, speed float64) error {
-	f, err := os.Open(path)
+	f, err := binlog.OpenPcap(path)
 	if err != nil {
 		return err
 	}
@@ -64,21 +116,26 @@ func (s *UdpServer) Replay(path string, speed float64) error {
 		return fmt.Errorf("read global header: %w", err)
 	}
 
-	s.running = true
-	log.Printf("Replaying %s at %.1fx speed...", path, speed)
+	s.running.Store(true)
+	s.replaying = true
+	defer func() { s.replaying = false }()
+	logging.Infof("Replaying %s at %.1fx speed...", path, speed)
 
 	bufRec := make([]byte, pcapRecordLen)
 	bufPhdr2 := make([]byte, phdr2Len)
 
 	var firstTs float64
 	var startReal time.Time
+	var offsetMs int64
 
 	// Initialize real-time start
 	startReal = time.Now()
 
 	pktCount := 0
+	bytesTotal := binlog.PcapSize(path)
+	bytesRead := int64(pcapGlobalLen)
 
-	for s.running {
+	for s.running.Load() {
 		// Read Record Header
 		if _, err := io.ReadFull(f, bufRec); err != nil {
 			if err == io.EOF {
@@ -90,10 +147,13 @@ func (s *UdpServer) Replay(path string, speed float64) error {
 		tsSec := binary.LittleEndian.Uint32(bufRec[0:4])
 		tsUsec := binary.LittleEndian.Uint32(bufRec[4:8])
 		inclLen := binary.LittleEndian.Uint32(bufRec[8:12])
+		bytesRead += pcapRecordLen + int64(inclLen)
 
 		if inclLen < phdr2Len {
 			// Skip malformed
-			f.Seek(int64(inclLen), io.SeekCurrent)
+			if _, err := io.CopyN(io.Discard, f, int64(inclLen)); err != nil {
+				break
+			}
 			continue
 		}
 
@@ -104,28 +164,39 @@ func (s *UdpServer) Replay(path string, speed float64) error {
 
 		flag := binary.LittleEndian.Uint16(bufPhdr2[0:2])
 		port := binary.LittleEndian.Uint16(bufPhdr2[2:4])
-		ipBytes := bufPhdr2[4:8]
+		ipBytes := net.IP(bufPhdr2[4:8])
 
-		payloadLen := int(inclLen) - phdr2Len
-		payload := make([]byte, payloadLen)
+		payload := make([]byte, int(inclLen)-phdr2Len)
 		if _, err := io.ReadFull(f, payload); err != nil {
 			return fmt.Errorf("read payload: %w", err)
 		}
+		flag, ip6, payload, err := binlog.SplitPhdr2IPv6(flag, payload)
+		if err != nil {
+			continue
+		}
+		if ip6 != nil {
+			ipBytes = ip6
+		}
+		payloadLen := len(payload)
 
 		// Process metadata blocks
 		if flag == flagAnchor {
-			s.parseAnchorBlock(payload, int(port), int(binary.LittleEndian.Uint32(ipBytes)))
+			s.parseAnchorBlock(payload, int(port), int(binary.LittleEndian.Uint32(bufPhdr2[4:8])))
+			continue
+		}
+		if flag == flagTag {
+			s.parseTagBlock(payload, int(port), int(binary.LittleEndian.Uint32(bufPhdr2[4:8])))
 			continue
 		}
-		if flag == flagTag || flag == flagStats {
+		if flag == flagStats {
 			continue
 		}
 
 		pktCount++
 		if pktCount <= 10 {
-			log.Printf("Replay Pkt #%d: TS=%.3f Len=%d Flag=%x IP=%d.%d.%d.%d:%d",
+			logging.Debugf("Replay Pkt #%d: TS=%.3f Len=%d Flag=%x Addr=%s",
 				pktCount, float64(tsSec)+float64(tsUsec)/1e6, payloadLen, flag,
-				ipBytes[0], ipBytes[1], ipBytes[2], ipBytes[3], port)
+				(&net.UDPAddr{IP: ipBytes, Port: int(port)}).String())
 		}
 
 		// Timing logic
@@ -133,6 +204,13 @@ func (s *UdpServer) Replay(path string, speed float64) error {
 		if firstTs == 0 {
 			firstTs = ts
 			startReal = time.Now() // Reset start time to now
+			if s.rebaseTime {
+				base := startReal.UnixMilli()
+				if base < s.replayNextMs {
+					base = s.replayNextMs
+				}
+				offsetMs = base - int64(ts*1000)
+			}
 		} else if speed > 0 {
 			targetDelay := time.Duration((ts - firstTs) / speed * float64(time.Second))
 			elapsed := time.Since(startReal)
@@ -143,17 +221,28 @@ func (s *UdpServer) Replay(path string, speed float64) error {
 
 		// Construct simulated address
 		addr := &net.UDPAddr{
-			IP:   net.IP(ipBytes),
+			IP:   ipBytes,
 			Port: int(port),
 		}
 
 		// Feed to pipeline
-		s.handlePacket(payload, addr, int64(ts*1000))
+		tsMs := int64(ts*1000) + offsetMs
+		s.handlePacket(payload, addr, tsMs)
+		if s.rebaseTime && tsMs >= s.replayNextMs {
+			s.replayNextMs = tsMs + 1
+		}
 
-		if pktCount%1000 == 0 {
-			// log.Printf("Processed %d packets", pktCount)
+		if pktCount%ReplayProgressEvery == 0 {
+			s.reportReplayProgress(ReplayProgress{
+				Packets:    pktCount,
+				BytesRead:  bytesRead,
+				BytesTotal: bytesTotal,
+				FileTime:   ts - firstTs,
+				Elapsed:    time.Since(startReal),
+				Speed:      speed,
+			})
 		}
 	}
-	log.Printf("Replay loop ended. Total Packets: %d", pktCount)
+	logging.Infof("Replay loop ended. Total Packets: %d", pktCount)
 	return nil
 }
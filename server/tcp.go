@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"engine-go/logging"
+)
+
+// StartTCP accepts persistent gateway connections on port and feeds the
+// received UNIB stream through handlePacket, exactly like UDP datagrams.
+// It blocks until Stop is called.
+func (s *UdpServer) StartTCP(port int) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.tcpLn = ln
+	s.mu.Unlock()
+	logging.Infof("TCP Server listening on %s", ln.Addr().String())
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !s.running.Load() {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		go s.serveTCP(conn)
+	}
+}
+
+func (s *UdpServer) serveTCP(conn net.Conn) {
+	// Downlinks and PCAP records are keyed by a UDP-style address so the
+	// rest of the server treats TCP gateways the same as UDP ones.
+	ta, _ := conn.RemoteAddr().(*net.TCPAddr)
+	addr := &net.UDPAddr{}
+	if ta != nil {
+		addr = &net.UDPAddr{IP: ta.IP, Port: ta.Port, Zone: ta.Zone}
+	}
+	key := addr.String()
+
+	s.mu.Lock()
+	s.tcpConns[key] = conn
+	s.mu.Unlock()
+	logging.Infof("TCP gateway connected: %s", key)
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.tcpConns, key)
+		s.mu.Unlock()
+		conn.Close()
+		logging.Infof("TCP gateway disconnected: %s", key)
+	}()
+
+	buf := make([]byte, 0, MaxPacketSize)
+	chunk := make([]byte, MaxPacketSize)
+	for {
+		n, err := conn.Read(chunk)
+		if n > 0 {
+			buf = append(buf, chunk[:n]...)
+			end := completeFrames(buf)
+			if end > 0 {
+				data := make([]byte, end)
+				copy(data, buf[:end])
+				s.forward(data)
+				s.handlePacket(data, addr, time.Now().UnixMilli())
+				buf = append(buf[:0], buf[end:]...)
+			}
+		}
+		if err != nil {
+			if err != io.EOF && s.running.Load() {
+				logging.Warnf("TCP read error from %s: %v", key, err)
+			}
+			return
+		}
+	}
+}
+
+// completeFrames returns the length of the prefix of buf that holds only
+// whole UNIB frames (plus any garbage before them). A trailing partial
+// frame is left for the next read.
+func completeFrames(buf []byte) int {
+	offset, end := 0, 0
+	for len(buf)-offset >= UnibHdrLen {
+		hdr, err := ParseHeader(buf[offset:])
+		if err != nil {
+			offset++
+			end = offset
+			continue
+		}
+		totalLen := UnibWrapLen + hdr.BodyLen
+		if offset+totalLen > len(buf) {
+			break
+		}
+		offset += totalLen
+		end = offset
+	}
+	return end
+}
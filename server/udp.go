@@ -4,16 +4,16 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"log"
-	"math"
 	"net"
 	"os"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"engine-go/binlog"
 	"engine-go/fusion"
+	"engine-go/logging"
 	"engine-go/rbc"
 	"engine-go/web"
 )
@@ -34,35 +34,84 @@ type wsPos struct {
 	Z           float64  `json:"z"`
 	Layer       int      `json:"layer"`
 	Flag        int      `json:"flag"`
+	Quality     int      `json:"quality"`
 	Pressure    *float64 `json:"pressure,omitempty"`
 	Temperature *float64 `json:"temperature,omitempty"`
+	GwID        uint32   `json:"gw_id,omitempty"`
+	GwAddr      string   `json:"gw_addr,omitempty"`
+}
+
+// PacketWriter records raw packets; implemented by binlog.PcapWriter and
+// binlog.RotatingPcapWriter.
+type PacketWriter interface {
+	WritePacket(flag uint16, addr *net.UDPAddr, data []byte) error
+}
+
+// timedPacketWriter is a PacketWriter that can stamp records with a given
+// time; used to re-log rebased replays consistently.
+type timedPacketWriter interface {
+	WritePacketAt(ts time.Time, flag uint16, addr *net.UDPAddr, data []byte) error
 }
 
 type UdpServer struct {
 	conn    *net.UDPConn
-	pcap    *binlog.PcapWriter
+	pcap    PacketWriter
 	sender  *rbc.Sender
 	webHub  *web.Hub
-	running bool
+	running atomic.Bool
+
+	// Replay timestamp rebasing (see SetRebaseTime)
+	rebaseTime   bool
+	replaying    bool
+	replayNextMs int64
 
+	// Replay progress reporting (see SetReplayProgressFunc)
+	replayProgress     func(ReplayProgress)
+	replayProgressBusy atomic.Bool
+
+	// UNIB packets dropped for a bad CRC
+	crcErrors atomic.Uint64
+
+	// Append the fix quality to RBC position messages (see SetRbcQuality)
+	rbcQuality bool
+
+	// Coordinate transform for RBC and CSV output (see SetOutputTransform)
+	outXform fusion.OutputTransform
+
+	// CSV output; csvMu serializes rows from the UDP and TCP goroutines
+	csvMu     sync.Mutex
 	csvFile   *os.File
 	csvWriter *csv.Writer
 
+	// Raw packet tap (optional, see SetForwardAddr)
+	fwd *packetForwarder
+
+	// Lost-tag sweep (optional, see SetIdleTimeout)
+	idle *idleSweeper
+
+	// InfluxDB line-protocol output (optional, see SetInflux)
+	influx *influxWriter
+
+	// TCP input (optional); gateway address -> live connection
+	tcpLn    net.Listener
+	tcpConns map[string]net.Conn
+
+	// Geofence: regions and, per tag, which of them it is currently inside
+	forbidden   []ForbiddenRegion
+	inForbidden map[int]map[int]bool
+	// Zone enter/exit events (optional)
+	geoMon *fusion.GeofenceMonitor
+
 	// Map TagID -> Last Seen Gateway Addr
 	lastGw map[int]*net.UDPAddr
-	// Map TagID -> Last Known Position
-	tagsState map[int]*wsPos
-	// Map TagID -> dedicated fusion pipeline (stateful)
-	pipelines map[int]*fusion.FusionPipeline
-
-	// Shared configuration for constructing pipelines
-	anchors      map[int]fusion.Anchor
-	rssiModel    *fusion.BLERssi
-	dimMap       map[int][]fusion.DimMat
-	beaconLayer  map[int]int
-	beaconDims   map[int][]fusion.DimMat
-	layerManager *fusion.LayerManager
-	mu           sync.Mutex
+	// Map TagID -> UNIB address of the gateway that last relayed it
+	lastGwID map[int]uint32
+	// Map anchor ID -> latest heartbeat
+	anchorStatus map[int]*AnchorStatus
+
+	// Per-tag pipelines and latest positions
+	engine *Engine
+	mu     sync.Mutex
 }
 
 func NewUdpServer(port int, anchors map[int]fusion.Anchor, rssi *fusion.BLERssi, dimMap map[int][]fusion.DimMat, beaconLayer map[int]int, beaconDims map[int][]fusion.DimMat, lm *fusion.LayerManager) (*UdpServer, error) {
@@ -81,26 +130,17 @@ func NewUdpServer(port int, anchors map[int]fusion.Anchor, rssi *fusion.BLERssi,
 	// Set buffer size similar to C++
 	conn.SetReadBuffer(256 * 1024)
 
-	anchCopy := make(map[int]fusion.Anchor, len(anchors))
-	for k, v := range anchors {
-		anchCopy[k] = v
-	}
-
 	return &UdpServer{
-		conn:         conn,
-		lastGw:       make(map[int]*net.UDPAddr),
-		tagsState:    make(map[int]*wsPos),
-		pipelines:    make(map[int]*fusion.FusionPipeline),
-		anchors:      anchCopy,
-		rssiModel:    rssi,
-		dimMap:       dimMap,
-		beaconLayer:  beaconLayer,
-		beaconDims:   beaconDims,
-		layerManager: lm,
+		conn:        conn,
+		tcpConns:    make(map[string]net.Conn),
+		inForbidden: make(map[int]map[int]bool),
+		lastGw:      make(map[int]*net.UDPAddr),
+		lastGwID:    make(map[int]uint32),
+		engine:      NewEngine(anchors, rssi, dimMap, beaconLayer, beaconDims, lm),
 	}, nil
 }
 
-func (s *UdpServer) SetPcapWriter(pw *binlog.PcapWriter) {
+func (s *UdpServer) SetPcapWriter(pw PacketWriter) {
 	s.pcap = pw
 }
 
@@ -114,44 +154,189 @@ func (s *UdpServer) SetCSVWriter(path string) error {
 	return s.csvWriter.Write([]string{"tag_id", "ts", "x", "y", "z", "layer", "flag"})
 }
 
+// SetRbcSender sends RBC messages through snd, formatted by its Formatter
+// (see rbc.Sender.SetFormatter).
 func (s *UdpServer) SetRbcSender(snd *rbc.Sender) {
 	s.sender = snd
 }
 
+// SetRbcQuality appends each fix's 0-100 quality score to RBC position
+// messages as an extra trailing field. Off by default, since receivers
+// expecting the plain position layout would reject the longer record.
+func (s *UdpServer) SetRbcQuality(on bool) {
+	s.rbcQuality = on
+}
+
+// SetTagMotionProfile assigns kinematic limits (e.g. a forklift profile)
+// to one tag. Call before Start.
+func (s *UdpServer) SetTagMotionProfile(tagID int, mp fusion.MotionProfile) {
+	s.engine.SetTagMotionProfile(tagID, mp)
+}
+
+// SetRssiSmoothing enables BLE strength smoothing with time constant tau on
+// tag pipelines. Call before Start.
+func (s *UdpServer) SetRssiSmoothing(tau time.Duration) {
+	s.engine.SetRssiSmoothing(tau)
+}
+
+// SetMaxRange sets the longest TWR range (meters) tag pipelines accept.
+// Call before Start.
+func (s *UdpServer) SetMaxRange(meters float64) {
+	s.engine.SetMaxRange(meters)
+}
+
 func (s *UdpServer) SetWebHub(h *web.Hub) {
 	s.webHub = h
 }
 
-// getPipeline returns a per-tag fusion pipeline, creating one if missing.
-func (s *UdpServer) getPipeline(tagID int) *fusion.FusionPipeline {
-	if p, ok := s.pipelines[tagID]; ok {
-		return p
+// Engine returns the transport-free engine the server feeds.
+func (s *UdpServer) Engine() *Engine {
+	return s.engine
+}
+
+// ExcludeAnchor takes an anchor out of service for all tags.
+func (s *UdpServer) ExcludeAnchor(id int) {
+	s.engine.ExcludeAnchor(id)
+	logging.Infof("Anchor %X excluded", id)
+}
+
+// IncludeAnchor puts an excluded anchor back in service.
+func (s *UdpServer) IncludeAnchor(id int) {
+	s.engine.IncludeAnchor(id)
+	logging.Infof("Anchor %X included", id)
+}
+
+// SetUKF switches tag pipelines to the unscented measurement update.
+func (s *UdpServer) SetUKF(on bool) {
+	s.engine.SetUKF(on)
+}
+
+// SetDeadReckoning makes tags that lose all anchors for gap keep reporting
+// IMU dead-reckoned positions (flag 3) until the reset gap; 0 disables it.
+func (s *UdpServer) SetDeadReckoning(gap time.Duration) {
+	s.engine.SetDeadReckoning(gap)
+}
+
+// SetResetGap sets how long a tag may go without an update before its
+// filter resets (0 = fusion.ResetGap). With predict, longer gaps predict
+// the filter forward instead while the tag is inside the map.
+func (s *UdpServer) SetResetGap(gap time.Duration, predict bool) {
+	s.engine.SetResetGap(gap, predict)
+}
+
+// SetOutputTransform sets the unit scale, origin offset and rotation
+// applied to coordinates in RBC messages (positions and position warnings)
+// and the CSV log. Fusion, geofence tests and the web API stay in map
+// meters, which the web UI draws on, as does InfluxDB output, whose fields
+// are defined in meters.
+func (s *UdpServer) SetOutputTransform(t fusion.OutputTransform) {
+	s.outXform = t
+}
+
+// ReloadConfig swaps a reloaded site configuration into all tag pipelines,
+// keeping their filter state.
+func (s *UdpServer) ReloadConfig(anchors map[int]fusion.Anchor, dimMap map[int][]fusion.DimMat, beaconLayer map[int]int, beaconDims map[int][]fusion.DimMat, lm *fusion.LayerManager) {
+	s.engine.ReloadConfig(anchors, dimMap, beaconLayer, beaconDims, lm)
+}
+
+// ExcludedAnchors lists the anchors currently out of service.
+func (s *UdpServer) ExcludedAnchors() []int {
+	return s.engine.ExcludedAnchors()
+}
+
+// setTagHeight records a tag's mounting height from a pcap tag block.
+func (s *UdpServer) setTagHeight(tagID int, height float64) {
+	s.engine.SetTagHeight(tagID, height)
+}
+
+// SetTagHeights records the mounting heights (m) of tags, keyed by tag id,
+// such as those of fusion.LoadTagHeights. Tags not listed keep their height.
+func (s *UdpServer) SetTagHeights(heights map[int]float64) {
+	for tagID, h := range heights {
+		s.engine.SetTagHeight(tagID, h)
 	}
-	p := fusion.NewFusionPipeline(s.anchors, s.rssiModel, s.dimMap, s.beaconLayer, s.beaconDims, s.layerManager)
-	s.pipelines[tagID] = p
-	return p
+}
+
+// SetDefaultTagHeight sets the mounting height (m) of tags without one of
+// their own (0 = fusion.DefaultTagHeight).
+func (s *UdpServer) SetDefaultTagHeight(height float64) {
+	s.engine.SetDefaultTagHeight(height)
+}
+
+// RbcStats returns the RBC sender counters keyed by target address, or an
+// empty map when no sender is configured.
+func (s *UdpServer) RbcStats() interface{} {
+	if s.sender == nil {
+		return map[string]rbc.SenderStats{}
+	}
+	return s.sender.Stats()
 }
 
 func (s *UdpServer) GetTags() interface{} {
+	positions := s.engine.Positions()
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	tags := make([]*wsPos, 0, len(s.tagsState))
-	for _, t := range s.tagsState {
-		tags = append(tags, t)
+	tags := make([]*wsPos, 0, len(positions))
+	for i := range positions {
+		cp := &positions[i]
+		cp.GwID = s.lastGwID[int(cp.ID)]
+		tags = append(tags, cp)
 	}
 	return tags
 }
 
+// gatewayInfo is one entry of GatewayStats.
+type gatewayInfo struct {
+	Addr string `json:"addr"`
+	Tags int    `json:"tags"`
+}
+
+// GatewayStats lists the distinct gateway addresses that last relayed a
+// packet, with how many positioned tags each currently serves.
+func (s *UdpServer) GatewayStats() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := map[string]int{}
+	for id, addr := range s.lastGw {
+		if addr == nil {
+			continue
+		}
+		key := addr.String()
+		if s.engine.hasTag(id) {
+			counts[key]++
+		} else if _, seen := counts[key]; !seen {
+			counts[key] = 0
+		}
+	}
+	out := make([]gatewayInfo, 0, len(counts))
+	for addr, n := range counts {
+		out = append(out, gatewayInfo{Addr: addr, Tags: n})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Addr < out[j].Addr })
+	return out
+}
+
+// GetGateways returns the learned TagID -> gateway UNIB address mapping.
+func (s *UdpServer) GetGateways() map[int]uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[int]uint32, len(s.lastGwID))
+	for k, v := range s.lastGwID {
+		out[k] = v
+	}
+	return out
+}
+
 func (s *UdpServer) Start() {
-	s.running = true
+	s.running.Store(true)
 	buf := make([]byte, MaxPacketSize)
-	log.Printf("UDP Server listening on %s", s.conn.LocalAddr().String())
+	logging.Infof("UDP Server listening on %s", s.conn.LocalAddr().String())
 
-	for s.running {
+	for s.running.Load() {
 		n, addr, err := s.conn.ReadFromUDP(buf)
 		if err != nil {
-			if s.running {
-				log.Printf("Read error: %v", err)
+			if s.running.Load() {
+				logging.Errorf("Read error: %v", err)
 			}
 			continue
 		}
@@ -161,41 +346,64 @@ func (s *UdpServer) Start() {
 		data := make([]byte, n)
 		copy(data, buf[:n])
 
+		s.forward(data)
 		s.handlePacket(data, addr, time.Now().UnixMilli())
 	}
 }
 
 func (s *UdpServer) Stop() {
-	s.running = false
+	s.running.Store(false)
 	s.conn.Close()
+	s.mu.Lock()
+	if s.tcpLn != nil {
+		s.tcpLn.Close()
+	}
+	for _, c := range s.tcpConns {
+		c.Close()
+	}
+	idle := s.idle
+	s.idle = nil
+	s.mu.Unlock()
+	if idle != nil {
+		idle.stop()
+	}
+	if s.fwd != nil {
+		s.fwd.stop()
+	}
+	if s.influx != nil {
+		s.influx.stop()
+	}
 	if s.csvWriter != nil {
+		s.csvMu.Lock()
 		s.csvWriter.Flush()
 		s.csvFile.Close()
+		s.csvMu.Unlock()
 	}
 }
 
 func (s *UdpServer) SendConfig(tagID int, cmdID int, data []byte) error {
 	s.mu.Lock()
 	addr, ok := s.lastGw[tagID]
+	// Gateways drop downlinks addressed to another gwID. Fall back to 0
+	// only when no LORA raw-data-up frame has been seen for this tag.
+	gwID := s.lastGwID[tagID]
+	var tcpConn net.Conn
+	if ok {
+		tcpConn = s.tcpConns[addr.String()]
+	}
 	s.mu.Unlock()
 
 	if !ok {
 		return fmt.Errorf("gateway for tag %d not found", tagID)
 	}
 
-	// Ideally, we should know the Gateway ID to put in the header.
-	// But we don't track Gateway IDs mapped to IPs yet.
-	// The C++ code uses gwid in PkgingSetTagReq.
-	// If we don't have it, we might use 0 or a dummy.
-	// The Gateway might ignore it or use it.
-	// Let's try to extract GatewayID from incoming packets if possible, but UnibHeader Addr is usually TagID for uplink.
-	// Except for Gateway Heartbeat.
-
-	// For now, use 0 as gwID.
-	gwID := uint32(0)
-
 	pkt := PackageSetTagReq(gwID, uint32(tagID), uint8(cmdID), data)
 
+	// Gateways connected over TCP get the downlink on their own stream
+	if tcpConn != nil {
+		_, err := tcpConn.Write(pkt)
+		return err
+	}
 	_, err := s.conn.WriteToUDP(pkt, addr)
 	return err
 }
@@ -205,65 +413,32 @@ func (s *UdpServer) handleExd(tagID int, ts int64, extra ExdData) {
 		return
 	}
 
-	s.mu.Lock()
-	state, ok := s.tagsState[tagID]
-
-	var posX, posY float64
-	var layer, flag int
-
-	newState := &wsPos{
-		ID: int64(tagID),
-		TS: ts,
-		Z:  0.0,
-	}
-
-	if ok {
-		posX = state.X
-		posY = state.Y
-		layer = state.Layer
-		flag = state.Flag
-
-		// Preserve existing values if new ones are missing
-		newState.Pressure = state.Pressure
-		newState.Temperature = state.Temperature
-	}
-
-	newState.X = posX
-	newState.Y = posY
-	newState.Layer = layer
-	newState.Flag = flag
-
-	if extra.Pressure != nil {
-		newState.Pressure = extra.Pressure
-	}
-	if extra.Temperature != nil {
-		newState.Temperature = extra.Temperature
-	}
-
-	s.tagsState[tagID] = newState
-	s.mu.Unlock()
+	// Keep the last position, refresh the environment readings
+	state := s.engine.annotate(tagID, func(p *wsPos) {
+		p.TS = ts
+		if extra.Pressure != nil {
+			p.Pressure = extra.Pressure
+		}
+		if extra.Temperature != nil {
+			p.Temperature = extra.Temperature
+		}
+	})
 
 	if s.webHub != nil {
-		b, _ := json.Marshal(newState)
-		s.webHub.Broadcast(b)
+		b, _ := json.Marshal(state)
+		s.webHub.BroadcastPos(state.ID, state.Layer, b)
 	}
 }
 
-// addAnchorGlobal updates the shared anchor store and all live pipelines.
+// addAnchorGlobal registers an anchor learned at runtime (e.g. from a
+// PCAP anchor block) with the engine, its layer and all live pipelines.
 func (s *UdpServer) addAnchorGlobal(a fusion.Anchor) {
-	// Update shared anchor map
-	if _, exists := s.anchors[a.ID]; !exists {
-		s.anchors[a.ID] = a
-	} else {
-		// overwrite to keep latest coordinates
-		s.anchors[a.ID] = a
-	}
-	// Push into every active pipeline
-	for _, p := range s.pipelines {
-		if !p.HasAnchor(a.ID) {
-			p.AddAnchor(a)
-		}
-	}
+	s.engine.AddAnchor(a)
+}
+
+// CRCErrors is the number of received UNIB packets dropped for a bad CRC.
+func (s *UdpServer) CRCErrors() uint64 {
+	return s.crcErrors.Load()
 }
 
 func (s *UdpServer) handlePacket(data []byte, addr *net.UDPAddr, ts int64) {
@@ -287,7 +462,23 @@ func (s *UdpServer) handlePacket(data []byte, addr *net.UDPAddr, ts int64) {
 		pktData := data[offset : offset+totalLen]
 
 		if s.pcap != nil {
-			_ = s.pcap.WritePacket(PcapFlag, addr, pktData)
+			if tw, ok := s.pcap.(timedPacketWriter); ok && s.replaying && s.rebaseTime {
+				_ = tw.WritePacketAt(time.UnixMilli(ts), PcapFlag, addr, pktData)
+			} else {
+				_ = s.pcap.WritePacket(PcapFlag, addr, pktData)
+			}
+		}
+
+		// Magic bytes can occur by chance; only decode intact packets.
+		// The capture keeps them for later inspection. The length of a
+		// bad packet is not trusted: resync from the next byte so real
+		// packets it would cover are still found.
+		if !VerifyUnibCRC(pktData, hdr) {
+			if n := s.crcErrors.Add(1); n == 1 || n%100 == 0 {
+				logging.Warnf("Dropped UNIB packet from %s with bad CRC (%d so far)", addr, n)
+			}
+			offset++
+			continue
 		}
 
 		bodyStart := offset + UnibHdrLen
@@ -300,15 +491,61 @@ func (s *UdpServer) handlePacket(data []byte, addr *net.UDPAddr, ts int64) {
 		s.lastGw[tagID] = addr
 		s.mu.Unlock()
 
+		if hdr.Type == TypeLoraRawDataUp {
+			s.trackGateway(hdr, body, addr)
+		}
+
 		s.processInner(hdr, body, ts, 0)
 
 		offset += totalLen
 	}
 }
 
+// rawUpPayload strips the seconds prefix and the deviceID/RSSI preamble from
+// a LORA raw-data-up body, returning the embedded UNIB frames.
+func rawUpPayload(body []byte, flags uint8) []byte {
+	if flags&0x2 != 0 && len(body) > 0 {
+		body = body[1:]
+	}
+	offset := 4
+	if len(body) >= 6 {
+		offset = 6
+	}
+	if len(body) <= offset {
+		return nil
+	}
+	return body[offset:]
+}
+
+// trackGateway records the outer gateway address for every tag carried in a
+// LORA raw-data-up frame so downlinks can be addressed correctly.
+func (s *UdpServer) trackGateway(hdr *UnibHeader, body []byte, addr *net.UDPAddr) {
+	innerPayload := rawUpPayload(body, hdr.Flags)
+	pos := 0
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for pos+UnibWrapLen <= len(innerPayload) {
+		inHdr, err := ParseHeader(innerPayload[pos:])
+		if err != nil {
+			pos++
+			continue
+		}
+		totalLen := UnibWrapLen + inHdr.BodyLen
+		if pos+totalLen > len(innerPayload) {
+			break
+		}
+		tagID := int(inHdr.Addr)
+		s.lastGwID[tagID] = hdr.Addr
+		s.lastGw[tagID] = addr
+		pos += totalLen
+	}
+}
+
 func (s *UdpServer) processInner(hdr *UnibHeader, body []byte, ts int64, parentFlags uint8) {
 	combinedFlags := hdr.Flags | parentFlags
 	realBody := body
+	// The seconds prefix is skipped, not applied: ts stays the arrival
+	// time (see binlog.InnerFrame.SecondsPrefix).
 	if combinedFlags&0x2 != 0 && len(body) > 0 {
 		realBody = body[1:]
 	}
@@ -317,14 +554,7 @@ func (s *UdpServer) processInner(hdr *UnibHeader, body []byte, ts int64, parentF
 
 	switch hdr.Type {
 	case TypeLoraRawDataUp:
-		offset := 4
-		if len(realBody) >= 6 {
-			offset = 6
-		}
-		if len(realBody) <= offset {
-			return
-		}
-		innerPayload := realBody[offset:]
+		innerPayload := rawUpPayload(body, combinedFlags)
 		pos := 0
 		for pos+UnibWrapLen <= len(innerPayload) {
 			inHdr, err := ParseHeader(innerPayload[pos:])
@@ -349,7 +579,7 @@ func (s *UdpServer) processInner(hdr *UnibHeader, body []byte, ts int64, parentF
 			extra := ParseExdEntries(extraBytes)
 			s.feedTwr(tagID, ts, samples, extra)
 		} else {
-			log.Printf("ParseTwrFrame error: %v", err)
+			logging.Warnf("ParseTwrFrame error: %v", err)
 		}
 	case TypeTwrFrameS:
 		samples, extraBytes, err := ParseTwrFrameS(realBody)
@@ -357,7 +587,7 @@ func (s *UdpServer) processInner(hdr *UnibHeader, body []byte, ts int64, parentF
 			extra := ParseExdEntries(extraBytes)
 			s.feedTwr(tagID, ts, samples, extra)
 		} else {
-			log.Printf("ParseTwrFrameS error: %v", err)
+			logging.Warnf("ParseTwrFrameS error: %v", err)
 		}
 	case TypeRssiFrame:
 		samples, extraBytes, err := ParseRssiFrame(realBody)
@@ -365,7 +595,7 @@ func (s *UdpServer) processInner(hdr *UnibHeader, body []byte, ts int64, parentF
 			extra := ParseExdEntries(extraBytes)
 			s.feedRssi(tagID, ts, samples, extra)
 		} else {
-			log.Printf("ParseRssiFrame error: %v", err)
+			logging.Warnf("ParseRssiFrame error: %v", err)
 		}
 	case TypeRssiFrameS:
 		samples, extraBytes, err := ParseRssiFrameS(realBody)
@@ -373,19 +603,32 @@ func (s *UdpServer) processInner(hdr *UnibHeader, body []byte, ts int64, parentF
 			extra := ParseExdEntries(extraBytes)
 			s.feedRssi(tagID, ts, samples, extra)
 		} else {
-			log.Printf("ParseRssiFrameS error: %v", err)
+			logging.Warnf("ParseRssiFrameS error: %v", err)
 		}
 	case TypeImuFrame:
 		imu, extraBytes, err := ParseImuFrame(realBody)
 		if err == nil {
-			p := s.getPipeline(tagID)
-			p.ProcessIMU(ts, imu.DistanceM, imu.YawDeg)
-
 			extra := ParseExdEntries(extraBytes)
-			if extra.Pressure != nil || extra.Temperature != nil {
+			res, pos, ok := s.engine.feedIMU(tagID, ts, imu.DistanceM, imu.YawDeg, fusion.IMUMotion{
+				SpeedMps:     imu.SpeedMps,
+				MotionCode:   imu.MotionCode,
+				YawSigmaCode: imu.YawSigmaCode,
+				DsSigmaCode:  imu.DsSigmaCode,
+			}, s.noteExtra(tagID, extra))
+
+			if ok {
+				s.sendResult(tagID, ts, res, pos)
+			} else if extra.Pressure != nil || extra.Temperature != nil {
 				s.handleExd(tagID, ts, extra)
 			}
 		}
+	case TypeAnchorHeartbeat:
+		hb, err := ParseAnchorHeartbeat(realBody)
+		if err == nil {
+			s.SendAnchorStatus(hb, ts)
+		} else {
+			logging.Warnf("ParseAnchorHeartbeat error: %v", err)
+		}
 	case TypeUpExd:
 		extra := ParseExdEntries(realBody)
 		s.handleExd(tagID, ts, extra)
@@ -400,9 +643,8 @@ func (s *UdpServer) feedTwr(tagID int, ts int64, samples []TwrSample, extra ExdD
 			Range:    smp.RangeM,
 		}
 	}
-	p := s.getPipeline(tagID)
-	res := p.Process(ts, tagID, []fusion.BLEMeas{}, twrMeas, 0.0)
-	s.sendResult(tagID, ts, res, extra)
+	res, pos := s.engine.feed(tagID, ts, nil, twrMeas, s.noteExtra(tagID, extra))
+	s.sendResult(tagID, ts, res, pos)
 }
 
 func (s *UdpServer) feedRssi(tagID int, ts int64, samples []RssiSample, extra ExdData) {
@@ -413,23 +655,37 @@ func (s *UdpServer) feedRssi(tagID int, ts int64, samples []RssiSample, extra Ex
 			RSSIDb:   smp.RSSIDb,
 		}
 	}
-	p := s.getPipeline(tagID)
-	res := p.Process(ts, tagID, bleMeas, []fusion.TWRMeas{}, 0.0)
-	s.sendResult(tagID, ts, res, extra)
+	res, pos := s.engine.feed(tagID, ts, bleMeas, nil, s.noteExtra(tagID, extra))
+	s.sendResult(tagID, ts, res, pos)
 }
 
-func (s *UdpServer) sendResult(tagID int, ts int64, res fusion.FusionResult, extra ExdData) {
-	// Debug logging for large coordinates AND hard safety clamp
-	if math.Abs(res.X) > 1000.0 || math.Abs(res.Y) > 1000.0 {
-		log.Printf("WARNING: Large Coordinate detected! Tag=%x X=%.2f Y=%.2f", tagID, res.X, res.Y)
-		// Drop the point to avoid contaminating downstream outputs
-		res.Flag = -2
-		res.X, res.Y = 0, 0
+// noteExtra returns the update of a tag's recorded state with the
+// environment readings that came with a frame and the gateway it arrived
+// through, applied by the engine together with the fusion result.
+func (s *UdpServer) noteExtra(tagID int, extra ExdData) func(p *wsPos) {
+	s.mu.Lock()
+	gw := s.lastGw[tagID]
+	s.mu.Unlock()
+	return func(p *wsPos) {
+		if extra.Pressure != nil {
+			p.Pressure = extra.Pressure
+		}
+		if extra.Temperature != nil {
+			p.Temperature = extra.Temperature
+		}
+		if gw != nil {
+			p.GwAddr = gw.String()
+		}
 	}
+}
 
+// sendResult fans an engine result out to RBC, CSV, geofencing and web
+// clients. The engine has already recorded it as pos and clamped wild
+// coordinates.
+func (s *UdpServer) sendResult(tagID int, ts int64, res fusion.FusionResult, pos wsPos) {
 	// Debug logging for Replay tracking
 	if res.Flag > 0 && tagID%10 == 0 {
-		// log.Printf("Pos: ID=%x Flag=%d X=%.2f Y=%.2f", tagID, res.Flag, res.X, res.Y)
+		// logging.Debugf("Pos: ID=%x Flag=%d X=%.2f Y=%.2f", tagID, res.Flag, res.X, res.Y)
 	}
 
 	region := 0
@@ -437,52 +693,46 @@ func (s *UdpServer) sendResult(tagID int, ts int64, res fusion.FusionResult, ext
 		region = *res.Layer
 	}
 
+	outX, outY := s.outXform.Apply(res.X, res.Y)
+
 	// Only send valid positions to RBC
 	if res.Flag >= 1 && s.sender != nil {
-		msg := rbc.FormatTagPos(tagID, ts, 0, region, res.X, res.Y, 0.0)
-		s.sender.Send(msg, rbc.FlagPosition)
+		if s.rbcQuality && !s.sender.BinaryMode() {
+			msg := s.sender.Formatter().FormatTagPosQuality(tagID, ts, 0, region, outX, outY, 0.0, res.Quality)
+			s.sender.Send(msg, rbc.FlagPosition)
+		} else {
+			s.sender.SendTagPos(rbc.TagPosMsg{ID: tagID, Ts: ts, Region: region, X: outX, Y: outY, Flag: uint8(res.Flag)})
+		}
+	}
+	if res.Flag >= 1 {
+		s.checkForbidden(tagID, ts, region, res.X, res.Y, outX, outY)
+		s.writeInflux(tagID, ts, res)
+	}
+	if s.geoMon != nil {
+		s.geoMon.Update(tagID, res)
+	}
+	if res.Flag == -2 && s.sender != nil {
+		msg := s.sender.Formatter().FormatWarning(tagID, ts, rbc.WarnFilterReset, "filter reset")
+		s.sender.Send(msg, rbc.FlagWarning)
 	}
 
 	if s.csvWriter != nil {
+		s.csvMu.Lock()
 		s.csvWriter.Write([]string{
 			fmt.Sprintf("%X", tagID),
 			strconv.FormatInt(ts, 10),
-			fmt.Sprintf("%.4f", res.X),
-			fmt.Sprintf("%.4f", res.Y),
+			fmt.Sprintf("%.4f", outX),
+			fmt.Sprintf("%.4f", outY),
 			"0.0",
 			strconv.Itoa(region),
 			strconv.Itoa(res.Flag),
 		})
 		s.csvWriter.Flush()
+		s.csvMu.Unlock()
 	}
 
-	pos := &wsPos{
-		ID:          int64(tagID),
-		TS:          ts,
-		X:           res.X,
-		Y:           res.Y,
-		Z:           0.0,
-		Layer:       region,
-		Flag:        res.Flag,
-		Pressure:    extra.Pressure,
-		Temperature: extra.Temperature,
-	}
-
-	// Update State (Always update, even if invalid/predictive)
-	s.mu.Lock()
-	if oldState, ok := s.tagsState[tagID]; ok {
-		if pos.Pressure == nil {
-			pos.Pressure = oldState.Pressure
-		}
-		if pos.Temperature == nil {
-			pos.Temperature = oldState.Temperature
-		}
-	}
-	s.tagsState[tagID] = pos
-	s.mu.Unlock()
-
 	if s.webHub != nil {
 		b, _ := json.Marshal(pos)
-		s.webHub.Broadcast(b)
+		s.webHub.BroadcastPos(pos.ID, pos.Layer, b)
 	}
 }
@@ -1,11 +1,14 @@
 package web
 
 import (
-	"log"
+	"encoding/json"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"engine-go/logging"
 )
 
 const (
@@ -35,11 +38,99 @@ var upgrader = websocket.Upgrader{
 type Client struct {
 	hub *Hub
 
-	// The websocket connection.
+	// The websocket connection; nil for SSE subscribers (see serveSSE).
 	conn *websocket.Conn
 
 	// Buffered channel of outbound messages.
 	send chan []byte
+
+	// DroppedMessages counts messages discarded because send was full.
+	DroppedMessages atomic.Uint64
+
+	// Active filter; nil means all tags. Replaced wholesale on each
+	// subscribe message so the hub can read it without locking.
+	filter atomic.Pointer[clientFilter]
+}
+
+// clientFilter restricts which updates a client receives. An empty set
+// does not filter on that field.
+type clientFilter struct {
+	tags   map[int64]struct{}
+	layers map[int]struct{}
+}
+
+// subscribeMsg is the client->server control message. Subscribe is either
+// a tag ID list or an object {"tags": [...], "layers": [...]}.
+type subscribeMsg struct {
+	Subscribe    json.RawMessage `json:"subscribe"`
+	SubscribeAll bool            `json:"subscribe_all"`
+}
+
+type subscribeFilter struct {
+	Tags   []int64 `json:"tags"`
+	Layers []int   `json:"layers"`
+}
+
+// wants reports whether the client should receive m. Messages without a
+// layer pass any layer filter.
+func (c *Client) wants(m hubMessage) bool {
+	f := c.filter.Load()
+	if f == nil {
+		return true
+	}
+	if m.tagged && len(f.tags) > 0 {
+		if _, ok := f.tags[m.tagID]; !ok {
+			return false
+		}
+	}
+	if m.hasLayer && len(f.layers) > 0 {
+		if _, ok := f.layers[m.layer]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// handleControl applies a subscription message. A tag list replaces the
+// tag filter, an object replaces whichever of tags/layers it carries.
+// Unknown messages are ignored.
+func (c *Client) handleControl(data []byte) {
+	var msg subscribeMsg
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+	if msg.SubscribeAll {
+		c.filter.Store(nil)
+		return
+	}
+	if len(msg.Subscribe) == 0 {
+		return
+	}
+	var sub subscribeFilter
+	if err := json.Unmarshal(msg.Subscribe, &sub.Tags); err != nil {
+		sub.Tags = nil
+		if err := json.Unmarshal(msg.Subscribe, &sub); err != nil {
+			return
+		}
+	}
+
+	next := &clientFilter{}
+	if cur := c.filter.Load(); cur != nil {
+		*next = *cur
+	}
+	if sub.Tags != nil {
+		next.tags = make(map[int64]struct{}, len(sub.Tags))
+		for _, id := range sub.Tags {
+			next.tags[id] = struct{}{}
+		}
+	}
+	if sub.Layers != nil {
+		next.layers = make(map[int]struct{}, len(sub.Layers))
+		for _, l := range sub.Layers {
+			next.layers[l] = struct{}{}
+		}
+	}
+	c.filter.Store(next)
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -52,14 +143,14 @@ func (c *Client) readPump() {
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
+				logging.Warnf("websocket read error: %v", err)
 			}
 			break
 		}
-		// We ignore incoming messages for now
+		c.handleControl(data)
 	}
 }
 
@@ -100,13 +191,13 @@ func (c *Client) writePump() {
 
 // serveWs handles websocket requests from the peer.
 func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	log.Println("serveWs: Attempting to upgrade connection to WebSocket.")
+	logging.Debugf("serveWs: Attempting to upgrade connection to WebSocket.")
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		logging.Warnf("websocket upgrade failed: %v", err)
 		return
 	}
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256)}
+	client := &Client{hub: hub, conn: conn, send: hub.newSendQueue()}
 	client.hub.register <- client
 
 	// Allow collection of memory referenced by the caller by doing all work in
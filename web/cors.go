@@ -0,0 +1,70 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	corsAllowMethods = "GET, POST, OPTIONS"
+	corsAllowHeaders = "Content-Type"
+)
+
+// SetCORSOrigins allows browser requests from the given origins (e.g.
+// "http://localhost:5173"); "*" allows any origin and is meant for
+// development. Without origins no CORS headers are sent. Call before Start.
+func (s *Server) SetCORSOrigins(origins []string) {
+	s.corsOrigins = nil
+	for _, o := range origins {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			s.corsOrigins = append(s.corsOrigins, o)
+		}
+	}
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for a request
+// from origin, or "" if the origin is not allowed.
+func (s *Server) allowedOrigin(origin string) string {
+	for _, o := range s.corsOrigins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// withCORS adds CORS headers for allowed origins and answers their
+// preflight OPTIONS requests with 204 No Content.
+func (s *Server) withCORS(next http.Handler) http.Handler {
+	if len(s.corsOrigins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		allow := ""
+		if origin != "" {
+			allow = s.allowedOrigin(origin)
+		}
+		if allow == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", allow)
+		h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+		if req := r.Header.Get("Access-Control-Request-Headers"); req != "" {
+			h.Set("Access-Control-Allow-Headers", req)
+		} else {
+			h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,14 +2,38 @@ package web
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
+// DefaultClientBuffer is the number of messages queued per client before
+// the oldest are dropped (see SetClientBuffer).
+const DefaultClientBuffer = 512
+
+// HubStats is a snapshot of the hub's counters. Sent counts messages
+// queued to clients; Dropped counts queued messages discarded to make room
+// for newer ones.
+type HubStats struct {
+	Clients int    `json:"clients"`
+	Sent    uint64 `json:"sent"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// hubMessage is a queued broadcast. Untagged messages go to every client;
+// tagged ones only to clients whose filter admits the tag (and layer).
+type hubMessage struct {
+	tagID    int64
+	tagged   bool
+	layer    int
+	hasLayer bool
+	data     []byte
+}
+
 type Hub struct {
 	// Registered clients.
 	clients map[*Client]bool
 
 	// Inbound messages from the clients.
-	broadcast chan []byte
+	broadcast chan hubMessage
 
 	// Register requests from the clients.
 	register chan *Client
@@ -18,15 +42,69 @@ type Hub struct {
 	unregister chan *Client
 
 	mu sync.Mutex
+
+	// Per-client send buffer size for new clients
+	clientBuffer atomic.Int64
+	sent         atomic.Uint64
+	dropped      atomic.Uint64
 }
 
 func NewHub() *Hub {
-	return &Hub{
-		broadcast:  make(chan []byte),
+	h := &Hub{
+		broadcast:  make(chan hubMessage, 256),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		clients:    make(map[*Client]bool),
 	}
+	h.clientBuffer.Store(DefaultClientBuffer)
+	return h
+}
+
+// SetClientBuffer sets how many messages are queued for each client
+// connecting from now on; n <= 0 restores DefaultClientBuffer.
+func (h *Hub) SetClientBuffer(n int) {
+	if n <= 0 {
+		n = DefaultClientBuffer
+	}
+	h.clientBuffer.Store(int64(n))
+}
+
+// newSendQueue returns a send channel sized for a new client.
+func (h *Hub) newSendQueue() chan []byte {
+	return make(chan []byte, h.clientBuffer.Load())
+}
+
+// Stats returns the current client count and message counters.
+func (h *Hub) Stats() HubStats {
+	h.mu.Lock()
+	n := len(h.clients)
+	h.mu.Unlock()
+	return HubStats{Clients: n, Sent: h.sent.Load(), Dropped: h.dropped.Load()}
+}
+
+// enqueue queues data for client. A slow client's queue is full; its
+// oldest message is dropped rather than the client or the newest update.
+func (h *Hub) enqueue(client *Client, data []byte) {
+	select {
+	case client.send <- data:
+		h.sent.Add(1)
+		return
+	default:
+	}
+	select {
+	case <-client.send:
+		client.DroppedMessages.Add(1)
+		h.dropped.Add(1)
+	default:
+	}
+	select {
+	case client.send <- data:
+		h.sent.Add(1)
+	default:
+		// The writer never drains; drop the newest as well.
+		client.DroppedMessages.Add(1)
+		h.dropped.Add(1)
+	}
 }
 
 func (h *Hub) Run() {
@@ -46,18 +124,29 @@ func (h *Hub) Run() {
 		case message := <-h.broadcast:
 			h.mu.Lock()
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+				if !client.wants(message) {
+					continue
 				}
+				h.enqueue(client, message.data)
 			}
 			h.mu.Unlock()
 		}
 	}
 }
 
+// Broadcast sends msg to every connected client regardless of subscriptions.
 func (h *Hub) Broadcast(msg []byte) {
-	h.broadcast <- msg
+	h.broadcast <- hubMessage{data: msg}
+}
+
+// BroadcastTag sends msg only to clients subscribed to tagID (or to all
+// tags). Filtering happens in Run, so the caller never waits on client state.
+func (h *Hub) BroadcastTag(tagID int64, msg []byte) {
+	h.broadcast <- hubMessage{tagID: tagID, tagged: true, data: msg}
+}
+
+// BroadcastPos is BroadcastTag for a position update on layer, so clients
+// subscribed to specific layers can filter it too.
+func (h *Hub) BroadcastPos(tagID int64, layer int, msg []byte) {
+	h.broadcast <- hubMessage{tagID: tagID, tagged: true, layer: layer, hasLayer: true, data: msg}
 }
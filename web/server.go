@@ -4,10 +4,12 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+
+	"engine-go/binlog"
+	"engine-go/logging"
 )
 
 type DownlinkHandler interface {
@@ -18,10 +20,41 @@ type TagProvider interface {
 	GetTags() interface{}
 }
 
+// RbcStatsProvider reports RBC sender counters (see rbc.Sender.Stats).
+type RbcStatsProvider interface {
+	RbcStats() interface{}
+}
+
+// GatewayProvider lists gateways and how many tags each serves.
+type GatewayProvider interface {
+	GatewayStats() interface{}
+}
+
+// AnchorStatusProvider reports each anchor's latest heartbeat (signal
+// quality, battery, temperature).
+type AnchorStatusProvider interface {
+	AnchorStatuses() interface{}
+}
+
+// AnchorController takes anchors in and out of service at runtime.
+type AnchorController interface {
+	ExcludeAnchor(id int)
+	IncludeAnchor(id int)
+	ExcludedAnchors() []int
+}
+
 type Server struct {
-	Hub             *Hub
-	DownlinkHandler DownlinkHandler
-	TagProvider     TagProvider
+	Hub              *Hub
+	DownlinkHandler  DownlinkHandler
+	TagProvider      TagProvider
+	RbcStatsProvider RbcStatsProvider
+	GatewayProvider  GatewayProvider
+	AnchorController AnchorController
+
+	AnchorStatusProvider AnchorStatusProvider
+
+	// Origins allowed cross-origin access (see SetCORSOrigins)
+	corsOrigins []string
 }
 
 func NewServer() *Server {
@@ -38,6 +71,22 @@ func (s *Server) SetTagProvider(p TagProvider) {
 	s.TagProvider = p
 }
 
+func (s *Server) SetRbcStatsProvider(p RbcStatsProvider) {
+	s.RbcStatsProvider = p
+}
+
+func (s *Server) SetGatewayProvider(p GatewayProvider) {
+	s.GatewayProvider = p
+}
+
+func (s *Server) SetAnchorController(c AnchorController) {
+	s.AnchorController = c
+}
+
+func (s *Server) SetAnchorStatusProvider(p AnchorStatusProvider) {
+	s.AnchorStatusProvider = p
+}
+
 func (s *Server) Start(port int, distDir string, configDir string) {
 	go s.Hub.Run()
 
@@ -48,9 +97,20 @@ func (s *Server) Start(port int, distDir string, configDir string) {
 		serveWs(s.Hub, w, r)
 	})
 
+	// Server-Sent Events fallback for clients that cannot use WebSocket
+	// (e.g. behind proxies that strip the Upgrade header)
+	mux.HandleFunc("/api/v1/positions/stream", s.handleStream)
+	mux.HandleFunc("/api/stream", s.handleStream)
+
 	// API
 	mux.HandleFunc("/api/lora/config", s.handleLoraConfig)
 	mux.HandleFunc("/api/tags", s.handleGetTags)
+	mux.HandleFunc("/api/v1/rbc/stats", s.handleRbcStats)
+	mux.HandleFunc("/api/v1/gateways", s.handleGateways)
+	mux.HandleFunc("GET /api/v1/ws/stats", s.handleWsStats)
+	mux.HandleFunc("GET /api/v1/anchors", s.handleAnchors)
+	mux.HandleFunc("POST /api/v1/anchors/{id}/exclude", s.handleAnchorExclude)
+	mux.HandleFunc("POST /api/v1/anchors/{id}/include", s.handleAnchorInclude)
 
 	// Config Files
 	if configDir != "" {
@@ -75,18 +135,54 @@ func (s *Server) Start(port int, distDir string, configDir string) {
 	}
 
 	addr := fmt.Sprintf(":%d", port)
-	log.Printf("HTTP Server listening on %s", addr)
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		log.Fatalf("HTTP server error: %v", err)
+	logging.Infof("HTTP Server listening on %s", addr)
+	if err := http.ListenAndServe(addr, s.withCORS(mux)); err != nil {
+		logging.Errorf("HTTP server error: %v", err)
+		os.Exit(1)
 	}
 }
 
+// handleStream serves the /ws feed as Server-Sent Events.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	serveSSE(s.Hub, w, r)
+}
+
+// ConfigRequest names the tag by exactly one of TagID (decimal) or TagHex
+// (e.g. "B50AC").
 type ConfigRequest struct {
-	TagID   int    `json:"tag_id"`
+	TagID   *int   `json:"tag_id"`
+	TagHex  string `json:"tag_hex"`
 	CmdID   int    `json:"cmd_id"`
 	DataHex string `json:"data_hex"` // Hex encoded data
 }
 
+// ConfigResponse echoes the resolved decimal tag ID.
+type ConfigResponse struct {
+	Status string `json:"status"`
+	TagID  int    `json:"tag_id"`
+}
+
+// tagID resolves the request's tag from tag_id or tag_hex.
+func (req ConfigRequest) tagID() (int, error) {
+	switch {
+	case req.TagID != nil && req.TagHex != "":
+		return 0, fmt.Errorf("give only one of tag_id or tag_hex")
+	case req.TagID != nil:
+		return *req.TagID, nil
+	case req.TagHex != "":
+		id, err := binlog.ParseHexID(req.TagHex)
+		if err != nil {
+			return 0, fmt.Errorf("invalid tag_hex %q", req.TagHex)
+		}
+		return id, nil
+	}
+	return 0, fmt.Errorf("tag_id or tag_hex required")
+}
+
 func (s *Server) handleLoraConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -104,19 +200,25 @@ func (s *Server) handleLoraConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tagID, err := req.tagID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	data, err := hex.DecodeString(req.DataHex)
 	if err != nil {
 		http.Error(w, "Invalid DataHex", http.StatusBadRequest)
 		return
 	}
 
-	if err := s.DownlinkHandler.SendConfig(req.TagID, req.CmdID, data); err != nil {
+	if err := s.DownlinkHandler.SendConfig(tagID, req.CmdID, data); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to send config: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConfigResponse{Status: "OK", TagID: tagID})
 }
 
 func (s *Server) handleGetTags(w http.ResponseWriter, r *http.Request) {
@@ -128,4 +230,77 @@ func (s *Server) handleGetTags(w http.ResponseWriter, r *http.Request) {
 	tags := s.TagProvider.GetTags()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(tags)
-}
\ No newline at end of file
+}
+
+func (s *Server) handleRbcStats(w http.ResponseWriter, r *http.Request) {
+	if s.RbcStatsProvider == nil {
+		http.Error(w, "RBC stats provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	stats := s.RbcStatsProvider.RbcStats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (s *Server) handleGateways(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.GatewayProvider == nil {
+		http.Error(w, "Gateway provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	gws := s.GatewayProvider.GatewayStats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gws)
+}
+
+// handleWsStats returns the WebSocket/SSE hub counters.
+func (s *Server) handleWsStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Hub.Stats())
+}
+
+// handleAnchors returns the latest heartbeat of every anchor.
+func (s *Server) handleAnchors(w http.ResponseWriter, r *http.Request) {
+	if s.AnchorStatusProvider == nil {
+		http.Error(w, "Anchor status provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.AnchorStatusProvider.AnchorStatuses())
+}
+
+// handleAnchorExclude takes the anchor {id} (hex) out of service and
+// returns the excluded set.
+func (s *Server) handleAnchorExclude(w http.ResponseWriter, r *http.Request) {
+	s.handleAnchorService(w, r, true)
+}
+
+// handleAnchorInclude puts the anchor {id} (hex) back in service.
+func (s *Server) handleAnchorInclude(w http.ResponseWriter, r *http.Request) {
+	s.handleAnchorService(w, r, false)
+}
+
+func (s *Server) handleAnchorService(w http.ResponseWriter, r *http.Request, exclude bool) {
+	if s.AnchorController == nil {
+		http.Error(w, "Anchor controller not configured", http.StatusServiceUnavailable)
+		return
+	}
+	id, err := binlog.ParseHexID(r.PathValue("id"))
+	if err != nil || id < 0 {
+		http.Error(w, "Invalid anchor id", http.StatusBadRequest)
+		return
+	}
+	if exclude {
+		s.AnchorController.ExcludeAnchor(id)
+	} else {
+		s.AnchorController.IncludeAnchor(id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]int{"excluded": s.AnchorController.ExcludedAnchors()})
+}
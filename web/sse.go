@@ -0,0 +1,110 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseRetryMs is the reconnection delay advertised to EventSource clients.
+const sseRetryMs = 3000
+
+// serveSSE streams hub broadcasts as Server-Sent Events for browsers that
+// cannot open a WebSocket. The stream registers a connection-less Client
+// with the hub, so broadcast and filtering are shared with /ws. Optional
+// ?tags=1,2&layers=3 query parameters set the initial filter, like a
+// subscribe message would.
+func serveSSE(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	client := &Client{hub: hub, send: hub.newSendQueue()}
+	if f := sseFilter(r); f != nil {
+		client.filter.Store(f)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	h.Set("Pragma", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("retry: " + strconv.Itoa(sseRetryMs) + "\n\n"))
+	flusher.Flush()
+
+	hub.register <- client
+	defer func() {
+		hub.unregister <- client
+	}()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case message, ok := <-client.send:
+			if !ok {
+				// The hub dropped us (slow consumer); the client reconnects.
+				return
+			}
+			if _, err := w.Write(sseEvent(message)); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			// Comment line keeps idle proxies from closing the stream.
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// sseEvent frames one message as an SSE data event. Payloads are single-line
+// JSON, but embedded newlines are split into data lines to stay valid.
+func sseEvent(msg []byte) []byte {
+	lines := strings.Split(string(msg), "\n")
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString("data: ")
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+// sseFilter builds a client filter from the tags/layers query parameters,
+// or nil when neither is given.
+func sseFilter(r *http.Request) *clientFilter {
+	q := r.URL.Query()
+	tags, layers := q.Get("tags"), q.Get("layers")
+	if tags == "" && layers == "" {
+		return nil
+	}
+	f := &clientFilter{}
+	for _, s := range strings.Split(tags, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+			if f.tags == nil {
+				f.tags = map[int64]struct{}{}
+			}
+			f.tags[id] = struct{}{}
+		}
+	}
+	for _, s := range strings.Split(layers, ",") {
+		if l, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+			if f.layers == nil {
+				f.layers = map[int]struct{}{}
+			}
+			f.layers[l] = struct{}{}
+		}
+	}
+	return f
+}